@@ -22,6 +22,16 @@ type atomicPointer[T any] struct {
 	ptr unsafe.Pointer
 }
 
+type atomicInt64 struct {
+	_ noCopy
+	v int64
+}
+
+type atomicUint64 struct {
+	_ noCopy
+	v uint64
+}
+
 type atomicUintptr struct {
 	_   noCopy
 	ptr uintptr
@@ -35,6 +45,19 @@ func (u *atomicUint32) CompareAndSwap(old, new uint32) bool {
 	return atomic.CompareAndSwapUint32(&u.v, old, new)
 }
 
+func (i *atomicInt64) Load() int64   { return atomic.LoadInt64(&i.v) }
+func (i *atomicInt64) Store(v int64) { atomic.StoreInt64(&i.v, v) }
+func (i *atomicInt64) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64(&i.v, old, new)
+}
+
+func (u *atomicUint64) Load() uint64            { return atomic.LoadUint64(&u.v) }
+func (u *atomicUint64) Store(v uint64)          { atomic.StoreUint64(&u.v, v) }
+func (u *atomicUint64) Add(delta uint64) uint64 { return atomic.AddUint64(&u.v, delta) }
+func (u *atomicUint64) CompareAndSwap(old, new uint64) bool {
+	return atomic.CompareAndSwapUint64(&u.v, old, new)
+}
+
 func (p *atomicPointer[T]) Load() *T     { return (*T)(atomic.LoadPointer(&p.ptr)) }
 func (p *atomicPointer[T]) Store(v *T)   { atomic.StorePointer(&p.ptr, unsafe.Pointer(v)) }
 func (p *atomicPointer[T]) Swap(v *T) *T { return (*T)(atomic.SwapPointer(&p.ptr, unsafe.Pointer(v))) }
@@ -23,6 +23,14 @@ func setupHaxMap() *haxmap.Map[uintptr, uintptr] {
 	return m
 }
 
+func setupFlatMap() *haxmap.FlatMap[uintptr, uintptr] {
+	m := haxmap.NewFlat[uintptr, uintptr](mapSize)
+	for i := uintptr(0); i < epochs; i++ {
+		m.Set(i, i)
+	}
+	return m
+}
+
 func setupGoSyncMap() *sync.Map {
 	m := &sync.Map{}
 	for i := uintptr(0); i < epochs; i++ {
@@ -87,6 +95,46 @@ func BenchmarkHaxMapReadsWithWrites(b *testing.B) {
 	})
 }
 
+func BenchmarkFlatMapReadsOnly(b *testing.B) {
+	m := setupFlatMap()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for i := uintptr(0); i < epochs; i++ {
+				j, _ := m.Get(i)
+				if j != i {
+					b.Fail()
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkFlatMapReadsWithWrites(b *testing.B) {
+	m := setupFlatMap()
+	var writer uintptr
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// use 1 thread as writer
+		if atomic.CompareAndSwapUintptr(&writer, 0, 1) {
+			for pb.Next() {
+				for i := uintptr(0); i < epochs; i++ {
+					m.Set(i, i)
+				}
+			}
+		} else {
+			for pb.Next() {
+				for i := uintptr(0); i < epochs; i++ {
+					j, _ := m.Get(i)
+					if j != i {
+						b.Fail()
+					}
+				}
+			}
+		}
+	})
+}
+
 func BenchmarkGoSyncMapReadsOnly(b *testing.B) {
 	m := setupGoSyncMap()
 	b.ResetTimer()
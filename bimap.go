@@ -0,0 +1,133 @@
+package haxmap
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrDuplicateValue is returned by BiMap.Put when the value is already bound to another key
+var ErrDuplicateValue = errors.New("haxmap: value already bound to another key")
+
+type (
+	// BiMap implements a concurrent bidirectional map where both keys and values are unique
+	// Lookups in either direction are lock-free whereas updates are serialized
+	// A pair is visible only once both the forward and the backward index point to it, hence readers never
+	// observe a pair in one direction only even while it is being inserted, replaced or deleted
+	BiMap[K, V hashable] struct {
+		mu       sync.Mutex // serializes writers
+		forward  *Map[K, *biPair[K, V]]
+		backward *Map[V, *biPair[K, V]]
+	}
+
+	// biPair is shared by both indexes of a BiMap
+	biPair[K, V hashable] struct {
+		key   K
+		value V
+	}
+)
+
+// NewBiMap returns a new BiMap instance with an optional specific initialization size
+func NewBiMap[K, V hashable](size ...uintptr) *BiMap[K, V] {
+	return &BiMap[K, V]{
+		forward:  New[K, *biPair[K, V]](size...),
+		backward: New[V, *biPair[K, V]](size...),
+	}
+}
+
+// GetByKey retrieves the value bound to the key
+// returns `false` if the key is absent
+func (b *BiMap[K, V]) GetByKey(key K) (value V, ok bool) {
+	if pair, found := b.forward.Get(key); found && b.visible(pair) {
+		return pair.value, true
+	}
+	return
+}
+
+// GetByValue retrieves the key bound to the value
+// returns `false` if the value is absent
+func (b *BiMap[K, V]) GetByValue(value V) (key K, ok bool) {
+	if pair, found := b.backward.Get(value); found && b.visible(pair) {
+		return pair.key, true
+	}
+	return
+}
+
+// Put binds the key to the value, replacing the previous value of the key if any
+// It returns ErrDuplicateValue without modifying the map if the value is already bound to another key
+func (b *BiMap[K, V]) Put(key K, value V) error {
+	return b.put(key, value, false)
+}
+
+// ForcePut binds the key to the value like Put but unbinds the value from any other key instead of failing
+func (b *BiMap[K, V]) ForcePut(key K, value V) {
+	_ = b.put(key, value, true)
+}
+
+// DelByKey deletes the key along with its value
+func (b *BiMap[K, V]) DelByKey(key K) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pair, ok := b.forward.Get(key); ok {
+		b.backward.Del(pair.value) // hides the pair from both directions
+		b.forward.Del(key)
+	}
+}
+
+// DelByValue deletes the value along with its key
+func (b *BiMap[K, V]) DelByValue(value V) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pair, ok := b.backward.Get(value); ok {
+		b.forward.Del(pair.key) // hides the pair from both directions
+		b.backward.Del(value)
+	}
+}
+
+// Len returns the number of pairs within the map
+func (b *BiMap[K, V]) Len() uintptr {
+	return b.forward.Len()
+}
+
+// ForEach iterates over the pairs and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+func (b *BiMap[K, V]) ForEach(lambda func(K, V) bool) {
+	b.forward.ForEach(func(_ K, pair *biPair[K, V]) bool {
+		if !b.visible(pair) {
+			return true
+		}
+		return lambda(pair.key, pair.value)
+	})
+}
+
+func (b *BiMap[K, V]) put(key K, value V, force bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if other, ok := b.backward.Get(value); ok {
+		if other.key == key {
+			return nil // already bound
+		}
+		if !force {
+			return ErrDuplicateValue
+		}
+		b.forward.Del(other.key) // hides the other pair, its backward entry is overwritten below
+	}
+	pair := &biPair[K, V]{key: key, value: value}
+	old, replaced := b.forward.Get(key)
+	// the pair becomes visible once the forward index points to it which atomically hides the old pair
+	b.backward.Set(value, pair)
+	b.forward.Set(key, pair)
+	if replaced {
+		b.backward.Del(old.value)
+	}
+	return nil
+}
+
+// visible reports whether both indexes point to the pair
+func (b *BiMap[K, V]) visible(pair *biPair[K, V]) bool {
+	forward, ok := b.forward.Get(pair.key)
+	if !ok || forward != pair {
+		return false
+	}
+	backward, ok := b.backward.Get(pair.value)
+	return ok && backward == pair
+}
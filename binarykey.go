@@ -0,0 +1,69 @@
+package haxmap
+
+import "encoding"
+
+type (
+	// BinaryKeyMap is a concurrent hashmap keyed by types implementing encoding.BinaryMarshaler like time.Time or netip.Addr
+	// Keys are hashed and compared by their marshaled bytes hence two keys are the same if they marshal to equal bytes
+	BinaryKeyMap[K encoding.BinaryMarshaler, V any] struct {
+		m *Map[string, binaryEntry[K, V]]
+	}
+
+	// entry of a BinaryKeyMap holding the original key along with the value
+	binaryEntry[K encoding.BinaryMarshaler, V any] struct {
+		key   K
+		value V
+	}
+)
+
+// NewBinaryKeyMap returns a new BinaryKeyMap instance with an optional specific initialization size
+func NewBinaryKeyMap[K encoding.BinaryMarshaler, V any](size ...uintptr) *BinaryKeyMap[K, V] {
+	return &BinaryKeyMap[K, V]{m: New[string, binaryEntry[K, V]](size...)}
+}
+
+// Get retrieves an element from the map
+// returns `false` if element is absent or if the key fails to marshal
+func (b *BinaryKeyMap[K, V]) Get(key K) (value V, ok bool) {
+	data, err := key.MarshalBinary()
+	if err != nil {
+		return
+	}
+	entry, ok := GetBytes(b.m, data)
+	return entry.value, ok
+}
+
+// Set tries to update an element if key is present else it inserts a new element
+// returns the error of the key marshaler if any
+func (b *BinaryKeyMap[K, V]) Set(key K, value V) error {
+	data, err := key.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	SetBytes(b.m, data, binaryEntry[K, V]{key: key, value: value})
+	return nil
+}
+
+// Del deletes a key from the map, keys failing to marshal are never present hence ignored
+func (b *BinaryKeyMap[K, V]) Del(key K) {
+	if data, err := key.MarshalBinary(); err == nil {
+		DelBytes(b.m, data)
+	}
+}
+
+// ForEach iterates over key-value pairs and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+func (b *BinaryKeyMap[K, V]) ForEach(lambda func(K, V) bool) {
+	b.m.ForEach(func(_ string, entry binaryEntry[K, V]) bool {
+		return lambda(entry.key, entry.value)
+	})
+}
+
+// Len returns the number of key-value pairs within the map
+func (b *BinaryKeyMap[K, V]) Len() uintptr {
+	return b.m.Len()
+}
+
+// Clear removes all entries from the map
+func (b *BinaryKeyMap[K, V]) Clear() {
+	b.m.Clear()
+}
@@ -0,0 +1,59 @@
+package haxmap
+
+import "unsafe"
+
+// GetBytes retrieves an element of a string keyed map by a key given as a byte slice without allocating a string
+// returns `false` if element is absent
+// If a loader was set via SetLoader, a miss loads the value with a copy of the key like Get
+func GetBytes[K ~string, V any](m *Map[K, V], key []byte) (value V, ok bool) {
+	m.initialize()
+	view := K(bytesView(key))
+	if elem := m.probe(view); elem != nil {
+		return *m.valueOf(elem), true
+	}
+	if m.loader != nil {
+		var err error
+		value, err = m.load(K(key), m.loader)
+		return value, err == nil
+	}
+	return
+}
+
+// SetBytes sets the value of a key given as a byte slice in a string keyed map
+// The key is only copied into a string when it is inserted, updating an existing key does not allocate a string
+func SetBytes[K ~string, V any](m *Map[K, V], key []byte, value V) {
+	m.initialize()
+	view := K(bytesView(key))
+	if elem := m.lookup(m.hasher(view), view); elem != nil && elem.refresh(0) { // clears the expiry like Set
+		if old := elem.store(&value, m.tomb); old != nil {
+			m.changed(elem)
+			m.notify(elem, old, &value)
+			return
+		}
+	}
+	m.Set(K(key), value)
+}
+
+// DelBytes deletes a key given as a byte slice from a string keyed map without allocating a string
+func DelBytes[K ~string, V any](m *Map[K, V], key []byte) {
+	m.Del(K(bytesView(key)))
+}
+
+// lookup returns the element of the key or nil if the key is absent
+func (m *Map[K, V]) lookup(h uintptr, key K) *element[K, V] {
+	for elem := m.seek(m.metadata.Load(), h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+		if m.equal(elem.key, key) {
+			if elem.isDeleted() || !m.live(elem) {
+				return nil
+			}
+			return elem
+		}
+	}
+	return nil
+}
+
+// bytesView returns a string sharing the memory of the byte slice
+// the string must not be retained beyond the call as the bytes may be modified afterwards
+func bytesView(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
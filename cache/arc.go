@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+type (
+	// ARC implements a concurrent adaptive replacement cache of fixed capacity
+	// Resident entries are split into those used once recently (t1) and those used at least twice (t2), and the keys
+	// recently evicted from each part are remembered as ghosts (b1 and b2) without their values
+	// A miss on a ghost key shifts the target size of t1, hence the cache self-tunes between recency and frequency
+	// and scans of one-off keys only churn t1 instead of flushing the frequently used entries
+	// Like LRU, lookups go through a haxmap and never block, accesses which cannot acquire the mutex are not recorded
+	ARC[K comparable, V any] struct {
+		stats    counters
+		items    *haxmap.Map[K, *arcEntry[K, V]] // resident entries of t1 and t2
+		mu       sync.Mutex                      // guards the lists, the ghosts and the target
+		ghosts   map[K]*arcEntry[K, V]           // ghost entries of b1 and b2
+		t1, t2   arcList[K, V]
+		b1, b2   arcList[K, V]
+		target   uintptr // adaptive target size of t1
+		capacity uintptr
+		loads    flight[K, V]
+	}
+
+	// entry of an ARC, the value of a resident entry is immutable once inserted
+	arcEntry[K comparable, V any] struct {
+		key        K
+		value      V
+		list       *arcList[K, V] // list holding the entry, nil once removed
+		prev, next *arcEntry[K, V]
+	}
+
+	// arcList is a circular list ordered from the most to the least recently used entry
+	arcList[K comparable, V any] struct {
+		root arcEntry[K, V]
+		len  uintptr
+	}
+)
+
+// NewARC returns a new ARC cache holding at most capacity entries, a capacity of 0 is treated as 1
+func NewARC[K comparable, V any](capacity uintptr) *ARC[K, V] {
+	if capacity == 0 {
+		capacity = 1
+	}
+	c := &ARC[K, V]{
+		items:    haxmap.New[K, *arcEntry[K, V]](),
+		ghosts:   make(map[K]*arcEntry[K, V]),
+		capacity: capacity,
+	}
+	for _, list := range []*arcList[K, V]{&c.t1, &c.t2, &c.b1, &c.b2} {
+		list.init()
+	}
+	return c
+}
+
+// Get retrieves the value of the key and promotes it to the frequently used entries
+// returns `false` if the key is absent
+func (c *ARC[K, V]) Get(key K) (value V, ok bool) {
+	entry, ok := c.items.Get(key)
+	c.stats.lookup(ok)
+	if !ok {
+		return
+	}
+	if c.mu.TryLock() {
+		if entry.list == &c.t1 || entry.list == &c.t2 { // else evicted or replaced concurrently
+			entry.list.remove(entry)
+			c.t2.pushFront(entry)
+		}
+		c.mu.Unlock()
+	}
+	return entry.value, true
+}
+
+// Peek retrieves the value of the key without recording the access
+func (c *ARC[K, V]) Peek(key K) (value V, ok bool) {
+	if entry, found := c.items.Get(key); found {
+		return entry.value, true
+	}
+	return
+}
+
+// Set inserts or updates the value of the key, evicting entries if the cache is full
+func (c *ARC[K, V]) Set(key K, value V) {
+	entry := &arcEntry[K, V]{key: key, value: value}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.items.Get(key); ok {
+		// hit, the updated entry is used at least twice
+		old.list.remove(old)
+		c.t2.pushFront(entry)
+		c.items.Set(key, entry)
+		return
+	}
+
+	if ghost, ok := c.ghosts[key]; ok {
+		// miss on a recently evicted key, adapt the target towards the part it was evicted from
+		if ghost.list == &c.b1 {
+			c.target = minUintptr(c.capacity, c.target+maxUintptr(c.b2.len/c.b1.len, 1))
+		} else {
+			c.target -= minUintptr(c.target, maxUintptr(c.b1.len/c.b2.len, 1))
+		}
+		c.replace(ghost.list == &c.b2)
+		ghost.list.remove(ghost)
+		delete(c.ghosts, key)
+		c.t2.pushFront(entry)
+		c.items.Set(key, entry)
+		return
+	}
+
+	// complete miss
+	switch l1 := c.t1.len + c.b1.len; {
+	case l1 >= c.capacity:
+		if c.t1.len < c.capacity {
+			c.dropGhost(&c.b1)
+			c.replace(false)
+		} else {
+			c.evict(c.t1.back()) // t1 alone fills the cache, its entry is dropped without ghost
+		}
+	case l1+c.t2.len+c.b2.len >= c.capacity:
+		if l1+c.t2.len+c.b2.len >= 2*c.capacity {
+			c.dropGhost(&c.b2)
+		}
+		c.replace(false)
+	}
+	c.t1.pushFront(entry)
+	c.items.Set(key, entry)
+}
+
+// GetOrLoad retrieves the value of the key, loading and storing it upon a miss
+// Concurrent misses for the same key result in a single loader call whose result is shared by all callers
+// Errors of the loader are returned as is and not cached
+func (c *ARC[K, V]) GetOrLoad(key K, loader func(K) (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	return c.loads.do(key, func() (V, error) {
+		if value, ok := c.Peek(key); ok { // loaded by a call which just finished
+			return value, nil
+		}
+		value, err := loader(key)
+		c.stats.load(err)
+		if err == nil {
+			c.Set(key, value)
+		}
+		return value, err
+	})
+}
+
+// Del deletes the keys from the cache, including their ghosts
+func (c *ARC[K, V]) Del(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if entry, ok := c.items.GetAndDel(key); ok {
+			entry.list.remove(entry)
+		} else if ghost, ok := c.ghosts[key]; ok {
+			ghost.list.remove(ghost)
+			delete(c.ghosts, key)
+		}
+	}
+}
+
+// Len returns the number of resident entries within the cache
+func (c *ARC[K, V]) Len() uintptr {
+	return c.items.Len()
+}
+
+// Purge removes all entries and ghosts from the cache and resets its adaptation
+func (c *ARC[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items.Clear()
+	c.ghosts = make(map[K]*arcEntry[K, V])
+	for _, list := range []*arcList[K, V]{&c.t1, &c.t2, &c.b1, &c.b2} {
+		// detach every entry as concurrent lookups might still hold them
+		for entry := list.root.next; entry != &list.root; {
+			next := entry.next
+			entry.list, entry.prev, entry.next = nil, nil, nil
+			entry = next
+		}
+		list.init()
+	}
+	c.target = 0
+}
+
+// Stats returns a snapshot of the counters of the cache
+func (c *ARC[K, V]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// replace evicts the least recently used entry of t1 or t2 depending on the target and remembers it as a ghost
+func (c *ARC[K, V]) replace(inB2 bool) {
+	if c.t1.len+c.t2.len < c.capacity {
+		return // room left after deletions
+	}
+	if c.t1.len > 0 && (c.t1.len > c.target || (inB2 && c.t1.len == c.target) || c.t2.len == 0) {
+		c.remember(&c.b1, c.evict(c.t1.back()))
+	} else if c.t2.len > 0 {
+		c.remember(&c.b2, c.evict(c.t2.back()))
+	}
+}
+
+// evict removes a resident entry from the cache and returns a ghost of it without its value
+func (c *ARC[K, V]) evict(entry *arcEntry[K, V]) *arcEntry[K, V] {
+	entry.list.remove(entry)
+	c.items.Del(entry.key)
+	c.stats.evict(1)
+	return &arcEntry[K, V]{key: entry.key}
+}
+
+func (c *ARC[K, V]) remember(list *arcList[K, V], ghost *arcEntry[K, V]) {
+	list.pushFront(ghost)
+	c.ghosts[ghost.key] = ghost
+}
+
+// dropGhost forgets the least recently evicted ghost of the list
+func (c *ARC[K, V]) dropGhost(list *arcList[K, V]) {
+	if ghost := list.back(); ghost != nil {
+		list.remove(ghost)
+		delete(c.ghosts, ghost.key)
+	}
+}
+
+func (l *arcList[K, V]) init() {
+	l.root.prev, l.root.next, l.len = &l.root, &l.root, 0
+}
+
+func (l *arcList[K, V]) pushFront(entry *arcEntry[K, V]) {
+	entry.list, entry.prev, entry.next = l, &l.root, l.root.next
+	l.root.next.prev = entry
+	l.root.next = entry
+	l.len++
+}
+
+func (l *arcList[K, V]) remove(entry *arcEntry[K, V]) {
+	entry.prev.next = entry.next
+	entry.next.prev = entry.prev
+	entry.list, entry.prev, entry.next = nil, nil, nil
+	l.len--
+}
+
+// back returns the least recently used entry of the list or nil if it is empty
+func (l *arcList[K, V]) back() *arcEntry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+func minUintptr(a, b uintptr) uintptr {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUintptr(a, b uintptr) uintptr {
+	if a > b {
+		return a
+	}
+	return b
+}
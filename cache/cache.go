@@ -0,0 +1,46 @@
+// Package cache provides concurrent caches built on top of haxmap
+package cache
+
+// Cache is the interface implemented by all caches of fixed capacity of this package
+type Cache[K comparable, V any] interface {
+	// Get retrieves the value of the key and records the access for the eviction policy
+	Get(key K) (value V, ok bool)
+	// Peek retrieves the value of the key without recording the access
+	Peek(key K) (value V, ok bool)
+	// Set inserts or updates the value of the key, evicting entries if the cache is full
+	Set(key K, value V)
+	// GetOrLoad retrieves the value of the key, loading and storing it upon a miss with a single loader call per key
+	GetOrLoad(key K, loader func(K) (V, error)) (V, error)
+	// Del deletes the keys from the cache
+	Del(keys ...K)
+	// Len returns the number of entries within the cache
+	Len() uintptr
+	// Purge removes all entries from the cache
+	Purge()
+	// Stats returns a snapshot of the counters of the cache
+	Stats() Stats
+}
+
+// Policy selects the eviction policy of a cache created with New
+type Policy uint8
+
+const (
+	// LRUPolicy evicts the least recently used entry, see NewLRU
+	LRUPolicy Policy = iota
+	// TinyLFUPolicy only admits new entries which are accessed more frequently than the entry they evict, see NewTinyLFU
+	TinyLFUPolicy
+	// ARCPolicy adapts between recency and frequency, see NewARC
+	ARCPolicy
+)
+
+// New returns a new cache holding at most capacity entries evicted with the given policy
+func New[K comparable, V any](capacity uintptr, policy Policy) Cache[K, V] {
+	switch policy {
+	case TinyLFUPolicy:
+		return NewTinyLFU[K, V](capacity)
+	case ARCPolicy:
+		return NewARC[K, V](capacity)
+	default:
+		return NewLRU[K, V](capacity)
+	}
+}
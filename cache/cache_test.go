@@ -0,0 +1,600 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[int, string](3)
+	for i := 1; i <= 3; i++ {
+		c.Set(i, strconv.Itoa(i))
+	}
+	c.Get(1) // 2 becomes the least recently used
+	c.Set(4, "4")
+	if _, ok := c.Peek(2); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if got := fmt.Sprint(c.Keys()); got != "[4 1 3]" {
+		t.Errorf("unexpected recency order %s", got)
+	}
+	c.Set(3, "three") // update marks it as used
+	if got := fmt.Sprint(c.Keys()); got != "[3 4 1]" || c.Len() != 3 {
+		t.Errorf("unexpected recency order after update %s", got)
+	}
+	if val, _ := c.Get(3); val != "three" {
+		t.Error("update failed")
+	}
+	c.Del(3)
+	if _, ok := c.Get(3); ok || c.Len() != 2 {
+		t.Error("deletion failed")
+	}
+	c.Purge()
+	if c.Len() != 0 || len(c.Keys()) != 0 {
+		t.Error("purge failed")
+	}
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	c := NewLRU[int, int](100)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := (i * (w + 1)) % 300
+				if _, ok := c.Get(key); !ok {
+					c.Set(key, i)
+				}
+				if i%100 == 0 {
+					c.Del(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if c.Len() > 100 || uintptr(len(c.Keys())) != c.Len() {
+		t.Errorf("recency list holds %d keys while the cache holds %d", len(c.Keys()), c.Len())
+	}
+}
+
+func TestWeightedLRU(t *testing.T) {
+	c := NewWeightedLRU[string, []byte](100)
+	c.SetWithCost("a", make([]byte, 40), 40)
+	c.SetWithCost("b", make([]byte, 40), 40)
+	c.Set("c", nil) // costs 1
+	if c.Cost() != 81 || c.Len() != 3 {
+		t.Errorf("expected 3 entries costing 81, got %d costing %d", c.Len(), c.Cost())
+	}
+	c.Get("a")
+	c.SetWithCost("d", make([]byte, 30), 30) // evicts b
+	if got := fmt.Sprint(c.Keys()); got != "[d a c]" || c.Cost() != 71 {
+		t.Errorf("unexpected entries %s costing %d", got, c.Cost())
+	}
+	c.SetWithCost("a", nil, 10) // updates replace the cost
+	if c.Cost() != 41 {
+		t.Errorf("expected a cost of 41 after the update, got %d", c.Cost())
+	}
+	c.SetWithCost("d", nil, 101) // too large to be stored
+	if _, ok := c.Peek("d"); ok || c.Cost() != 11 || c.Len() != 2 {
+		t.Error("entry costing more than the budget should not be stored")
+	}
+	c.Del("a", "c")
+	if c.Cost() != 0 || c.Len() != 0 {
+		t.Error("deletion should release the cost")
+	}
+}
+
+func TestLRUGetOrLoad(t *testing.T) {
+	var (
+		c     = NewLRU[string, int](10)
+		calls int32
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	loader := func(key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return len(key), nil
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val, err := c.GetOrLoad("key", loader); err != nil || val != 3 {
+				t.Errorf("unexpected result %d %v", val, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader should be called once, got %d calls", calls)
+	}
+
+	failure := errors.New("failure")
+	if _, err := c.GetOrLoad("fail", func(string) (int, error) { return 0, failure }); err != failure {
+		t.Error("loader error should be returned")
+	}
+	if _, ok := c.Peek("fail"); ok {
+		t.Error("errors should not be cached")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic of the loader should be propagated")
+			}
+		}()
+		c.GetOrLoad("panic", func(string) (int, error) { panic("failure") })
+	}()
+	if val, err := c.GetOrLoad("panic", func(key string) (int, error) { return len(key), nil }); err != nil || val != 5 {
+		t.Errorf("key should be loaded again after a panicking loader, got %d %v", val, err)
+	}
+
+	// callers waiting for a panicking loader panic as well instead of getting a zero value
+	var (
+		entered, release = make(chan struct{}), make(chan struct{})
+		once             sync.Once
+		panics           int32
+	)
+	panicking := func(string) (int, error) {
+		once.Do(func() { close(entered) })
+		<-release
+		panic("failure")
+	}
+	load := func() {
+		defer wg.Done()
+		defer func() {
+			if recover() != nil {
+				atomic.AddInt32(&panics, 1)
+			}
+		}()
+		if val, err := c.GetOrLoad("waited", panicking); err == nil {
+			t.Errorf("waiter of a panicking loader should not get %d", val)
+		}
+	}
+	wg.Add(1)
+	go load()
+	<-entered
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go load()
+	}
+	time.Sleep(20 * time.Millisecond) // let the waiters block on the call
+	close(release)
+	wg.Wait()
+	if panics != 5 {
+		t.Errorf("panic of the loader should be propagated to all 5 callers, got %d", panics)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var (
+		now   int64
+		calls int32
+	)
+	c := NewTTL[string, int](time.Second, func(key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return len(key), nil
+	})
+	c.now = func() int64 { return atomic.LoadInt64(&now) }
+
+	c.Set("a", 1)
+	c.SetWithTTL("b", 2, 3*time.Second)
+	c.SetWithTTL("c", 3, 0)
+	atomic.StoreInt64(&now, int64(time.Second))
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry should expire after the default ttl")
+	}
+	if val, ok := c.Get("b"); !ok || val != 2 {
+		t.Error("entry with a longer ttl should not expire yet")
+	}
+	atomic.StoreInt64(&now, int64(time.Hour))
+	if removed := c.DeleteExpired(); removed != 1 || c.Len() != 1 {
+		t.Errorf("expected 1 removed entry and 1 left, got %d and %d", removed, c.Len())
+	}
+	if val, ok := c.Get("c"); !ok || val != 3 {
+		t.Error("entry without ttl should never expire")
+	}
+
+	if val, err := c.GetOrLoad("abcd"); err != nil || val != 4 || calls != 1 {
+		t.Error("miss should be loaded")
+	}
+	if val, err := c.GetOrLoad("abcd"); err != nil || val != 4 || calls != 1 {
+		t.Error("loaded value should be cached")
+	}
+	atomic.StoreInt64(&now, int64(2*time.Hour))
+	if c.GetOrLoad("abcd"); calls != 2 {
+		t.Error("expired value should be loaded again")
+	}
+
+	if _, err := NewTTL[string, int](time.Second, nil).GetOrLoad("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound without loader, got %v", err)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var (
+		now     int64
+		calls   int32
+		release = make(chan struct{})
+	)
+	c := NewTTL[string, int](time.Second, func(key string) (int, error) {
+		<-release
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}, WithStaleWhileRevalidate(time.Second))
+	c.now = func() int64 { return atomic.LoadInt64(&now) }
+
+	c.Set("key", 0)
+	atomic.StoreInt64(&now, int64(1500*time.Millisecond))
+	for i := 0; i < 10; i++ { // served stale without blocking while a single refresh is pending
+		if val, err := c.GetOrLoad("key"); err != nil || val != 0 {
+			t.Fatalf("expected the stale value, got %d %v", val, err)
+		}
+	}
+	if _, ok := c.Get("key"); ok {
+		t.Error("Get should not serve stale entries")
+	}
+	if c.DeleteExpired() != 0 {
+		t.Error("stale entries should be kept until the end of the window")
+	}
+	close(release)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if entry, ok := c.items.Get("key"); ok && entry.value == 1 {
+			break
+		}
+	}
+	if val, err := c.GetOrLoad("key"); err != nil || val != 1 || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected a single background refresh, got value %d after %d calls", val, calls)
+	}
+
+	// beyond the window the load blocks
+	atomic.StoreInt64(&now, int64(5*time.Second))
+	if val, err := c.GetOrLoad("key"); err != nil || val != 2 {
+		t.Errorf("expected a blocking load, got %d %v", val, err)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var (
+		now     int64
+		calls   int32
+		release = make(chan struct{}, 1)
+	)
+	c := NewTTL[string, int](10*time.Second, func(key string) (int, error) {
+		<-release
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}, WithRefreshAhead(0.8))
+	c.now = func() int64 { return atomic.LoadInt64(&now) }
+
+	c.Set("key", 0)
+	atomic.StoreInt64(&now, int64(7*time.Second))
+	if val, _ := c.GetOrLoad("key"); val != 0 || len(c.loads.calls) != 0 {
+		t.Error("entry should not be refreshed before 80% of its ttl")
+	}
+	atomic.StoreInt64(&now, int64(8*time.Second))
+	for i := 0; i < 10; i++ {
+		if val, err := c.GetOrLoad("key"); err != nil || val != 0 {
+			t.Fatalf("expected the current value while refreshing, got %d %v", val, err)
+		}
+	}
+	release <- struct{}{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if entry, ok := c.items.Get("key"); ok && entry.value == 1 {
+			break
+		}
+	}
+	atomic.StoreInt64(&now, int64(12*time.Second)) // past the former expiry
+	if val, err := c.GetOrLoad("key"); err != nil || val != 1 || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected a single refresh ahead of the expiry, got %d after %d calls", val, calls)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	var (
+		now   int64
+		calls int32
+	)
+	c := NewTTL[string, int](time.Minute, func(key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		if key == "missing" {
+			return 0, fmt.Errorf("lookup %q: %w", key, ErrNotFound)
+		}
+		return 0, errors.New("unavailable")
+	}, WithNegativeTTL(time.Second))
+	c.now = func() int64 { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("missing key should be remembered, got %d loader calls", calls)
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get should report a remembered missing key as absent")
+	}
+	now = int64(time.Second)
+	c.GetOrLoad("missing")
+	if calls != 2 {
+		t.Error("missing key should be loaded again after the negative ttl")
+	}
+	c.GetOrLoad("error")
+	c.GetOrLoad("error")
+	if calls != 4 {
+		t.Error("other errors should not be cached")
+	}
+	c.Set("missing", 0) // a zero value is not mistaken for a missing key
+	if val, err := c.GetOrLoad("missing"); err != nil || val != 0 || calls != 4 {
+		t.Errorf("expected the stored zero value, got %d %v", val, err)
+	}
+}
+
+func TestTTLJanitor(t *testing.T) {
+	c := NewTTL[int, int](time.Millisecond, nil)
+	for i := 0; i < 100; i++ {
+		c.Set(i, i)
+	}
+	c.StartJanitor(time.Millisecond)
+	defer c.StopJanitor()
+	deadline := time.Now().Add(time.Second)
+	for c.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.Len() != 0 {
+		t.Errorf("janitor should remove expired entries, %d left", c.Len())
+	}
+}
+
+// fixedHash replaces the randomly seeded hasher of the cache entries, which also indexes the admission sketch,
+// so that collisions in the sketch are the same in every run
+func fixedHash(c Cache[int, int]) Cache[int, int] {
+	c.(*LRU[int, int]).items.SetHasher(func(key int) uintptr {
+		x := uint64(key) + 0x9e3779b97f4a7c15 // splitmix64 finalizer
+		x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+		x = (x ^ x>>27) * 0x94d049bb133111eb
+		return uintptr(x ^ x>>31)
+	})
+	return c
+}
+
+func TestTinyLFU(t *testing.T) {
+	c := fixedHash(New[int, int](10, TinyLFUPolicy))
+	// frequently used keys
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			if _, ok := c.Get(i); !ok {
+				c.Set(i, i)
+			}
+		}
+	}
+	// a scan of one-off keys must not flush them while they keep being used
+	for i := 100; i < 1000; i++ {
+		if _, ok := c.Get(i); !ok {
+			c.Set(i, i)
+		}
+		c.Get(i % 10)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Peek(i); !ok {
+			t.Errorf("frequently used key %d was evicted by a scan", i)
+		}
+	}
+
+	// a new key is admitted only if it is looked up more often than the entry it evicts
+	c = fixedHash(NewTinyLFU[int, int](10))
+	for i := 0; i < 10; i++ {
+		c.Get(i)
+		c.Set(i, i)
+	}
+	c.Set(100, 100)
+	if _, ok := c.Peek(100); ok {
+		t.Error("key never looked up should not be admitted")
+	}
+	for round := 0; round < 3; round++ {
+		c.Get(200)
+	}
+	c.Set(200, 200)
+	if _, ok := c.Peek(200); !ok || c.Len() != 10 {
+		t.Error("frequent key should be admitted")
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(64)
+	for i := 0; i < 10; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	if s.estimate(1) < 10 || s.estimate(2) < 1 || s.estimate(1) <= s.estimate(2) {
+		t.Errorf("unexpected estimations %d and %d", s.estimate(1), s.estimate(2))
+	}
+	for i := 0; i < 100; i++ {
+		s.increment(1)
+	}
+	if s.estimate(1) != sketchMaxCount {
+		t.Error("counters should saturate")
+	}
+	s.reset()
+	if s.estimate(1) != sketchMaxCount/2 {
+		t.Error("reset should halve the counters")
+	}
+}
+
+func TestARC(t *testing.T) {
+	c := NewARC[int, int](10)
+	for i := 0; i < 10; i++ {
+		c.Set(i, i)
+		c.Get(i) // used twice, hence frequently used
+	}
+	// a scan of one-off keys only churns the recently used part once it holds an entry
+	// the first miss of the scan evicts the least frequently used entry as the recently used part is empty
+	for i := 100; i < 200; i++ {
+		c.Set(i, i)
+	}
+	for i := 1; i < 10; i++ {
+		if val, ok := c.Peek(i); !ok || val != i {
+			t.Errorf("frequently used key %d was evicted by a scan", i)
+		}
+	}
+	if c.Len() != 10 || c.t1.len+c.t2.len != 10 || c.b1.len+c.b2.len > 10 {
+		t.Errorf("unexpected sizes t1=%d t2=%d b1=%d b2=%d", c.t1.len, c.t2.len, c.b1.len, c.b2.len)
+	}
+
+	// a miss on a key recently evicted from t1 grows the target of t1
+	ghost := c.b1.root.next.key
+	c.Set(ghost, ghost)
+	if c.target == 0 {
+		t.Error("ghost hit in b1 should adapt the target")
+	}
+	if val, ok := c.Get(ghost); !ok || val != ghost {
+		t.Error("ghost key should become resident again")
+	}
+
+	c.Set(1, 100)
+	if val, _ := c.Peek(1); val != 100 || c.Len() != 10 {
+		t.Error("update failed")
+	}
+	c.Del(1, ghost)
+	if _, ok := c.Peek(1); ok || c.Len() != 8 {
+		t.Error("deletion failed")
+	}
+	c.Purge()
+	if c.Len() != 0 || len(c.ghosts) != 0 || c.target != 0 {
+		t.Error("purge failed")
+	}
+}
+
+func TestARCConcurrent(t *testing.T) {
+	c := New[int, int](50, ARCPolicy).(*ARC[int, int])
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := (i * (w + 1)) % 200
+				if _, err := c.GetOrLoad(key, func(key int) (int, error) { return key, nil }); err != nil {
+					t.Error(err)
+				}
+				if i%150 == 0 {
+					c.Del(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Len() > 50 || c.t1.len+c.t2.len != c.Len() || uintptr(len(c.ghosts)) != c.b1.len+c.b2.len {
+		t.Errorf("inconsistent sizes len=%d t1=%d t2=%d b1=%d b2=%d ghosts=%d", c.Len(), c.t1.len, c.t2.len, c.b1.len, c.b2.len, len(c.ghosts))
+	}
+	if c.t1.len+c.t2.len+c.b1.len+c.b2.len > 100 {
+		t.Error("directory should hold at most twice the capacity")
+	}
+}
+
+func TestWheel(t *testing.T) {
+	w := newWheel[int, int](1, 0)
+	// distances spanning every level, including beyond the last one
+	delays := []int64{1, 2, 63, 64, 65, 100, 4095, 4096, 4097, 300000, 1 << 25}
+	for i, delay := range delays {
+		w.schedule(i, &ttlEntry[int]{value: i, expires: delay})
+	}
+	w.schedule(len(delays), &ttlEntry[int]{expires: -5}) // overdue fires upon the next tick
+
+	var (
+		fired = make(map[int]int64)
+		now   int64
+		step  int64 = 1
+	)
+	for now < 1<<25 {
+		if now == 5000 {
+			step = 1000 // coarse steps for the far timers, they fire at the end of the step covering their expiry
+		}
+		now += step
+		w.advance(now, func(tm timer[int, int]) {
+			fired[tm.key] = now
+		})
+	}
+	for i, delay := range delays {
+		if fired[i] < delay || fired[i]-delay >= step && delay < 5000 || fired[i]-delay >= 1000 {
+			t.Errorf("timer %d due at %d fired at %d", i, delay, fired[i])
+		}
+	}
+	if fired[len(delays)] != 1 {
+		t.Errorf("overdue timer fired at %d", fired[len(delays)])
+	}
+}
+
+func TestTTLWheel(t *testing.T) {
+	var now int64
+	c := NewTTL[int, int](10*time.Millisecond, nil, WithTickResolution(time.Millisecond))
+	c.now = func() int64 { return now }
+	c.wheel = newWheel[int, int](int64(time.Millisecond), now)
+
+	for i := 0; i < 100; i++ {
+		c.SetWithTTL(i, i, time.Duration(i+1)*time.Millisecond)
+	}
+	c.Set(50, 50) // refreshed with the default ttl of 10ms, its former timer is stale
+	now = int64(20 * time.Millisecond)
+	if removed := c.DeleteExpired(); removed != 21 || c.Len() != 79 {
+		t.Errorf("expected 21 removed entries and 79 left, got %d and %d", removed, c.Len())
+	}
+	c.SetWithTTL(60, 60, time.Hour) // its former timer is stale
+	now = int64(200 * time.Millisecond)
+	if removed := c.DeleteExpired(); removed != 78 || c.Len() != 1 {
+		t.Errorf("expected all entries to expire, %d removed and %d left", removed, c.Len())
+	}
+}
+
+func TestStats(t *testing.T) {
+	caches := map[string]Cache[int, int]{
+		"lru":     NewLRU[int, int](2),
+		"tinylfu": NewTinyLFU[int, int](2),
+		"arc":     NewARC[int, int](2),
+	}
+	failure := errors.New("failure")
+	for name, c := range caches {
+		c.Set(1, 1)
+		c.Get(1)
+		c.Get(2)
+		c.GetOrLoad(3, func(int) (int, error) { return 0, failure })
+		for i := 0; i < 3; i++ { // repeated lookups get the keys admitted by TinyLFU
+			c.GetOrLoad(4, func(key int) (int, error) { return key, nil })
+			c.GetOrLoad(5, func(key int) (int, error) { return key, nil })
+		}
+		s := c.Stats()
+		if s.Hits < 2 || s.Misses < 4 || s.LoadErrors != 1 || s.Loads < 3 || s.Evictions < 1 {
+			t.Errorf("%s: unexpected stats %+v", name, s)
+		}
+		if ratio := s.HitRatio(); ratio <= 0 || ratio >= 1 {
+			t.Errorf("%s: unexpected hit ratio %f", name, ratio)
+		}
+	}
+
+	var now int64
+	c := NewTTL[int, int](time.Second, func(key int) (int, error) { return key, nil })
+	c.now = func() int64 { return now }
+	c.GetOrLoad(1)
+	c.GetOrLoad(1)
+	c.Set(2, 2)
+	c.Set(3, 3)
+	now = int64(time.Second)
+	c.Get(2)
+	c.DeleteExpired()
+	if s := c.Stats(); s != (Stats{Hits: 1, Misses: 2, Loads: 1, Expirations: 3}) {
+		t.Errorf("ttl: unexpected stats %+v", s)
+	}
+	if (Stats{}).HitRatio() != 0 {
+		t.Error("hit ratio without lookups should be 0")
+	}
+}
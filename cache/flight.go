@@ -0,0 +1,79 @@
+package cache
+
+import "sync"
+
+type (
+	// an in-flight loader call shared by all callers missing the same key
+	call[V any] struct {
+		wg     sync.WaitGroup
+		value  V
+		err    error
+		panicV any // value fn panicked with, panicked again in every caller sharing the call
+	}
+
+	// flight coalesces concurrent loader calls for the same key into one (singleflight)
+	flight[K comparable, V any] struct {
+		mu    sync.Mutex
+		calls map[K]*call[V]
+	}
+)
+
+// do executes fn once for all concurrent callers of the same key and hands its result to all of them
+// a panic of fn is propagated to all of them as well
+func (f *flight[K, V]) do(key K, fn func() (V, error)) (V, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[K]*call[V])
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		c.wg.Wait()
+		if c.panicV != nil {
+			panic(c.panicV)
+		}
+		return c.value, c.err
+	}
+	c := new(call[V])
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	f.run(key, c, fn)
+	return c.value, c.err
+}
+
+// launch executes fn on a new goroutine unless a call for the same key is already in flight
+// callers of do for the same key wait for the launched call and share its result
+func (f *flight[K, V]) launch(key K, fn func() (V, error)) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[K]*call[V])
+	}
+	if _, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		return
+	}
+	c := new(call[V])
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	go f.run(key, c, fn)
+}
+
+// run executes fn for the registered call and releases its waiters
+// if fn panics the panic is recorded for the waiters, which panic with it instead of getting a zero value, and the
+// call is released before panicking again so that later callers of the key do not wait for it forever
+func (f *flight[K, V]) run(key K, c *call[V], fn func() (V, error)) {
+	defer func() {
+		c.panicV = recover()
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		c.wg.Done()
+		if c.panicV != nil {
+			panic(c.panicV)
+		}
+	}()
+	c.value, c.err = fn()
+}
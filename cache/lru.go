@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/alphadose/haxmap"
+)
+
+type (
+	// LRU implements a concurrent cache of fixed capacity evicting the least recently used entry when full
+	// Lookups go through a haxmap and never block, the recency list is updated under a mutex which lookups
+	// only try to acquire, hence under heavy contention some accesses are not recorded and the order is approximate
+	// Insertions and deletions always update the recency list
+	LRU[K comparable, V any] struct {
+		stats     counters
+		items     *haxmap.Map[K, *lruEntry[K, V]]
+		mu        sync.Mutex     // guards the recency list
+		root      lruEntry[K, V] // sentinel of the circular recency list, root.next is the most recently used entry
+		capacity  uintptr
+		maxCost   int64 // budget of the total cost of the entries, 0 if unbounded
+		cost      int64 // total cost of the entries, guarded by the mutex
+		loads     flight[K, V]
+		admission *sketch // access frequencies of the TinyLFU admission policy, nil to admit every new entry
+	}
+
+	// entry of an LRU, immutable once inserted except for its links which are guarded by the mutex
+	lruEntry[K comparable, V any] struct {
+		key        K
+		value      V
+		cost       int64
+		prev, next *lruEntry[K, V]
+	}
+)
+
+// NewLRU returns a new LRU cache holding at most capacity entries, a capacity of 0 is treated as 1
+func NewLRU[K comparable, V any](capacity uintptr) *LRU[K, V] {
+	if capacity == 0 {
+		capacity = 1
+	}
+	c := &LRU[K, V]{items: haxmap.New[K, *lruEntry[K, V]](), capacity: capacity}
+	c.root.prev, c.root.next = &c.root, &c.root
+	return c
+}
+
+// NewWeightedLRU returns a new LRU cache bounded by the total cost of its entries rather than their number
+// Entries are stored with SetWithCost, Set storing them with a cost of 1, and the least recently used entries
+// are evicted until the total cost fits in maxCost, a maxCost of 0 or less is treated as 1
+func NewWeightedLRU[K comparable, V any](maxCost int64) *LRU[K, V] {
+	if maxCost <= 0 {
+		maxCost = 1
+	}
+	c := NewLRU[K, V](^uintptr(0))
+	c.maxCost = maxCost
+	return c
+}
+
+// NewTinyLFU returns a new LRU cache holding at most capacity entries with a TinyLFU admission policy
+// The frequency of lookups of all keys, including misses, is estimated with a count-min sketch and a new key
+// is only inserted into a full cache if it was accessed more frequently than the least recently used entry
+// it would evict, which protects frequently used entries from being flushed by one-off keys
+func NewTinyLFU[K comparable, V any](capacity uintptr) *LRU[K, V] {
+	c := NewLRU[K, V](capacity)
+	c.admission = newSketch(c.capacity)
+	return c
+}
+
+// Get retrieves the value of the key and marks it as most recently used
+// returns `false` if the key is absent
+func (c *LRU[K, V]) Get(key K) (value V, ok bool) {
+	if c.admission != nil {
+		c.admission.increment(c.items.Hash(key))
+	}
+	entry, ok := c.items.Get(key)
+	c.stats.lookup(ok)
+	if !ok {
+		return
+	}
+	if c.mu.TryLock() {
+		if entry.next != nil { // else evicted or replaced concurrently
+			c.unlink(entry)
+			c.pushFront(entry)
+		}
+		c.mu.Unlock()
+	}
+	return entry.value, true
+}
+
+// Peek retrieves the value of the key without marking it as used
+func (c *LRU[K, V]) Peek(key K) (value V, ok bool) {
+	if entry, found := c.items.Get(key); found {
+		return entry.value, true
+	}
+	return
+}
+
+// Set inserts or updates the value of the key and marks it as most recently used
+// The least recently used entry is evicted if the cache is full, unless the TinyLFU admission policy
+// rejects the new key in which case the cache is left as is
+func (c *LRU[K, V]) Set(key K, value V) {
+	c.SetWithCost(key, value, 1)
+}
+
+// SetWithCost is like Set but accounts the entry with the given cost against the budget of a cache
+// created with NewWeightedLRU, costs are ignored by caches bounded by their number of entries
+// An entry costing more than the whole budget is not stored and the previous value of the key is deleted
+func (c *LRU[K, V]) SetWithCost(key K, value V, cost int64) {
+	entry := &lruEntry[K, V]{key: key, value: value, cost: cost}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.items.Get(key)
+	if ok {
+		c.remove(old)
+	}
+	if c.maxCost > 0 && cost > c.maxCost {
+		return
+	}
+	if !ok && c.admission != nil && c.full(cost) {
+		victim := c.root.prev
+		if c.admission.estimate(c.items.Hash(key)) <= c.admission.estimate(c.items.Hash(victim.key)) {
+			return
+		}
+	}
+	c.pushFront(entry)
+	c.cost += cost
+	c.items.Set(key, entry)
+	for c.items.Len() > c.capacity || c.maxCost > 0 && c.cost > c.maxCost {
+		victim := c.root.prev
+		c.remove(victim)
+		c.stats.evict(1)
+	}
+}
+
+// GetOrLoad retrieves the value of the key, loading and storing it upon a miss
+// Concurrent misses for the same key result in a single loader call whose result is shared by all callers
+// Errors of the loader are returned as is and not cached
+func (c *LRU[K, V]) GetOrLoad(key K, loader func(K) (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	return c.loads.do(key, func() (V, error) {
+		if value, ok := c.Peek(key); ok { // loaded by a call which just finished
+			return value, nil
+		}
+		value, err := loader(key)
+		c.stats.load(err)
+		if err == nil {
+			c.Set(key, value)
+		}
+		return value, err
+	})
+}
+
+// Del deletes the keys from the cache
+func (c *LRU[K, V]) Del(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if entry, ok := c.items.Get(key); ok {
+			c.remove(entry)
+		}
+	}
+}
+
+// Len returns the number of entries within the cache
+func (c *LRU[K, V]) Len() uintptr {
+	return c.items.Len()
+}
+
+// Purge removes all entries from the cache
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items.Clear()
+	// unlink every entry as concurrent lookups might still hold them
+	for entry := c.root.next; entry != &c.root; {
+		next := entry.next
+		entry.prev, entry.next = nil, nil
+		entry = next
+	}
+	c.root.prev, c.root.next = &c.root, &c.root
+	c.cost = 0
+}
+
+// Stats returns a snapshot of the counters of the cache
+func (c *LRU[K, V]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// Cost returns the total cost of the entries within the cache, see SetWithCost
+func (c *LRU[K, V]) Cost() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cost
+}
+
+// Keys returns the keys of the cache from the most to the least recently used
+func (c *LRU[K, V]) Keys() []K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]K, 0, c.items.Len())
+	for entry := c.root.next; entry != &c.root; entry = entry.next {
+		keys = append(keys, entry.key)
+	}
+	return keys
+}
+
+func (c *LRU[K, V]) pushFront(entry *lruEntry[K, V]) {
+	entry.prev, entry.next = &c.root, c.root.next
+	c.root.next.prev = entry
+	c.root.next = entry
+}
+
+// remove deletes the entry from the map and the recency list, the mutex must be held
+func (c *LRU[K, V]) remove(entry *lruEntry[K, V]) {
+	c.unlink(entry)
+	c.items.Del(entry.key)
+	c.cost -= entry.cost
+}
+
+// full reports whether inserting a new entry of the given cost requires an eviction, the mutex must be held
+func (c *LRU[K, V]) full(cost int64) bool {
+	if c.maxCost > 0 {
+		return c.cost+cost > c.maxCost
+	}
+	return c.items.Len() >= c.capacity
+}
+
+// unlink removes the entry from the recency list, its nil links mark it as unlinked
+func (c *LRU[K, V]) unlink(entry *lruEntry[K, V]) {
+	entry.prev.next = entry.next
+	entry.next.prev = entry.prev
+	entry.prev, entry.next = nil, nil
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	// number of rows of the count-min sketch, each row hashes keys independently
+	sketchDepth = 4
+
+	// maximum value of a counter, frequencies are kept small as only their relative order matters
+	sketchMaxCount = 15
+)
+
+// seeds mixed into the key hash to index every row independently
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// sketch is a count-min sketch estimating the access frequency of keys (TinyLFU)
+// Counters are updated with atomic operations hence it is safe for concurrent use, all counters are halved
+// after a number of increments proportional to the width so that the frequencies of stale keys decay
+type sketch struct {
+	additions uint64 // first for 64-bit alignment of atomic operations
+	resetAt   uint64
+	mask      uint64
+	rows      [sketchDepth][]uint32
+}
+
+// newSketch returns a sketch sized for a cache of the given capacity
+func newSketch(capacity uintptr) *sketch {
+	// a few counters per entry keep collisions between the keys of the cache rare
+	width := uint64(64)
+	for width < 4*uint64(capacity) {
+		width <<= 1
+	}
+	s := &sketch{resetAt: width * 10, mask: width - 1}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, width)
+	}
+	return s
+}
+
+// increment records an access of the key with the given hash
+func (s *sketch) increment(hash uintptr) {
+	for i := range s.rows {
+		counter := &s.rows[i][s.index(hash, i)]
+		for {
+			count := atomic.LoadUint32(counter)
+			if count >= sketchMaxCount || atomic.CompareAndSwapUint32(counter, count, count+1) {
+				break
+			}
+		}
+	}
+	if atomic.AddUint64(&s.additions, 1) == s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated access frequency of the key with the given hash
+func (s *sketch) estimate(hash uintptr) uint32 {
+	min := uint32(sketchMaxCount)
+	for i := range s.rows {
+		if count := atomic.LoadUint32(&s.rows[i][s.index(hash, i)]); count < min {
+			min = count
+		}
+	}
+	return min
+}
+
+// reset halves all counters, increments racing with it might be lost which only affects the estimation
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			counter := &s.rows[i][j]
+			atomic.StoreUint32(counter, atomic.LoadUint32(counter)>>1)
+		}
+	}
+	atomic.StoreUint64(&s.additions, 0)
+}
+
+func (s *sketch) index(hash uintptr, row int) uint64 {
+	hi, lo := bits.Mul64(uint64(hash)^sketchSeeds[row], sketchSeeds[(row+1)%sketchDepth])
+	return (hi ^ lo) & s.mask
+}
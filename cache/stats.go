@@ -0,0 +1,65 @@
+package cache
+
+import "sync/atomic"
+
+type (
+	// Stats is a snapshot of the counters of a cache
+	Stats struct {
+		Hits        uint64 // lookups by Get or GetOrLoad which found the key
+		Misses      uint64 // lookups by Get or GetOrLoad which did not find the key
+		Loads       uint64 // calls of the loader
+		LoadErrors  uint64 // calls of the loader which returned an error
+		Evictions   uint64 // entries evicted to make room for new ones
+		Expirations uint64 // entries removed once expired
+	}
+
+	// counters of a cache, updated atomically
+	// the counters are 64-bit hence the struct must be the first field of the caches to be aligned on 32-bit platforms
+	counters struct {
+		hits, misses, loads, loadErrors, evictions, expirations uint64
+	}
+)
+
+// HitRatio returns the ratio of lookups which found the key, 0 if there was no lookup
+func (s Stats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// lookup counts a hit or a miss
+func (c *counters) lookup(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+// load counts a loader call which returned the given error
+func (c *counters) load(err error) {
+	atomic.AddUint64(&c.loads, 1)
+	if err != nil {
+		atomic.AddUint64(&c.loadErrors, 1)
+	}
+}
+
+func (c *counters) evict(n uint64) {
+	atomic.AddUint64(&c.evictions, n)
+}
+
+func (c *counters) expire(n uint64) {
+	atomic.AddUint64(&c.expirations, n)
+}
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Loads:       atomic.LoadUint64(&c.loads),
+		LoadErrors:  atomic.LoadUint64(&c.loadErrors),
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Expirations: atomic.LoadUint64(&c.expirations),
+	}
+}
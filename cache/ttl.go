@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+// ErrNotFound is returned by TTL.GetOrLoad when the key is absent or expired and the cache has no loader
+// Loaders return it, possibly wrapped, to report a missing key which is then remembered if negative caching
+// is enabled with WithNegativeTTL
+var ErrNotFound = errors.New("cache: key not found")
+
+type (
+	// TTL implements a concurrent cache whose entries expire after a time-to-live
+	// Expired entries are never returned, unless served while stale by GetOrLoad, and are removed lazily upon lookup
+	// or by the optional janitor started with StartJanitor, until then they still count in Len
+	TTL[K comparable, V any] struct {
+		stats   counters
+		items   *haxmap.Map[K, *ttlEntry[V]]
+		ttl     time.Duration
+		loader  func(K) (V, error)
+		loads   flight[K, V]
+		now     func() int64  // clock in unix nanoseconds, replaceable in tests
+		wheel   *wheel[K, V]  // schedules expirations if enabled with WithTickResolution, nil to scan all entries
+		stale   int64         // nanoseconds expired entries are served by GetOrLoad while being reloaded, 0 if disabled
+		missing time.Duration // ttl of the entries remembering keys reported missing by the loader, 0 if disabled
+		ahead   float64       // fraction of the ttl after which GetOrLoad refreshes an entry in the background, 0 if disabled
+		mu      sync.Mutex    // guards the janitor
+		janitor chan struct{}
+	}
+
+	// TTLOption configures a cache created with NewTTL
+	TTLOption func(*ttlOptions)
+
+	ttlOptions struct {
+		resolution time.Duration
+		stale      time.Duration
+		missing    time.Duration
+		ahead      float64
+	}
+
+	// entry of a TTL cache, immutable once inserted
+	ttlEntry[V any] struct {
+		value   V
+		expires int64 // unix nanoseconds, 0 if the entry never expires
+		refresh int64 // unix nanoseconds after which GetOrLoad refreshes the entry ahead of its expiry, 0 if never
+		missing bool  // remembers a key reported missing by the loader, see WithNegativeTTL
+	}
+)
+
+// NewTTL returns a new TTL cache whose entries expire after ttl, a ttl of 0 disables expiry
+// The loader is called by GetOrLoad upon a miss and may be nil
+func NewTTL[K comparable, V any](ttl time.Duration, loader func(K) (V, error), opts ...TTLOption) *TTL[K, V] {
+	var o ttlOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &TTL[K, V]{
+		items:  haxmap.New[K, *ttlEntry[V]](),
+		ttl:    ttl,
+		loader: loader,
+		now:    func() int64 { return time.Now().UnixNano() },
+	}
+	if o.stale > 0 && loader != nil {
+		c.stale = int64(o.stale)
+	}
+	if o.missing > 0 && loader != nil {
+		c.missing = o.missing
+	}
+	if o.ahead > 0 && o.ahead < 1 && loader != nil {
+		c.ahead = o.ahead
+	}
+	if o.resolution > 0 {
+		c.wheel = newWheel[K, V](int64(o.resolution), c.now())
+	}
+	return c
+}
+
+// WithStaleWhileRevalidate makes GetOrLoad serve entries which expired less than maxStale ago immediately
+// while a single background call of the loader per key refreshes them, which hides the latency of the loader
+// and avoids a burst of blocking loads whenever a hot key expires
+// Entries are kept until maxStale after their expiry, Get still reports them as absent once expired
+// A failed refresh leaves the stale entry in place, the next GetOrLoad tries again
+// It has no effect on a cache without loader
+func WithStaleWhileRevalidate(maxStale time.Duration) TTLOption {
+	return func(o *ttlOptions) {
+		o.stale = maxStale
+	}
+}
+
+// WithRefreshAhead makes GetOrLoad refresh an entry in the background once the given fraction of its ttl elapsed,
+// for instance 0.8 for the last 20% of its lifetime, so that keys accessed regularly never expire and never block
+// on the loader, the current value is served meanwhile and a single refresh per key is in flight at any time
+// A failed refresh leaves the entry in place until its expiry, the next GetOrLoad tries again
+// It has no effect on a cache without loader or with a fraction outside of (0, 1)
+func WithRefreshAhead(fraction float64) TTLOption {
+	return func(o *ttlOptions) {
+		o.ahead = fraction
+	}
+}
+
+// WithNegativeTTL makes the cache remember keys for which the loader returned ErrNotFound during the given ttl,
+// usually shorter than the ttl of values, so that repeated lookups of missing keys do not hit the backing store
+// GetOrLoad returns ErrNotFound for such keys without calling the loader, Get and Len treat them like expired entries
+// It has no effect on a cache without loader
+func WithNegativeTTL(ttl time.Duration) TTLOption {
+	return func(o *ttlOptions) {
+		o.missing = ttl
+	}
+}
+
+// WithTickResolution schedules expirations in a hierarchical timing wheel whose ticks last the given resolution
+// DeleteExpired, hence the janitor, then only visits the entries due since its last call instead of all entries,
+// which keeps the work per tick proportional to the number of expirations even with millions of entries
+// Entries are removed at most one tick after their expiry, updated or deleted entries leave a stale timer behind
+// until their former expiry
+func WithTickResolution(resolution time.Duration) TTLOption {
+	return func(o *ttlOptions) {
+		o.resolution = resolution
+	}
+}
+
+// Get retrieves the value of the key
+// returns `false` if the key is absent or expired
+func (c *TTL[K, V]) Get(key K) (value V, ok bool) {
+	entry, ok := c.items.Get(key)
+	if ok {
+		if now := c.now(); entry.expired(now) {
+			if entry.expired(now-c.stale) && c.items.CompareAndDelete(key, entry) { // else kept to be served while stale
+				c.stats.expire(1)
+			}
+			ok = false
+		} else if entry.missing {
+			ok = false
+		} else {
+			value = entry.value
+		}
+	}
+	c.stats.lookup(ok)
+	return
+}
+
+// GetOrLoad retrieves the value of the key, loading and storing it with the default ttl upon a miss
+// Concurrent misses for the same key result in a single loader call whose result is shared by all callers
+// Errors of the loader are returned as is and not cached, except ErrNotFound with WithNegativeTTL,
+// ErrNotFound is returned upon a miss without loader
+func (c *TTL[K, V]) GetOrLoad(key K) (V, error) {
+	if entry, ok := c.items.Get(key); ok {
+		now := c.now()
+		if !entry.expired(now) {
+			c.stats.lookup(!entry.missing)
+			if entry.refresh != 0 && now >= entry.refresh {
+				c.loads.launch(key, func() (V, error) { return c.load(key) })
+			}
+			return entry.result()
+		}
+		if !entry.expired(now - c.stale) { // serve the stale entry while it is reloaded
+			c.stats.lookup(!entry.missing)
+			c.loads.launch(key, func() (V, error) { return c.load(key) })
+			return entry.result()
+		}
+	}
+	c.stats.lookup(false)
+	if c.loader == nil {
+		var zero V
+		return zero, ErrNotFound
+	}
+	return c.loads.do(key, func() (V, error) {
+		if entry, ok := c.items.Get(key); ok && !entry.expired(c.now()) { // loaded by a call which just finished
+			return entry.result()
+		}
+		return c.load(key)
+	})
+}
+
+// load calls the loader and stores the loaded value with the default ttl
+// a missing key is remembered if negative caching is enabled
+func (c *TTL[K, V]) load(key K) (V, error) {
+	value, err := c.loader(key)
+	c.stats.load(err)
+	if err == nil {
+		c.Set(key, value)
+	} else if c.missing > 0 && errors.Is(err, ErrNotFound) {
+		c.store(key, &ttlEntry[V]{missing: true}, c.missing)
+	}
+	return value, err
+}
+
+// Set inserts or updates the value of the key which expires after the default ttl
+func (c *TTL[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL inserts or updates the value of the key which expires after the given ttl, 0 disables expiry
+func (c *TTL[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.store(key, &ttlEntry[V]{value: value}, ttl)
+}
+
+// store inserts the entry which expires after the given ttl, 0 disables expiry
+func (c *TTL[K, V]) store(key K, entry *ttlEntry[V], ttl time.Duration) {
+	if ttl > 0 {
+		entry.expires = c.now() + int64(ttl)
+		if c.ahead > 0 && !entry.missing {
+			entry.refresh = entry.expires - int64(float64(ttl)*(1-c.ahead))
+		}
+	}
+	c.items.Set(key, entry)
+	if c.wheel != nil && entry.expires != 0 {
+		c.wheel.schedule(key, entry)
+	}
+}
+
+// Del deletes the keys from the cache
+func (c *TTL[K, V]) Del(keys ...K) {
+	c.items.Del(keys...)
+}
+
+// Len returns the number of entries within the cache including expired ones not removed yet
+func (c *TTL[K, V]) Len() uintptr {
+	return c.items.Len()
+}
+
+// Purge removes all entries from the cache
+func (c *TTL[K, V]) Purge() {
+	c.items.Clear()
+}
+
+// Stats returns a snapshot of the counters of the cache, a remembered missing key is counted as a miss
+// Evictions are always 0 as entries only leave the cache once expired
+func (c *TTL[K, V]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// DeleteExpired removes all expired entries and returns their number
+// Entries served while stale, see WithStaleWhileRevalidate, are only removed once maxStale elapsed after their expiry
+func (c *TTL[K, V]) DeleteExpired() (removed int) {
+	now := c.now() - c.stale
+	if c.wheel != nil {
+		c.wheel.advance(now, func(t timer[K, V]) {
+			// a stale timer does not match the current entry of the key
+			if c.items.CompareAndDelete(t.key, t.entry) {
+				removed++
+			}
+		})
+	} else {
+		c.items.ForEach(func(key K, entry *ttlEntry[V]) bool {
+			// an entry refreshed in the meantime is a different entry and is kept
+			if entry.expired(now) && c.items.CompareAndDelete(key, entry) {
+				removed++
+			}
+			return true
+		})
+	}
+	c.stats.expire(uint64(removed))
+	return
+}
+
+// StartJanitor starts a background goroutine calling DeleteExpired at every interval until StopJanitor is called
+// A running janitor is replaced
+func (c *TTL[K, V]) StartJanitor(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.janitor != nil {
+		close(c.janitor)
+	}
+	stop := make(chan struct{})
+	c.janitor = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.DeleteExpired()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the background goroutine started by StartJanitor if any
+func (c *TTL[K, V]) StopJanitor() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.janitor != nil {
+		close(c.janitor)
+		c.janitor = nil
+	}
+}
+
+// result returns the value of the entry or ErrNotFound if it remembers a missing key
+func (e *ttlEntry[V]) result() (V, error) {
+	if e.missing {
+		var zero V
+		return zero, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (e *ttlEntry[V]) expired(now int64) bool {
+	return e.expires != 0 && now >= e.expires
+}
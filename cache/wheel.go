@@ -0,0 +1,92 @@
+package cache
+
+import "sync"
+
+const (
+	// number of slots of every level of a timing wheel, a power of 2
+	wheelSlotBits = 6
+	wheelSlots    = 1 << wheelSlotBits
+	wheelMask     = wheelSlots - 1
+
+	// number of levels of a timing wheel, the last level spans wheelSlots^wheelLevels ticks
+	wheelLevels = 4
+)
+
+type (
+	// wheel is a hierarchical timing wheel scheduling the expiry of entries in O(1)
+	// Level 0 holds the timers expiring within the next wheelSlots ticks, one slot per tick, and every further level
+	// holds timers wheelSlots times further away with wheelSlots times coarser slots
+	// Whenever a level wraps around, the next slot of the level above is cascaded down to finer slots,
+	// hence every timer is moved at most wheelLevels times before it fires
+	wheel[K comparable, V any] struct {
+		mu         sync.Mutex
+		resolution int64 // duration of a tick in nanoseconds
+		current    int64 // last processed tick
+		levels     [wheelLevels][wheelSlots][]timer[K, V]
+	}
+
+	// timer of an entry, it is stale once the entry was updated or deleted
+	timer[K comparable, V any] struct {
+		key   K
+		entry *ttlEntry[V]
+		tick  int64 // tick at which the entry expires
+	}
+)
+
+func newWheel[K comparable, V any](resolution, now int64) *wheel[K, V] {
+	return &wheel[K, V]{resolution: resolution, current: now / resolution}
+}
+
+// schedule adds a timer for an entry expiring at the given time in nanoseconds
+func (w *wheel[K, V]) schedule(key K, entry *ttlEntry[V]) {
+	// round up so that an entry never fires before its expiry
+	t := timer[K, V]{key: key, entry: entry, tick: (entry.expires + w.resolution - 1) / w.resolution}
+	w.mu.Lock()
+	if t.tick <= w.current {
+		t.tick = w.current + 1 // the slot of the current tick was already processed
+	}
+	w.add(t)
+	w.mu.Unlock()
+}
+
+// advance processes all ticks up to the given time in nanoseconds and calls expire for every due timer
+func (w *wheel[K, V]) advance(now int64, expire func(timer[K, V])) {
+	var due []timer[K, V]
+	w.mu.Lock()
+	for target := now / w.resolution; w.current < target; {
+		w.current++
+		// cascade from the highest level so that timers moved down can be cascaded again
+		for level := wheelLevels - 1; level > 0; level-- {
+			if w.current&(1<<(wheelSlotBits*level)-1) == 0 {
+				slot := &w.levels[level][(w.current>>(wheelSlotBits*level))&wheelMask]
+				timers := *slot
+				*slot = nil
+				for _, t := range timers {
+					w.add(t)
+				}
+			}
+		}
+		slot := &w.levels[0][w.current&wheelMask]
+		due = append(due, *slot...)
+		*slot = nil
+	}
+	w.mu.Unlock()
+	// expire outside of the lock as it updates the map
+	for _, t := range due {
+		expire(t)
+	}
+}
+
+// add files the timer in the level whose span covers its distance from the current tick
+// timers cascaded down may be due at the current tick whose slot is processed right after cascading
+func (w *wheel[K, V]) add(t timer[K, V]) {
+	delta := t.tick - w.current
+	for level := 0; level < wheelLevels; level++ {
+		if delta < 1<<(wheelSlotBits*(level+1)) || level == wheelLevels-1 {
+			// timers beyond the span of the last level are cascaded again until they are due
+			slot := &w.levels[level][(t.tick>>(wheelSlotBits*level))&wheelMask]
+			*slot = append(*slot, t)
+			return
+		}
+	}
+}
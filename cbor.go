@@ -0,0 +1,394 @@
+package haxmap
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// major types of CBOR data items
+const (
+	cborUint byte = iota
+	cborNegint
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+type (
+	// cborMarshaler is the cbor.Marshaler interface of github.com/fxamacker/cbor
+	cborMarshaler interface {
+		MarshalCBOR() ([]byte, error)
+	}
+
+	// cborUnmarshaler is the cbor.Unmarshaler interface of github.com/fxamacker/cbor
+	cborUnmarshaler interface {
+		UnmarshalCBOR([]byte) error
+	}
+
+	// cborEncoder appends CBOR data items, sorting the keys of maps if deterministic
+	cborEncoder struct {
+		deterministic bool
+	}
+
+	// cborDecoder decodes CBOR data items from a buffer
+	cborDecoder struct {
+		data []byte
+		off  int
+	}
+)
+
+var (
+	cborMarshalerType   = reflect.TypeOf((*cborMarshaler)(nil)).Elem()
+	cborUnmarshalerType = reflect.TypeOf((*cborUnmarshaler)(nil)).Elem()
+)
+
+// SetDeterministicCBOR makes MarshalCBOR follow the core deterministic encoding of RFC 8949: the keys of the map,
+// and of maps and structs nested in values, are sorted by the bytewise order of their encoding hence equal maps are
+// encoded to equal bytes whatever their hashes or insertion order, at the cost of sorting the entries
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) SetDeterministicCBOR(deterministic bool) {
+	m.initialize()
+	m.sortedCBOR = deterministic
+}
+
+// MarshalCBOR implements the cbor.Marshaler interface of github.com/fxamacker/cbor.
+// The map is encoded as a CBOR map without requiring any dependency: keys and values implementing the interface are
+// embedded as is, encoding.BinaryMarshaler types are encoded as byte strings, structs are encoded as maps of their
+// exported fields named by their `cbor` or `json` tag if any and other types are encoded according to their kind
+// Entries are written in the order of the list unless deterministic encoding is enabled, see SetDeterministicCBOR
+func (m *Map[K, V]) MarshalCBOR() ([]byte, error) {
+	m.initialize()
+	var items []*element[K, V]
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		items = append(items, item)
+	}
+	e := cborEncoder{deterministic: m.sortedCBOR}
+	return e.appendMap(nil, len(items), func(dst []byte, i int) ([]byte, error) {
+		return e.append(dst, reflect.ValueOf(&items[i].key).Elem())
+	}, func(dst []byte, i int) ([]byte, error) {
+		return e.append(dst, reflect.ValueOf(m.valueOf(items[i])).Elem())
+	})
+}
+
+// UnmarshalCBOR implements the cbor.Unmarshaler interface of github.com/fxamacker/cbor.
+// Keys and values are decoded following the rules of MarshalCBOR, tags are ignored and indefinite length items are
+// unsupported, entries already present are kept unless overwritten and null leaves the map as is
+// All pairs are decoded first and inserted with SetMany, which grows the map once to fit them
+func (m *Map[K, V]) UnmarshalCBOR(data []byte) error {
+	m.initialize()
+	dec := &cborDecoder{data: data}
+	if null, err := dec.nextNil(); null || err != nil {
+		return err
+	}
+	n, err := dec.mapLength()
+	if err != nil {
+		return err
+	}
+	pairs := make([]Pair[K, V], n)
+	for idx := range pairs {
+		if err = decodeValue(dec, reflect.ValueOf(&pairs[idx].Key).Elem()); err != nil {
+			return err
+		}
+		if err = decodeValue(dec, reflect.ValueOf(&pairs[idx].Value).Elem()); err != nil {
+			return err
+		}
+	}
+	m.SetMany(pairs...)
+	return nil
+}
+
+// append appends the CBOR encoding of a value
+func (e cborEncoder) append(dst []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface || v.Kind() == reflect.Map ||
+		v.Kind() == reflect.Slice) && v.IsNil()) {
+		return append(dst, cborSimple<<5|22), nil // null
+	}
+	if v.Type().Implements(cborMarshalerType) {
+		data, err := v.Interface().(cborMarshaler).MarshalCBOR()
+		return append(dst, data...), err
+	}
+	if v.Type().Implements(binaryMarshalerType) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		return append(appendCBORHead(dst, cborBytes, uint64(len(data))), data...), err
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, cborSimple<<5|21), nil
+		}
+		return append(dst, cborSimple<<5|20), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			return appendCBORHead(dst, cborNegint, uint64(^i)), nil // -1 - i
+		}
+		return appendCBORHead(dst, cborUint, uint64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendCBORHead(dst, cborUint, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		// the shortest of single and double precision which preserves the value
+		if f := v.Float(); float64(float32(f)) == f || v.Kind() == reflect.Float32 {
+			return appendBigEndian(append(dst, cborSimple<<5|26), uint64(math.Float32bits(float32(f))), 4), nil
+		}
+		return appendBigEndian(append(dst, cborSimple<<5|27), math.Float64bits(v.Float()), 8), nil
+	case reflect.String:
+		return append(appendCBORHead(dst, cborText, uint64(v.Len())), v.String()...), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dst = appendCBORHead(dst, cborBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				dst = append(dst, byte(v.Index(i).Uint()))
+			}
+			return dst, nil
+		}
+		dst = appendCBORHead(dst, cborArray, uint64(v.Len()))
+		for i := 0; i < v.Len() && err == nil; i++ {
+			dst, err = e.append(dst, v.Index(i))
+		}
+		return dst, err
+	case reflect.Map:
+		keys := v.MapKeys()
+		return e.appendMap(dst, len(keys), func(dst []byte, i int) ([]byte, error) {
+			return e.append(dst, keys[i])
+		}, func(dst []byte, i int) ([]byte, error) {
+			return e.append(dst, v.MapIndex(keys[i]))
+		})
+	case reflect.Struct:
+		var fields []structField
+		for _, field := range taggedFields(v, "cbor", "json") {
+			if !field.omitEmpty || !field.value.IsZero() {
+				fields = append(fields, field)
+			}
+		}
+		return e.appendMap(dst, len(fields), func(dst []byte, i int) ([]byte, error) {
+			return append(appendCBORHead(dst, cborText, uint64(len(fields[i].name))), fields[i].name...), nil
+		}, func(dst []byte, i int) ([]byte, error) {
+			return e.append(dst, fields[i].value)
+		})
+	case reflect.Pointer, reflect.Interface:
+		return e.append(dst, v.Elem())
+	}
+	return dst, fmt.Errorf("haxmap: unsupported CBOR type %s", v.Type())
+}
+
+// appendMap appends a map of n entries whose keys and values are appended by key(i) and value(i)
+// the entries are sorted by the bytewise order of their encoded keys if deterministic
+func (e cborEncoder) appendMap(dst []byte, n int, key, value func(dst []byte, i int) ([]byte, error)) ([]byte, error) {
+	dst = appendCBORHead(dst, cborMap, uint64(n))
+	var err error
+	if !e.deterministic {
+		for i := 0; i < n; i++ {
+			if dst, err = key(dst, i); err != nil {
+				return dst, err
+			}
+			if dst, err = value(dst, i); err != nil {
+				return dst, err
+			}
+		}
+		return dst, nil
+	}
+
+	// encode all entries into a buffer first, then copy them in the order of their keys
+	type span struct{ start, split, end int }
+	var (
+		buf   []byte
+		spans = make([]span, n)
+	)
+	for i := range spans {
+		spans[i].start = len(buf)
+		if buf, err = key(buf, i); err != nil {
+			return dst, err
+		}
+		spans[i].split = len(buf)
+		if buf, err = value(buf, i); err != nil {
+			return dst, err
+		}
+		spans[i].end = len(buf)
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return bytes.Compare(buf[spans[i].start:spans[i].split], buf[spans[j].start:spans[j].split]) < 0
+	})
+	for _, s := range spans {
+		dst = append(dst, buf[s.start:s.end]...)
+	}
+	return dst, nil
+}
+
+// appendCBORHead appends the initial bytes of a data item of the given major type and argument in the shortest form
+func appendCBORHead(dst []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(dst, major<<5|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(dst, major<<5|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return appendBigEndian(append(dst, major<<5|25), arg, 2)
+	case arg <= math.MaxUint32:
+		return appendBigEndian(append(dst, major<<5|26), arg, 4)
+	}
+	return appendBigEndian(append(dst, major<<5|27), arg, 8)
+}
+
+// head reads the major type, additional information and argument of the next data item, skipping its tags if any
+// the argument of floating point numbers holds their bits and the one of other simple values their number
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	for {
+		if d.off >= len(d.data) {
+			return 0, 0, 0, io.ErrUnexpectedEOF
+		}
+		b := d.data[d.off]
+		d.off++
+		major, info, arg = b>>5, b&0x1f, 0
+		switch {
+		case info < 24:
+			arg = uint64(info)
+		case info <= 27:
+			size := 1 << (info - 24)
+			if len(d.data)-d.off < size {
+				return 0, 0, 0, io.ErrUnexpectedEOF
+			}
+			for _, c := range d.data[d.off : d.off+size] {
+				arg = arg<<8 | uint64(c)
+			}
+			d.off += size
+		case info == 31:
+			return 0, 0, 0, fmt.Errorf("haxmap: unsupported indefinite length CBOR item 0x%x", b)
+		default:
+			return 0, 0, 0, fmt.Errorf("haxmap: malformed CBOR item 0x%x", b)
+		}
+		if major != cborTag { // otherwise the tagged item follows
+			return major, info, arg, nil
+		}
+	}
+}
+
+// length reads the header of a byte string, text string, array or map of the given major type
+// the length is checked against the remaining data as every entry takes at least the given number of bytes
+func (d *cborDecoder) length(expected byte, size int) (int, error) {
+	major, _, arg, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != expected {
+		return 0, fmt.Errorf("haxmap: unexpected CBOR major type %d, expected %d", major, expected)
+	}
+	if arg > uint64(len(d.data)-d.off)/uint64(size) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(arg), nil
+}
+
+func (d *cborDecoder) unmarshal(v reflect.Value) (bool, error) {
+	if !v.CanAddr() || !v.Addr().Type().Implements(cborUnmarshalerType) {
+		return false, nil
+	}
+	start := d.off
+	if _, err := d.decodeAny(); err != nil {
+		return true, err
+	}
+	return true, v.Addr().Interface().(cborUnmarshaler).UnmarshalCBOR(d.data[start:d.off])
+}
+
+func (d *cborDecoder) nextNil() (bool, error) {
+	start := d.off
+	major, info, _, err := d.head()
+	if err == nil && major == cborSimple && (info == 22 || info == 23) { // null or undefined
+		return true, nil
+	}
+	d.off = start
+	return false, err
+}
+
+func (d *cborDecoder) nextBytes() bool {
+	start := d.off
+	major, _, _, err := d.head()
+	d.off = start
+	return err == nil && (major == cborBytes || major == cborText)
+}
+
+func (d *cborDecoder) arrayLength() (int, error) {
+	return d.length(cborArray, 1)
+}
+
+func (d *cborDecoder) mapLength() (int, error) {
+	return d.length(cborMap, 2)
+}
+
+func (d *cborDecoder) decodeAny() (any, error) {
+	start := d.off
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return arg, nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("haxmap: CBOR integer -1-%d overflows int64", arg)
+		}
+		return ^int64(arg), nil // -1 - arg
+	case cborBytes, cborText:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := d.data[d.off : d.off+int(arg)]
+		d.off += int(arg)
+		if major == cborText {
+			return string(data), nil
+		}
+		return append([]byte(nil), data...), nil
+	case cborArray:
+		d.off = start // read again as the header
+		return decodeAnyArray(d)
+	case cborMap:
+		d.off = start
+		return decodeAnyMap(d)
+	}
+	switch info {
+	case 20, 21:
+		return info == 21, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16(uint16(arg)), nil
+	case 26:
+		return math.Float32frombits(uint32(arg)), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("haxmap: unsupported CBOR simple value %d", arg)
+}
+
+func (d *cborDecoder) format() string {
+	return "CBOR"
+}
+
+func (d *cborDecoder) fieldTags() []string {
+	return []string{"cbor", "json"}
+}
+
+// float16 converts the bits of a half precision floating point number to a float32
+func float16(h uint16) float32 {
+	sign, exp, frac := uint32(h>>15)<<31, uint32(h>>10)&0x1f, uint32(h&0x3ff)
+	switch exp {
+	case 0: // zero or subnormal
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f: // infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
@@ -0,0 +1,123 @@
+package haxmap
+
+import (
+	"sort"
+	"sync"
+)
+
+type (
+	// Delta holds the changes of a map since a previous snapshot, see SnapshotSince
+	Delta[K hashable, V any] struct {
+		Seq     uint64       // sequence number of the snapshot to pass to the next call of SnapshotSince
+		Full    bool         // whether Set holds all entries of the map, which then replace any previous state
+		Deleted []K          // keys deleted since the previous snapshot, to be applied before Set
+		Set     []Pair[K, V] // entries inserted or updated since the previous snapshot
+	}
+
+	// changelog numbers the writes and deletions of a map with a global sequence
+	changelog[K hashable] struct {
+		seq       atomicUint64 // first field to be 64-bit aligned on 32-bit platforms
+		stamping  sync.RWMutex // held shared by writers between taking a sequence number and stamping it
+		mu        sync.Mutex   // guards deletions and trimmed
+		deletions []deletion[K]
+		trimmed   uint64 // sequence number up to which deletions were discarded by TrimChanges
+	}
+
+	// a deleted key along with the sequence number of its deletion
+	deletion[K hashable] struct {
+		key K
+		seq uint64
+	}
+)
+
+// EnableChangeTracking numbers every write to the map with a global sequence so that SnapshotSince can export only
+// the entries modified after a previous snapshot, deleted keys are recorded until discarded with TrimChanges
+// Tracking costs an atomic increment of a counter shared by all writers, a shared lock held while the write is
+// stamped and a mutex acquisition per deletion
+// It cannot be disabled once enabled and must not be called concurrently with other operations on the map
+func (m *Map[K, V]) EnableChangeTracking() {
+	m.initialize()
+	if m.changes == nil {
+		m.changes = new(changelog[K])
+	}
+}
+
+// SnapshotSince returns the changes of the map since the snapshot numbered seq, 0 for a full snapshot
+// Applying the deleted keys then the set entries of the returned delta, in this order, to the state of the previous
+// snapshot yields the state of the map at the new snapshot, concurrent writes being included either in this delta
+// or in the next one
+// The delta is full if seq is 0, if deletions after seq were discarded by TrimChanges or if tracking is disabled
+func (m *Map[K, V]) SnapshotSince(seq uint64) (delta Delta[K, V]) {
+	m.initialize()
+	c := m.changes
+	if c == nil {
+		delta.Full = true
+	} else {
+		// ahead of the traversal so that later writes are part of the next delta, once no writer holds a sequence
+		// number up to it without having stamped it yet as such a write would neither be part of this delta nor
+		// of the next one
+		c.stamping.Lock()
+		delta.Seq = c.seq.Load()
+		c.stamping.Unlock()
+		c.mu.Lock()
+		if delta.Full = seq == 0 || seq < c.trimmed; !delta.Full {
+			idx := sort.Search(len(c.deletions), func(i int) bool {
+				return c.deletions[i].seq > seq
+			})
+			for ; idx < len(c.deletions) && c.deletions[idx].seq <= delta.Seq; idx++ {
+				delta.Deleted = append(delta.Deleted, c.deletions[idx].key)
+			}
+		}
+		c.mu.Unlock()
+	}
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		if delta.Full || item.version.Load() > seq {
+			delta.Set = append(delta.Set, Pair[K, V]{Key: item.key, Value: *m.valueOf(item)})
+		}
+	}
+	return
+}
+
+// TrimChanges discards the deleted keys recorded up to the snapshot numbered seq, once all consumers of deltas
+// caught up with it, later calls of SnapshotSince for an older snapshot return a full snapshot
+func (m *Map[K, V]) TrimChanges(seq uint64) {
+	m.initialize()
+	c := m.changes
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq <= c.trimmed {
+		return
+	}
+	idx := sort.Search(len(c.deletions), func(i int) bool {
+		return c.deletions[i].seq > seq
+	})
+	c.deletions = append(c.deletions[:0:0], c.deletions[idx:]...) // release the memory of the discarded keys
+	c.trimmed = seq
+}
+
+// changed stamps an element with the next sequence number after a write if changes are tracked
+// the version only ever increases so that a stamp delayed past the one of a later write cannot hide that write
+func (m *Map[K, V]) changed(elem *element[K, V]) {
+	c := m.changes
+	if c == nil {
+		return
+	}
+	c.stamping.RLock()
+	seq := c.seq.Add(1)
+	for version := elem.version.Load(); version < seq && !elem.version.CompareAndSwap(version, seq); version = elem.version.Load() {
+	}
+	c.stamping.RUnlock()
+}
+
+// removed records the deletion of a key, the sequence number is taken under the mutex so that deletions stay sorted
+func (c *changelog[K]) removed(key K) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.deletions = append(c.deletions, deletion[K]{key: key, seq: c.seq.Add(1)})
+	c.mu.Unlock()
+}
@@ -0,0 +1,326 @@
+package haxmap
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+type (
+	// objectDecoder decodes the objects of a self-describing binary format like MessagePack or CBOR
+	// the decoding of objects into Go values is shared by the formats, see decodeValue
+	objectDecoder interface {
+		// unmarshal hands the raw next object to the format specific unmarshaler of v if v implements it
+		unmarshal(v reflect.Value) (bool, error)
+		// nextNil consumes the next object if it is nil
+		nextNil() (bool, error)
+		// nextBytes reports whether the next object is a byte or text string
+		nextBytes() bool
+		// arrayLength reads the header of an array
+		arrayLength() (int, error)
+		// mapLength reads the header of a map
+		mapLength() (int, error)
+		// decodeAny decodes the next object into a generic value
+		decodeAny() (any, error)
+		// format names the format in errors
+		format() string
+		// fieldTags lists the struct tags naming fields, by order of precedence
+		fieldTags() []string
+	}
+
+	// structField is an exported field of a struct along with its encoded name
+	structField struct {
+		name      string
+		value     reflect.Value
+		omitEmpty bool
+	}
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// taggedFields lists the exported fields of a struct named following the first of the given tags set on each field
+func taggedFields(v reflect.Value, tags ...string) (fields []structField) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		var tag string
+		for _, key := range tags {
+			if tag = field.Tag.Get(key); tag != "" {
+				break
+			}
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, structField{name: name, value: v.Field(i), omitEmpty: opts == "omitempty"})
+	}
+	return
+}
+
+// decodeValue decodes the next object into a settable value
+func decodeValue(d objectDecoder, v reflect.Value) error {
+	if ok, err := d.unmarshal(v); ok || err != nil {
+		return err
+	}
+	if null, err := d.nextNil(); null || err != nil {
+		if null {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return err
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(d, v.Elem())
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		x, err := d.decodeAny()
+		if err != nil {
+			return err
+		}
+		switch data := x.(type) {
+		case []byte:
+			return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+		case string:
+			return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary([]byte(data))
+		}
+		return fmt.Errorf("haxmap: cannot decode %s %T into %s", d.format(), x, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && d.nextBytes() {
+			break // decoded from a byte or text string below
+		}
+		n, err := d.arrayLength()
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		} else if n > v.Len() {
+			return fmt.Errorf("haxmap: %s array of length %d overflows %s", d.format(), n, v.Type())
+		}
+		for i := 0; i < n; i++ {
+			if err = decodeValue(d, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, err := d.mapLength()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		for i := 0; i < n; i++ {
+			key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			if err = decodeValue(d, key); err != nil {
+				return err
+			}
+			if err = decodeValue(d, value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+		return nil
+	case reflect.Struct:
+		n, err := d.mapLength()
+		if err != nil {
+			return err
+		}
+		fields := taggedFields(v, d.fieldTags()...)
+		for i := 0; i < n; i++ {
+			var name string
+			if err = decodeValue(d, reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			field, ok := structField{}, false
+			for _, f := range fields {
+				if f.name == name {
+					field, ok = f, true
+					break
+				}
+			}
+			if !ok { // unknown fields are skipped
+				_, err = d.decodeAny()
+			} else {
+				err = decodeValue(d, field.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	x, err := d.decodeAny()
+	if err != nil {
+		return err
+	}
+	if assignAny(v, x) {
+		return nil
+	}
+	return fmt.Errorf("haxmap: cannot decode %s %T into %s", d.format(), x, v.Type())
+}
+
+// assignAny assigns a generic value decoded by decodeAny to a scalar, an empty interface or a byte slice or array
+// it reports false if the value does not fit
+func assignAny(v reflect.Value, x any) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			if x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+			return true
+		}
+	case reflect.Bool:
+		if b, ok := x.(bool); ok {
+			v.SetBool(b)
+			return true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch i := x.(type) {
+		case int64:
+			if !v.OverflowInt(i) {
+				v.SetInt(i)
+				return true
+			}
+		case uint64:
+			if i <= math.MaxInt64 && !v.OverflowInt(int64(i)) {
+				v.SetInt(int64(i))
+				return true
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch u := x.(type) {
+		case int64:
+			if u >= 0 && !v.OverflowUint(uint64(u)) {
+				v.SetUint(uint64(u))
+				return true
+			}
+		case uint64:
+			if !v.OverflowUint(u) {
+				v.SetUint(u)
+				return true
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		switch f := x.(type) {
+		case float32:
+			v.SetFloat(float64(f))
+			return true
+		case float64:
+			v.SetFloat(f)
+			return true
+		case int64:
+			v.SetFloat(float64(f))
+			return true
+		case uint64:
+			v.SetFloat(float64(f))
+			return true
+		}
+	case reflect.String:
+		switch s := x.(type) {
+		case string:
+			v.SetString(s)
+			return true
+		case []byte:
+			v.SetString(string(s))
+			return true
+		}
+	case reflect.Slice, reflect.Array: // of bytes
+		var data []byte
+		switch b := x.(type) {
+		case string:
+			data = []byte(b)
+		case []byte:
+			data = b
+		default:
+			return false
+		}
+		if v.Kind() == reflect.Slice {
+			v.SetBytes(data)
+			return true
+		}
+		if len(data) <= v.Len() {
+			reflect.Copy(v, reflect.ValueOf(data))
+			return true
+		}
+	}
+	return false
+}
+
+// decodeAnyArray decodes the next array into a []any
+func decodeAnyArray(d objectDecoder) (any, error) {
+	n, err := d.arrayLength()
+	if err != nil {
+		return nil, err
+	}
+	array := make([]any, n)
+	for i := range array {
+		if array[i], err = d.decodeAny(); err != nil {
+			return nil, err
+		}
+	}
+	return array, nil
+}
+
+// decodeAnyMap decodes the next map into a map[string]any if all keys are strings, into a map[any]any otherwise
+func decodeAnyMap(d objectDecoder) (any, error) {
+	n, err := d.mapLength()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]any, n)
+	var others map[any]any // once a key is not a string
+	for i := 0; i < n; i++ {
+		key, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok && others == nil {
+			byName[s] = value
+			continue
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("haxmap: unsupported %s map key %T", d.format(), key)
+		}
+		if others == nil {
+			others = make(map[any]any, n)
+			for k, v := range byName {
+				others[k] = v
+			}
+		}
+		others[key] = value
+	}
+	if others != nil {
+		return others, nil
+	}
+	return byName, nil
+}
+
+// appendBigEndian appends the n lowest bytes of u in big endian order
+func appendBigEndian(dst []byte, u uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(u>>(8*i)))
+	}
+	return dst
+}
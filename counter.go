@@ -0,0 +1,97 @@
+package haxmap
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// deadCounter is stored in a counter claimed by Del so that concurrent adds retry on the counter replacing it
+// instead of incrementing a counter which is about to be unlinked, hence counters never reach math.MinInt64
+const deadCounter = math.MinInt64
+
+// Counter implements concurrent per-key counters on top of Map
+// Every key owns an integer which is updated in place with atomic operations, hence only the first increment
+// of a key allocates whereas a Map[K, int64] allocates a new boxed value upon every update
+type Counter[K hashable] struct {
+	m *Map[K, *int64]
+}
+
+// NewCounter returns a new Counter instance with an optional specific initialization size
+func NewCounter[K hashable](size ...uintptr) *Counter[K] {
+	return &Counter[K]{m: New[K, *int64](size...)}
+}
+
+// Add adds delta, which may be negative, to the counter of the key and returns the new value
+// The counter of an absent key starts from 0
+func (c *Counter[K]) Add(key K, delta int64) int64 {
+	for {
+		counter, ok := c.m.Get(key)
+		if !ok {
+			counter, _ = c.m.GetOrSet(key, new(int64))
+		}
+		for value := atomic.LoadInt64(counter); value != deadCounter; value = atomic.LoadInt64(counter) {
+			if atomic.CompareAndSwapInt64(counter, value, value+delta) {
+				return value + delta
+			}
+		}
+		// help the deletion along so that the retry does not find the dead counter again
+		c.m.deleteIf(key, func(current *int64) bool { return current == counter })
+	}
+}
+
+// Load returns the value of the counter of the key, 0 if the key is absent
+func (c *Counter[K]) Load(key K) int64 {
+	if counter, ok := c.m.Get(key); ok {
+		if value := atomic.LoadInt64(counter); value != deadCounter {
+			return value
+		}
+	}
+	return 0
+}
+
+// Del deletes the counters of the keys
+func (c *Counter[K]) Del(keys ...K) {
+	for _, key := range keys {
+		c.del(key)
+	}
+}
+
+// del deletes the counter of the key and returns its final value, every add either happens before and is
+// included in that value or happens after and starts from a new counter
+func (c *Counter[K]) del(key K) int64 {
+	counter, ok := c.m.Get(key)
+	if !ok {
+		return 0
+	}
+	value := atomic.SwapInt64(counter, deadCounter)
+	c.m.deleteIf(key, func(current *int64) bool { return current == counter })
+	if value == deadCounter {
+		return 0 // deleted concurrently
+	}
+	return value
+}
+
+// Len returns the number of counters
+func (c *Counter[K]) Len() uintptr {
+	return c.m.Len()
+}
+
+// ForEach iterates over the counters and executes the lambda provided for each key and its current value
+// lambda must return `true` to continue iteration and `false` to break iteration
+func (c *Counter[K]) ForEach(lambda func(K, int64) bool) {
+	c.m.ForEach(func(key K, counter *int64) bool {
+		value := atomic.LoadInt64(counter)
+		return value == deadCounter || lambda(key, value)
+	})
+}
+
+// Snapshot returns the values of all counters in a built-in map
+// Like ForEach, the snapshot is weakly consistent with respect to concurrent updates
+func (c *Counter[K]) Snapshot() map[K]int64 {
+	snapshot := make(map[K]int64, c.m.Len())
+	c.ForEach(func(key K, value int64) bool {
+		snapshot[key] = value
+		return true
+	})
+	return snapshot
+}
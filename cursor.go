@@ -0,0 +1,103 @@
+package haxmap
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidCursor is returned by ParseCursor for malformed cursors
+var ErrInvalidCursor = errors.New("haxmap: invalid cursor")
+
+// textual representation of a cursor past the end of the map
+const cursorDone = "done"
+
+type (
+	// Cursor is an opaque position within a map used to resume a paginated iteration
+	// It can be serialized with String() and restored with ParseCursor, e.g. across paginated API requests
+	Cursor struct {
+		from uintptr // lowest hash not yielded yet
+		done bool
+	}
+
+	// Iterator is a resumable iterator yielding the map's key-value pairs page by page in ascending order of hash
+	// The map can be modified between pages, entries set or deleted in the meantime might or might not be yielded
+	// but entries present for the whole iteration are yielded exactly once
+	Iterator[K hashable, V any] struct {
+		m      *Map[K, V]
+		cursor Cursor
+	}
+)
+
+// Iterator returns a paginated iterator starting at the beginning of the map
+func (m *Map[K, V]) Iterator() *Iterator[K, V] {
+	m.initialize()
+	return &Iterator[K, V]{m: m}
+}
+
+// IteratorFrom returns a paginated iterator resuming at the given cursor
+func (m *Map[K, V]) IteratorFrom(cursor Cursor) *Iterator[K, V] {
+	m.initialize()
+	return &Iterator[K, V]{m: m, cursor: cursor}
+}
+
+// Next returns the next page of up to n pairs along with the cursor to resume from
+// Keys sharing the same hash are never split across pages, so a page can exceed n pairs in case of collisions
+// An empty page is returned once the iteration is done
+func (it *Iterator[K, V]) Next(n int) (pairs []Pair[K, V], cursor Cursor) {
+	if it.cursor.done || n <= 0 {
+		return nil, it.cursor
+	}
+	var (
+		from = it.cursor.from
+		last uintptr
+		item = it.m.metadata.Load().indexElement(from)
+	)
+	if item == nil || item.keyHash > from {
+		item = it.m.listHead.next()
+	}
+	for ; item != nil && item.keyHash < from; item = item.next() {
+	}
+	for item = it.m.skipExpired(item); item != nil; item = it.m.skipExpired(item.next()) {
+		if len(pairs) >= n && item.keyHash != last {
+			break
+		}
+		pairs = append(pairs, Pair[K, V]{Key: item.key, Value: *it.m.valueOf(item)})
+		last = item.keyHash
+	}
+	if item == nil {
+		it.cursor.done = true
+	} else {
+		it.cursor.from = item.keyHash // first hash not yielded yet
+	}
+	return pairs, it.cursor
+}
+
+// Cursor returns the current position of the iterator
+func (it *Iterator[K, V]) Cursor() Cursor {
+	return it.cursor
+}
+
+// Done reports whether the iteration reached the end of the map
+func (c Cursor) Done() bool {
+	return c.done
+}
+
+// String returns the textual representation of the cursor which can be parsed back with ParseCursor
+func (c Cursor) String() string {
+	if c.done {
+		return cursorDone
+	}
+	return strconv.FormatUint(uint64(c.from), 16)
+}
+
+// ParseCursor parses a cursor previously serialized with Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	if s == cursorDone {
+		return Cursor{done: true}, nil
+	}
+	from, err := strconv.ParseUint(s, 16, strconv.IntSize)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{from: uintptr(from)}, nil
+}
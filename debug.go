@@ -0,0 +1,103 @@
+package haxmap
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Distribution describes how the entries of a map are spread over the slots of its index, see DebugDistribution
+type Distribution struct {
+	Slots          uintptr   // number of index slots
+	Entries        uintptr   // number of entries linked in the list, including expired ones not yet removed
+	EmptySlots     uintptr   // number of slots holding no entry
+	Histogram      []uintptr // Histogram[n] is the number of slots holding n entries
+	LongestChain   uintptr   // largest number of entries sharing a slot, which a lookup may have to traverse
+	HashCollisions uintptr   // number of entries sharing their full hash with the previous entry of the list
+	slots          []uintptr // number of entries of every slot, used by DumpIndex
+}
+
+// DebugDistribution returns the distribution of the entries over the slots of the index in a single pass over the list
+// A slot holds the entries whose hashes share the most significant bits addressing the index, hence a hasher whose
+// high bits cluster shows up as long chains and many empty slots
+func (m *Map[K, V]) DebugDistribution() (dist Distribution) {
+	m.initialize()
+	data := m.metadata.Load()
+	dist.Slots = uintptr(len(data.index))
+	dist.slots = make([]uintptr, dist.Slots)
+	var prev *element[K, V]
+	for item := m.listHead.next(); item != nil; item = item.next() {
+		slot := item.keyHash >> data.keyshifts
+		if slot >= dist.Slots { // resized concurrently
+			slot = dist.Slots - 1
+		}
+		dist.slots[slot]++
+		dist.Entries++
+		if prev != nil && prev.keyHash == item.keyHash {
+			dist.HashCollisions++
+		}
+		prev = item
+	}
+	for _, n := range dist.slots {
+		if n > dist.LongestChain {
+			dist.LongestChain = n
+		}
+	}
+	dist.Histogram = make([]uintptr, dist.LongestChain+1)
+	for _, n := range dist.slots {
+		dist.Histogram[n]++
+	}
+	dist.EmptySlots = dist.Histogram[0]
+	return
+}
+
+// DumpIndex writes a human-readable report of the distribution of the entries over the index, see DebugDistribution
+// The report lists the histogram of entries per slot and the most crowded slots
+func (m *Map[K, V]) DumpIndex(w io.Writer) error {
+	const (
+		barWidth = 50
+		crowded  = 10 // number of most crowded slots listed
+	)
+	dist := m.DebugDistribution()
+	var b strings.Builder
+	fmt.Fprintf(&b, "slots %d, entries %d, empty slots %d (%.1f%%), longest chain %d, hash collisions %d\n",
+		dist.Slots, dist.Entries, dist.EmptySlots, 100*float64(dist.EmptySlots)/float64(dist.Slots),
+		dist.LongestChain, dist.HashCollisions)
+
+	b.WriteString("entries per slot:\n")
+	var most uintptr
+	for _, count := range dist.Histogram {
+		if count > most {
+			most = count
+		}
+	}
+	for n, count := range dist.Histogram {
+		if count == 0 {
+			continue
+		}
+		bar := int((count*barWidth + most - 1) / most)
+		fmt.Fprintf(&b, "%6d %10d %s\n", n, count, strings.Repeat("#", bar))
+	}
+
+	slots := make([]int, 0, len(dist.slots))
+	for slot, n := range dist.slots {
+		if n > 1 {
+			slots = append(slots, slot)
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool {
+		return dist.slots[slots[i]] > dist.slots[slots[j]]
+	})
+	if len(slots) > crowded {
+		slots = slots[:crowded]
+	}
+	if len(slots) > 0 {
+		b.WriteString("most crowded slots:\n")
+	}
+	for _, slot := range slots {
+		fmt.Fprintf(&b, "%6d %10d entries\n", slot, dist.slots[slot])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
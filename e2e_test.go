@@ -1,9 +1,23 @@
 package haxmap
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"math"
+	"net/netip"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -432,3 +446,3928 @@ func TestSwap(t *testing.T) {
 		t.Error("New value not set")
 	}
 }
+
+func TestNewLike(t *testing.T) {
+	template := New[string, int](64)
+	template.SetHasher(func(key string) uintptr {
+		return uintptr(len(key))
+	})
+	template.Set("one", 1)
+
+	m := NewLike(template)
+	if m.Len() != 0 {
+		t.Errorf("map created from a template should be empty but has %d items.", m.Len())
+	}
+	if n := len(m.metadata.Load().index); n != 64 {
+		t.Errorf("map should have the same initial size as the template, got: %d", n)
+	}
+	if m.hasher("four") != 4 {
+		t.Error("map should use the hasher of the template")
+	}
+	m.Set("two", 2)
+	if _, ok := template.Get("two"); ok {
+		t.Error("template should not be affected by the new map")
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var (
+		m     = New[int, string]()
+		calls int64
+		start = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	if _, err := m.GetE(1); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound without a loader, got: %v", err)
+	}
+	m.SetLoader(func(key int) (string, error) {
+		if key < 0 {
+			return "", fmt.Errorf("negative key %d", key)
+		}
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return strconv.Itoa(key), nil
+	})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if val, ok := m.Get(1); !ok || val != "1" {
+				t.Errorf("loaded value is not as expected: %q", val)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("concurrent misses should be coalesced into one loader call, got %d calls", n)
+	}
+	if m.Len() != 1 {
+		t.Error("loaded value should be stored in the map")
+	}
+	if _, err := m.GetE(-1); err == nil {
+		t.Error("loader error should be returned by GetE")
+	}
+	if _, ok := m.Get(-1); ok {
+		t.Error("ok should be false when the loader fails")
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	var (
+		m     = New[string, int]()
+		calls int64
+		start = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	loader := func(key string) (int, error) {
+		if key == "" {
+			return 0, fmt.Errorf("empty key")
+		}
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return len(key), nil
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if val, err := m.GetOrLoad("key", loader); err != nil || val != 3 {
+				t.Errorf("unexpected result %d %v", val, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("concurrent misses should be coalesced into one loader call, got %d calls", n)
+	}
+	if val, err := m.GetOrLoad("key", loader); err != nil || val != 3 || atomic.LoadInt64(&calls) != 1 {
+		t.Error("hit should not call the loader")
+	}
+	if _, err := m.GetOrLoad("", loader); err == nil || m.Len() != 1 {
+		t.Error("loader error should be returned and not cached")
+	}
+}
+
+func TestLoaderPanic(t *testing.T) {
+	var (
+		m                = New[string, int]()
+		entered, release = make(chan struct{}), make(chan struct{})
+		once             sync.Once
+		panics           int64
+		wg               sync.WaitGroup
+	)
+	m.SetLoader(func(string) (int, error) {
+		once.Do(func() { close(entered) })
+		<-release
+		panic("unavailable")
+	})
+	call := func(get func()) {
+		defer wg.Done()
+		defer func() {
+			if recover() == "unavailable" {
+				atomic.AddInt64(&panics, 1)
+			}
+		}()
+		get()
+	}
+	wg.Add(1)
+	go call(func() { m.Get("key") })
+	<-entered
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go call(func() {
+			if val, err := m.GetE("key"); err == nil {
+				t.Errorf("waiter of a panicking loader should not get %d", val)
+			}
+		})
+	}
+	time.Sleep(20 * time.Millisecond) // let the waiters block on the call
+	close(release)
+	wg.Wait()
+	if panics != 5 {
+		t.Errorf("panic of the loader should be propagated to all 5 callers, got %d", panics)
+	}
+	if len(m.loads.calls) != 0 {
+		t.Error("panicking call should be released")
+	}
+}
+
+func TestGetOrLoadKeyEqual(t *testing.T) {
+	var (
+		m = NewWithOptions[string, int](
+			WithHasher(func(key string) uintptr { return uintptr(len(key)) + 1 }),
+			WithKeyEqual(strings.EqualFold),
+		)
+		calls int64
+		start = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	loader := func(key string) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return len(key), nil
+	}
+	// keys equal under the custom equality share a single loader call
+	for _, key := range []string{"key", "KEY", "Key", "kEy"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			<-start
+			if val, err := m.GetOrLoad(key, loader); err != nil || val != 3 {
+				t.Errorf("unexpected result %d %v", val, err)
+			}
+		}(key)
+	}
+	// distinct keys with the same hash are loaded separately
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		if val, err := m.GetOrLoad("abc", loader); err != nil || val != 3 {
+			t.Errorf("unexpected result %d %v", val, err)
+		}
+	}()
+	close(start)
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 2 || m.Len() != 2 {
+		t.Errorf("misses of equal keys should be coalesced, got %d calls and %d entries", n, m.Len())
+	}
+	if len(m.loads.calls) != 0 {
+		t.Errorf("finished calls should be released, %d hashes left", len(m.loads.calls))
+	}
+}
+
+func TestGetOrSetConcurrent(t *testing.T) {
+	var (
+		m      = New[int, int]()
+		stored int64
+		wg     sync.WaitGroup
+		values = make([]int, 100)
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			val, loaded := m.GetOrSet(1, i)
+			if !loaded {
+				atomic.AddInt64(&stored, 1)
+			}
+			values[i] = val
+		}(i)
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Errorf("exactly one caller should have stored its value, got %d", stored)
+	}
+	current, _ := m.Get(1)
+	for _, val := range values {
+		if val != current {
+			t.Errorf("all callers should observe the stored value %d, got %d", current, val)
+		}
+	}
+	if m.Len() != 1 {
+		t.Errorf("map should contain exactly one element but has %d items.", m.Len())
+	}
+}
+
+func TestGetOrComputeOnce(t *testing.T) {
+	var (
+		m      = New[string, int]()
+		calls  int64
+		stored int64
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, loaded := m.GetOrCompute("key", func() int {
+				atomic.AddInt64(&calls, 1)
+				time.Sleep(time.Millisecond)
+				return 42
+			})
+			if !loaded {
+				atomic.AddInt64(&stored, 1)
+			}
+			if val != 42 {
+				t.Errorf("computed value is not as expected: %d", val)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("value constructor should be called exactly once, got %d calls", calls)
+	}
+	if stored != 1 {
+		t.Errorf("exactly one caller should report the value as stored, got %d", stored)
+	}
+}
+
+func TestGetOrComputeDuringFailingLoad(t *testing.T) {
+	m := New[string, int]()
+	entered, release := make(chan struct{}), make(chan struct{})
+	m.SetLoader(func(string) (int, error) {
+		close(entered)
+		<-release
+		return 0, errors.New("unavailable")
+	})
+	go m.Get("key")
+	<-entered
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	if val, loaded := m.GetOrCompute("key", func() int { return 42 }); val != 42 || loaded {
+		t.Errorf("constructor should not share the failed load, got %d, %v", val, loaded)
+	}
+}
+
+func TestGetAndDelConcurrent(t *testing.T) {
+	var (
+		m       = New[int, int]()
+		claimed int64
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if val, ok := m.GetAndDel(i); ok {
+					if val != i {
+						t.Errorf("claimed value is not as expected: %d", val)
+					}
+					atomic.AddInt64(&claimed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 100 {
+		t.Errorf("each value should be claimed exactly once, got %d claims", claimed)
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty but has %d items.", m.Len())
+	}
+}
+
+func TestSwapAbsent(t *testing.T) {
+	m := New[string, int]()
+	val, loaded := m.Swap("1", 1)
+	if loaded {
+		t.Error("loaded should be false when swapping an absent key")
+	}
+	if val != 0 {
+		t.Errorf("old value should be the zero value for an absent key, got %d", val)
+	}
+	if val, ok := m.Get("1"); !ok || val != 1 {
+		t.Error("value should be stored when swapping an absent key")
+	}
+	if m.Len() != 1 {
+		t.Errorf("map should contain exactly one element but has %d items.", m.Len())
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	m := New[string, int]()
+	if m.CompareAndDelete("1", 0) {
+		t.Error("Compare and Delete should fail for an absent key")
+	}
+	m.Set("1", 1)
+	if m.CompareAndDelete("1", 2) {
+		t.Error("Compare and Delete should fail when the value differs")
+	}
+	if _, ok := m.Get("1"); !ok {
+		t.Error("entry should not be deleted when the value differs")
+	}
+	if !m.CompareAndDelete("1", 1) {
+		t.Error("Compare and Delete failed")
+	}
+	if _, ok := m.Get("1"); ok {
+		t.Error("entry should be deleted")
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty but has %d items.", m.Len())
+	}
+}
+
+func TestCompareAndDeleteConcurrentSet(t *testing.T) {
+	testConditionalDeleteConcurrentSet(t, func(m *Map[int, int], key, value int) bool {
+		return m.CompareAndDelete(key, value)
+	})
+}
+
+// testConditionalDeleteConcurrentSet races a writer setting increasing values of a key against a deleter removing the
+// key only if it holds the value it read, every value found missing right after being set must have been deleted by
+// a successful conditional delete of that very value
+func testConditionalDeleteConcurrentSet(t *testing.T, del func(m *Map[int, int], key, value int) bool) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4)) // runs the writer and the deleter in parallel even on a single core
+	var (
+		m       = New[int, int]()
+		wg      sync.WaitGroup
+		done    = make(chan struct{})
+		deleted = make(map[int]bool)
+		missing []int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if value, ok := m.Get(0); ok && del(m, 0, value) {
+				deleted[value] = true
+			}
+		}
+	}()
+	for value, deadline := 1, time.Now().Add(200*time.Millisecond); time.Now().Before(deadline); value++ {
+		m.Set(0, value)
+		if current, ok := m.Get(0); !ok || current != value {
+			missing = append(missing, value)
+		}
+	}
+	close(done)
+	wg.Wait()
+	for _, value := range missing {
+		if !deleted[value] {
+			t.Fatalf("value %d was lost without being deleted", value)
+		}
+	}
+}
+
+func TestSetIfAbsentAndPresent(t *testing.T) {
+	m := New[string, int]()
+	if m.SetIfPresent("1", 1) {
+		t.Error("SetIfPresent should fail for an absent key")
+	}
+	if m.Len() != 0 {
+		t.Error("SetIfPresent should not insert an absent key")
+	}
+	if !m.SetIfAbsent("1", 1) {
+		t.Error("SetIfAbsent should succeed for an absent key")
+	}
+	if m.SetIfAbsent("1", 2) {
+		t.Error("SetIfAbsent should fail for a present key")
+	}
+	if val, _ := m.Get("1"); val != 1 {
+		t.Error("SetIfAbsent should not overwrite an existing value")
+	}
+	if !m.SetIfPresent("1", 3) {
+		t.Error("SetIfPresent should succeed for a present key")
+	}
+	if val, _ := m.Get("1"); val != 3 {
+		t.Error("SetIfPresent should update the existing value")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	var (
+		m  = New[string, int]()
+		wg sync.WaitGroup
+	)
+	increment := func(oldValue int, loaded bool) (int, bool) {
+		return oldValue + 1, false
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Compute("counter", increment)
+			}
+		}()
+	}
+	wg.Wait()
+	if val, ok := m.Get("counter"); !ok || val != 8000 {
+		t.Errorf("concurrent increments should not be lost, got %d", val)
+	}
+
+	val, ok := m.Compute("counter", func(oldValue int, loaded bool) (int, bool) {
+		if !loaded {
+			t.Error("loaded should be true for a present key")
+		}
+		return 0, true
+	})
+	if ok || val != 0 {
+		t.Error("entry should be reported as absent after deletion")
+	}
+	if _, ok := m.Get("counter"); ok {
+		t.Error("entry should be deleted")
+	}
+	if _, ok := m.Compute("absent", func(int, bool) (int, bool) { return 0, true }); ok {
+		t.Error("deleting an absent key should not insert it")
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty but has %d items.", m.Len())
+	}
+}
+
+func TestComputeDeleteConcurrentSet(t *testing.T) {
+	testConditionalDeleteConcurrentSet(t, func(m *Map[int, int], key, value int) bool {
+		deleted := false
+		m.Compute(key, func(oldValue int, loaded bool) (int, bool) {
+			deleted = loaded && oldValue == value
+			return oldValue, deleted
+		})
+		return deleted
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	var (
+		m     = New[string, []int]()
+		wg    sync.WaitGroup
+		merge = func(existing, incoming []int) []int {
+			return append(append([]int(nil), existing...), incoming...)
+		}
+	)
+	if val := m.Upsert("label", []int{0}, merge); len(val) != 1 {
+		t.Errorf("value should be inserted as is for an absent key, got %v", val)
+	}
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Upsert("label", []int{i}, merge)
+		}(i)
+	}
+	wg.Wait()
+	if val, _ := m.Get("label"); len(val) != 101 {
+		t.Errorf("concurrent merges should not be lost, got %d values", len(val))
+	}
+}
+
+func TestClearConcurrent(t *testing.T) {
+	var (
+		m    = New[int, int]()
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				m.Set(worker*1000+i%1000, i)
+			}
+		}(worker)
+	}
+	for i := 0; i < 20; i++ {
+		m.Clear()
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	m.Clear()
+	if m.Len() != 0 {
+		t.Errorf("map should be empty after clear but has %d items.", m.Len())
+	}
+	if n := len(m.metadata.Load().index); n != defaultSize {
+		t.Errorf("index should be reset to its initial size after clear, got %d", n)
+	}
+	m.ForEach(func(key, value int) bool {
+		t.Errorf("map should be empty after clear but got key -> %d", key)
+		return false
+	})
+}
+
+func TestSetMany(t *testing.T) {
+	m := New[int, string]()
+	m.Set(1, "old")
+	pairs := make([]Pair[int, string], 0, 1001)
+	for i := 0; i < 1000; i++ {
+		pairs = append(pairs, Pair[int, string]{Key: i, Value: strconv.Itoa(i)})
+	}
+	pairs = append(pairs, Pair[int, string]{Key: 0, Value: "last"})
+	m.SetMany(pairs...)
+
+	if m.Len() != 1000 {
+		t.Errorf("map should contain exactly 1000 elements but has %d items.", m.Len())
+	}
+	if val, _ := m.Get(0); val != "last" {
+		t.Errorf("last duplicate key in the batch should win, got %q", val)
+	}
+	for i := 1; i < 1000; i++ {
+		if val, ok := m.Get(i); !ok || val != strconv.Itoa(i) {
+			t.Errorf("item is not as expected for key %d: %q", i, val)
+		}
+	}
+	pairs[1].Value = "modified"
+	if val, _ := m.Get(1); val != "1" {
+		t.Error("modifying the given pairs should not affect the map")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 1000; i += 2 {
+		m.Set(i, strconv.Itoa(i))
+	}
+	keys := make([]int, 0, 1000)
+	for i := 999; i >= 0; i-- {
+		keys = append(keys, i)
+	}
+	values, found := m.GetMany(keys...)
+	for idx, key := range keys {
+		if found[idx] != (key%2 == 0) {
+			t.Errorf("found mask is not as expected for key %d", key)
+		}
+		if found[idx] && values[idx] != strconv.Itoa(key) {
+			t.Errorf("item is not as expected for key %d: %q", key, values[idx])
+		}
+	}
+
+	m.SetHasher(func(key int) uintptr {
+		return uintptr(key%3 + 1)
+	})
+	m.Clear()
+	for i := 0; i < 10; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	values, found = m.GetMany(9, 0, 3, 6, 10)
+	for idx, key := range []int{9, 0, 3, 6} {
+		if !found[idx] || values[idx] != strconv.Itoa(key) {
+			t.Errorf("colliding key %d should be found", key)
+		}
+	}
+	if found[4] {
+		t.Error("absent colliding key should not be found")
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	keys := make([]int, 0, 500)
+	for i := 999; i >= 0; i -= 2 {
+		keys = append(keys, i)
+	}
+	m.Del(keys...)
+	if m.Len() != 500 {
+		t.Errorf("map should contain exactly 500 elements but has %d items.", m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := m.Get(i); ok != (i%2 == 0) {
+			t.Errorf("deletion result is not as expected for key %d", i)
+		}
+	}
+
+	// keys sharing the same hash must all be deleted regardless of their order in the batch
+	m.SetHasher(func(key int) uintptr {
+		return uintptr(key%3 + 1)
+	})
+	m.Clear()
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	m.Del(9, 6, 3, 0, 7, 4)
+	if m.Len() != 4 {
+		t.Errorf("map should contain exactly 4 elements but has %d items.", m.Len())
+	}
+	for _, key := range []int{1, 2, 5, 8} {
+		if _, ok := m.Get(key); !ok {
+			t.Errorf("key %d should not be deleted", key)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ours, theirs := New[string, int](), New[string, int]()
+	ours.Set("a", 1)
+	ours.Set("b", 2)
+	theirs.Set("b", 20)
+	theirs.Set("c", 30)
+
+	ours.Merge(theirs, func(o, t int) int {
+		return o + t
+	})
+	for key, expected := range map[string]int{"a": 1, "b": 22, "c": 30} {
+		if val, ok := ours.Get(key); !ok || val != expected {
+			t.Errorf("merged value for key %s is not as expected: %d", key, val)
+		}
+	}
+	if theirs.Len() != 2 {
+		t.Error("merging should not modify the other map")
+	}
+
+	ours.Merge(theirs, nil)
+	if val, _ := ours.Get("b"); val != 20 {
+		t.Errorf("other map's value should win without a resolver, got %d", val)
+	}
+	ours.Merge(ours, func(o, t int) int {
+		return o + t
+	})
+	if val, _ := ours.Get("a"); val != 1 || ours.Len() != 3 {
+		t.Error("merging a map into itself should be a no-op")
+	}
+}
+
+func TestNewFromMap(t *testing.T) {
+	src := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		src[strconv.Itoa(i)] = i
+	}
+	m := NewFromMap(src)
+	if m.Len() != uintptr(len(src)) {
+		t.Errorf("map should contain exactly %d elements but has %d items.", len(src), m.Len())
+	}
+	for key, expected := range src {
+		if val, ok := m.Get(key); !ok || val != expected {
+			t.Errorf("item is not as expected for key %s: %d", key, val)
+		}
+	}
+	if resizeNeeded(uintptr(len(m.metadata.Load().index)), m.Len(), m.maxFillRate) {
+		t.Error("map should be pre-allocated to fit all entries")
+	}
+	if n := len(NewFromMap(src, 1<<14).metadata.Load().index); n != 1<<14 {
+		t.Errorf("map should honour a larger provided size, got %d", n)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	m.Del(0, 1)
+	gomap := m.ToMap()
+	if len(gomap) != 98 {
+		t.Errorf("copy should contain exactly 98 elements but has %d items.", len(gomap))
+	}
+	for i := 2; i < 100; i++ {
+		if gomap[i] != strconv.Itoa(i) {
+			t.Errorf("item is not as expected for key %d: %q", i, gomap[i])
+		}
+	}
+	gomap[1000] = "1000"
+	if _, ok := m.Get(1000); ok {
+		t.Error("modifying the copy should not affect the map")
+	}
+}
+
+func TestKeysAndValues(t *testing.T) {
+	m := New[int, string]()
+	if len(m.Keys()) != 0 || len(m.Values()) != 0 {
+		t.Error("keys and values of an empty map should be empty")
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	m.Del(0)
+	keys, values := m.Keys(), m.Values()
+	if len(keys) != 99 || len(values) != 99 {
+		t.Fatalf("expected 99 keys and values, got %d keys and %d values", len(keys), len(values))
+	}
+	for idx := range keys {
+		if keys[idx] == 0 {
+			t.Error("deleted key should not be returned")
+		}
+		if values[idx] != strconv.Itoa(keys[idx]) {
+			t.Errorf("values should be in the same order as keys, got %q for key %d", values[idx], keys[idx])
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	m := New[int, int](100)
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	m.Del(0)
+
+	var (
+		drained = make(map[int]int)
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Drain(func(key, value int) {
+				mu.Lock()
+				drained[key]++
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	if len(drained) != 999 {
+		t.Errorf("expected 999 drained entries, got %d", len(drained))
+	}
+	for key, times := range drained {
+		if times != 1 || key == 0 {
+			t.Errorf("key %d drained %d times", key, times)
+		}
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty after drain but has %d items.", m.Len())
+	}
+	m.Set(1, 1)
+	if val, ok := m.Get(1); !ok || val != 1 {
+		t.Error("map should be usable after drain")
+	}
+}
+
+func TestDrainConcurrentDel(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	drained := 0
+	m.OnDelete(func(key, value int) {
+		if key == 500 && drained == 0 {
+			// the deletion of 500 claimed its element but did not count it yet when the map is drained
+			m.Drain(func(int, int) { drained++ })
+		}
+	})
+	m.Del(500)
+	if drained != 999 {
+		t.Errorf("expected 999 drained entries, got %d", drained)
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty after drain and deletion but has %d items.", m.Len())
+	}
+
+	// concurrent deletions while draining
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	m = New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	var (
+		deleted int64
+		wg      sync.WaitGroup
+	)
+	drained = 0
+	for worker := 0; worker < 2; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < 1000; i += 2 {
+				if _, ok := m.GetAndDel(i); ok {
+					atomic.AddInt64(&deleted, 1)
+				}
+			}
+		}(worker)
+	}
+	m.Drain(func(int, int) { drained++ })
+	wg.Wait()
+	if drained+int(deleted) != 1000 {
+		t.Errorf("%d drained and %d deleted entries instead of 1000 in total", drained, deleted)
+	}
+	if m.Len() != 0 {
+		t.Errorf("map should be empty after drain and deletions but has %d items.", m.Len())
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := New[string, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.Del("0")
+	clone := m.Clone()
+	if clone.Len() != m.Len() {
+		t.Errorf("clone should contain %d elements but has %d items.", m.Len(), clone.Len())
+	}
+	if len(clone.metadata.Load().index) != len(m.metadata.Load().index) {
+		t.Error("clone should have the same index size as the original map")
+	}
+	for i := 1; i < 1000; i++ {
+		if val, ok := clone.Get(strconv.Itoa(i)); !ok || val != i {
+			t.Errorf("item is not as expected for key %d: %d", i, val)
+		}
+	}
+	if _, ok := clone.Get("0"); ok {
+		t.Error("deleted entries should not be cloned")
+	}
+	clone.Set("1", 100)
+	clone.Set("new", 1)
+	clone.Del("2")
+	if val, _ := m.Get("1"); val != 1 {
+		t.Error("updating the clone should not affect the original map")
+	}
+	if _, ok := m.Get("2"); !ok || m.Len() != 999 {
+		t.Error("modifying the clone should not affect the original map")
+	}
+}
+
+func TestForEachParallel(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	m.Del(0)
+	for _, workers := range []int{0, 1, 3, 8, 1 << 20} {
+		var (
+			visited = make([]int32, 10000)
+			total   int64
+		)
+		m.ForEachParallel(workers, func(key, value int) {
+			atomic.AddInt32(&visited[key], 1)
+			atomic.AddInt64(&total, 1)
+		})
+		if total != 9999 {
+			t.Errorf("expected 9999 visited pairs with %d workers, got %d", workers, total)
+		}
+		for key := 1; key < 10000; key++ {
+			if visited[key] != 1 {
+				t.Errorf("key %d visited %d times with %d workers", key, visited[key], workers)
+			}
+		}
+	}
+}
+
+func TestForEachConsistency(t *testing.T) {
+	var (
+		m    = New[int, int]()
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i) // stable entries which are never modified
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m.Set(1000+i%1000, i)
+			m.Del(1000 + (i+500)%1000)
+		}
+	}()
+	for round := 0; round < 50; round++ {
+		seen := make(map[int]bool)
+		m.ForEach(func(key, value int) bool {
+			if seen[key] {
+				t.Errorf("key %d yielded twice", key)
+			}
+			seen[key] = true
+			return true
+		})
+		for i := 0; i < 1000; i++ {
+			if !seen[i] {
+				t.Errorf("unmodified key %d was not yielded", i)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestSortedIteration(t *testing.T) {
+	m := New[int, string]()
+	for i := 99; i >= 0; i-- {
+		m.Set(i, strconv.Itoa(i))
+	}
+
+	expected := 0
+	ForEachSorted(m, func(key int, value string) bool {
+		if key != expected || value != strconv.Itoa(key) {
+			t.Errorf("expected key %d, got %d", expected, key)
+		}
+		expected++
+		return expected < 50
+	})
+	if expected != 50 {
+		t.Errorf("iteration should stop when lambda returns false, got %d pairs", expected)
+	}
+
+	expected = 10
+	RangeSorted(m, 10, 20, func(key int, value string) bool {
+		if key != expected {
+			t.Errorf("expected key %d, got %d", expected, key)
+		}
+		expected++
+		return true
+	})
+	if expected != 20 {
+		t.Errorf("range should yield keys 10 to 19, stopped at %d", expected)
+	}
+}
+
+func TestReverseIteration(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+
+	var forward, backward []int
+	m.ForEach(func(key int, value string) bool {
+		forward = append(forward, key)
+		return true
+	})
+	m.ForEachReverse(func(key int, value string) bool {
+		backward = append(backward, key)
+		return true
+	})
+	if len(forward) != len(backward) {
+		t.Fatalf("expected %d keys in reverse, got %d", len(forward), len(backward))
+	}
+	for idx := range forward {
+		if forward[idx] != backward[len(backward)-1-idx] {
+			t.Fatal("reverse iteration should yield keys in the reverse order of ForEach")
+		}
+	}
+
+	expected := 99
+	ForEachSortedReverse(m, func(key int, value string) bool {
+		if key != expected {
+			t.Errorf("expected key %d, got %d", expected, key)
+		}
+		expected--
+		return expected > 89
+	})
+	if expected != 89 {
+		t.Errorf("iteration should stop when lambda returns false, stopped at %d", expected)
+	}
+}
+
+func TestCursorIteration(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	var (
+		seen   = make(map[int]int)
+		cursor Cursor
+		pages  int
+	)
+	for !cursor.Done() {
+		// restore the iterator from the serialized cursor like a paginated API would
+		restored, err := ParseCursor(cursor.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pairs []Pair[int, int]
+		pairs, cursor = m.IteratorFrom(restored).Next(64)
+		for _, pair := range pairs {
+			seen[pair.Key]++
+		}
+		if len(pairs) > 64 {
+			t.Errorf("page should not exceed the requested size without collisions, got %d", len(pairs))
+		}
+		if pages++; pages == 2 {
+			m.Del(999, 998) // modifying the map between pages is allowed
+		}
+	}
+	if pages != 1000/64+1 {
+		t.Errorf("expected %d pages, got %d", 1000/64+1, pages)
+	}
+	for i := 0; i < 998; i++ {
+		if seen[i] != 1 {
+			t.Errorf("key %d yielded %d times", i, seen[i])
+		}
+	}
+	if pairs, _ := m.Iterator().Next(0); len(pairs) != 0 {
+		t.Error("an empty page should be returned for a non-positive page size")
+	}
+	if _, err := ParseCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	even := m.Filter(func(key int, value string) bool {
+		return key%2 == 0
+	})
+	if even.Len() != 500 {
+		t.Errorf("filtered map should contain exactly 500 elements but has %d items.", even.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if val, ok := even.Get(i); ok != (i%2 == 0) || ok && val != strconv.Itoa(i) {
+			t.Errorf("filtered result is not as expected for key %d", i)
+		}
+	}
+	if resizeNeeded(uintptr(len(even.metadata.Load().index)), even.Len(), even.maxFillRate) {
+		t.Error("filtered map should be sized to fit its entries")
+	}
+	even.Set(1, "1")
+	if m.Len() != 1000 || even.Len() != 501 {
+		t.Error("filtered map should be independent of the original map")
+	}
+}
+
+func TestReduceAndMapValues(t *testing.T) {
+	m := New[string, int]()
+	for i := 1; i <= 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	sum := Reduce(m, 0, func(acc int, key string, value int) int {
+		return acc + value
+	})
+	if sum != 5050 {
+		t.Errorf("expected sum 5050, got %d", sum)
+	}
+
+	squares := MapValues(m, func(key string, value int) float64 {
+		return float64(value * value)
+	})
+	if squares.Len() != 100 {
+		t.Errorf("mapped map should contain exactly 100 elements but has %d items.", squares.Len())
+	}
+	for i := 1; i <= 100; i++ {
+		if val, ok := squares.Get(strconv.Itoa(i)); !ok || val != float64(i*i) {
+			t.Errorf("mapped value is not as expected for key %d: %v", i, val)
+		}
+	}
+}
+
+func TestDeleteWithinForEach(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	visited := make(map[int]int)
+	m.ForEach(func(key, value int) bool {
+		visited[key]++
+		if key%3 == 0 {
+			m.Del(key)
+		}
+		return true
+	})
+	if len(visited) != 1000 {
+		t.Errorf("all entries should be visited, got %d", len(visited))
+	}
+	for key, times := range visited {
+		if times != 1 {
+			t.Errorf("key %d visited %d times", key, times)
+		}
+	}
+	if m.Len() != 666 {
+		t.Errorf("map should contain exactly 666 elements but has %d items.", m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := m.Get(i); ok != (i%3 != 0) {
+			t.Errorf("deletion result is not as expected for key %d", i)
+		}
+	}
+}
+
+func TestNoBackgroundGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	maps := make([]*Map[int, int], 0, 100)
+	for i := 0; i < 100; i++ {
+		m := New[int, int]()
+		for j := 0; j < 100; j++ {
+			m.Set(j, j) // triggers several resizes
+		}
+		maps = append(maps, m)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("maps should not spawn goroutines, got %d goroutines before and %d after", before, after)
+	}
+	runtime.KeepAlive(maps)
+}
+
+func TestGrowAndWait(t *testing.T) {
+	m := New[int, int]()
+	m.resizing.Store(resizingInProgress) // simulate a concurrent resize in progress
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.GrowAndWait(1000)
+	}()
+	select {
+	case <-done:
+		t.Fatal("GrowAndWait should wait for the in-progress resize")
+	case <-time.After(10 * time.Millisecond):
+	}
+	m.resizing.Store(notResizing)
+	<-done
+	if n := len(m.metadata.Load().index); n != 1024 {
+		t.Errorf("index size should be 1024 after GrowAndWait, got %d", n)
+	}
+	m.GrowAndWait(16)
+	if n := len(m.metadata.Load().index); n != 1024 {
+		t.Errorf("GrowAndWait should never shrink the map, got %d", n)
+	}
+	m.GrowAndWait(0)
+	if n := len(m.metadata.Load().index); n != 2048 {
+		t.Errorf("GrowAndWait(0) should double the size, got %d", n)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	grown := len(m.metadata.Load().index)
+	for i := 100; i < 10000; i++ {
+		m.Del(i)
+	}
+	if n := len(m.metadata.Load().index); n != grown {
+		t.Errorf("map should not shrink automatically by default, got %d", n)
+	}
+	m.Shrink()
+	if n := len(m.metadata.Load().index); n != 512 {
+		t.Errorf("index size should be 512 after shrinking 100 entries, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		if val, ok := m.Get(i); !ok || val != i {
+			t.Errorf("item is not as expected for key %d after shrinking", i)
+		}
+	}
+
+	m.SetMinFillRate(10)
+	for i := 100; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	for i := 10; i < 10000; i++ {
+		m.Del(i)
+	}
+	if n := len(m.metadata.Load().index); n > 64 {
+		t.Errorf("map should shrink automatically below the min fill rate, got %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		if val, ok := m.Get(i); !ok || val != i {
+			t.Errorf("item is not as expected for key %d after shrinking", i)
+		}
+	}
+	m.Del(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	if n := len(m.metadata.Load().index); n != defaultSize {
+		t.Errorf("map should not shrink below its initial size, got %d", n)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	m := New[int, int]()
+	m.Set(-1, -1)
+	m.Reserve(1000)
+	size := len(m.metadata.Load().index)
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	if n := len(m.metadata.Load().index); n != size {
+		t.Errorf("inserting the reserved number of entries should not resize the map, got %d instead of %d", n, size)
+	}
+	m.Reserve(0)
+	if n := len(m.metadata.Load().index); n != size {
+		t.Errorf("reserving no additional entries should not resize the map, got %d", n)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	size := len(m.metadata.Load().index)
+	for i := 0; i < 1000; i += 2 {
+		m.Del(i)
+	}
+	m.Compact()
+	linked := 0
+	for item := m.listHead.nextPtr.Load(); item != nil; item = item.nextPtr.Load() {
+		if item.isDeleted() {
+			t.Errorf("deleted key %d is still linked after compaction", item.key)
+		}
+		linked++
+	}
+	if linked != 500 {
+		t.Errorf("expected 500 linked elements after compaction, got %d", linked)
+	}
+	if n := len(m.metadata.Load().index); n != size {
+		t.Errorf("compaction should keep the index size, got %d instead of %d", n, size)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := m.Get(i); ok != (i%2 == 1) {
+			t.Errorf("lookup result is not as expected for key %d after compaction", i)
+		}
+	}
+}
+
+func TestMemStats(t *testing.T) {
+	m := New[int, int](1024)
+	if stats := m.MemStats(); stats.Elements != 0 || stats.OverheadPerEntry != 0 {
+		t.Errorf("empty map should report no elements, got %#v", stats)
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.Del(0)
+	stats := m.MemStats()
+	if stats.IndexSlots != 1024 || stats.IndexBytes != 1024*intSizeBytes {
+		t.Errorf("index size is not as expected: %#v", stats)
+	}
+	if stats.Elements != 99 {
+		t.Errorf("expected 99 live elements, got %d", stats.Elements)
+	}
+	if stats.Elements+stats.DeletedElements > 100 {
+		t.Errorf("at most 100 elements should be linked, got %d", stats.Elements+stats.DeletedElements)
+	}
+	if stats.TotalBytes != stats.IndexBytes+(stats.Elements+stats.DeletedElements)*stats.ElementBytes {
+		t.Errorf("total bytes are not as expected: %#v", stats)
+	}
+	m.Compact()
+	if stats := m.MemStats(); stats.DeletedElements != 0 {
+		t.Errorf("no deleted elements should be linked after compaction, got %d", stats.DeletedElements)
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := New[int, int](8)
+	m.Set(1, 1)
+	m.Get(1)
+	if stats := m.Stats(); stats.Lookups != 0 || stats.Resizes != 0 {
+		t.Errorf("counters should be disabled by default, got %#v", stats)
+	}
+
+	if !statsEnabled {
+		t.Skip("statistics are compiled out")
+	}
+	m = NewWithOptions[int, int](WithInitialSize(8), WithStats())
+	if stats := m.Stats(); stats != (Stats{}) || stats.AverageProbeLength() != 0 {
+		t.Errorf("new map should report no operations, got %#v", stats)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 4000; i += 4 {
+				m.Set(i, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := 0; i < 5000; i++ {
+		m.Get(i)
+	}
+	stats := m.Stats()
+	if stats.Resizes == 0 {
+		t.Error("growing the map should be counted as resizes")
+	}
+	if stats.Lookups != 5000 || stats.ProbeSteps < 4000 || stats.MaxProbeLength == 0 {
+		t.Errorf("lookups are not accounted as expected: %#v", stats)
+	}
+	if avg := stats.AverageProbeLength(); avg < 0.8 || avg > float64(stats.MaxProbeLength) {
+		t.Errorf("average probe length %f is out of bounds", avg)
+	}
+	if stats.FillRate != m.Fillrate() {
+		t.Errorf("fill rate should be reported, got %d instead of %d", stats.FillRate, m.Fillrate())
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	m := NewWithOptions[int, int](WithStats())
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.Get(1)
+	v := m.PublishExpvar("haxmap_test")
+	if published := expvar.Get("haxmap_test"); published == nil || published.String() != v.String() {
+		t.Fatal("variable should be published")
+	}
+	var got map[string]uintptr
+	if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["len"] != 100 || got["fill_rate"] != m.Fillrate() {
+		t.Errorf("unexpected variable %s", v.String())
+	}
+	if statsEnabled && got["lookups"] != 1 {
+		t.Errorf("statistics should be reported, got %s", v.String())
+	}
+	m.Del(1)
+	if !strings.Contains(v.String(), `"len":99`) {
+		t.Errorf("variable should be computed when formatted, got %s", v.String())
+	}
+}
+
+func TestDebugDistribution(t *testing.T) {
+	m := New[int, int](1024)
+	for i := 0; i < 500; i++ {
+		m.Set(i, i)
+	}
+	dist := m.DebugDistribution()
+	if dist.Slots != 1024 || dist.Entries != 500 || dist.HashCollisions != 0 {
+		t.Errorf("unexpected distribution %+v", dist)
+	}
+	var slots, entries uintptr
+	for n, count := range dist.Histogram {
+		slots += count
+		entries += uintptr(n) * count
+	}
+	if slots != dist.Slots || entries != dist.Entries || dist.EmptySlots != dist.Histogram[0] {
+		t.Errorf("histogram does not add up: %v", dist.Histogram)
+	}
+
+	// a hasher ignoring the low bits clusters the entries into a few slots
+	clustered := New[int, int](1024)
+	clustered.SetHasher(func(key int) uintptr { return uintptr(key%4)<<(strconv.IntSize-2) + 1 })
+	for i := 0; i < 500; i++ {
+		clustered.Set(i, i)
+	}
+	dist = clustered.DebugDistribution()
+	if dist.LongestChain != 125 || dist.EmptySlots != dist.Slots-4 || dist.HashCollisions != 496 {
+		t.Errorf("clustering should show up in the distribution %+v", dist)
+	}
+
+	var b strings.Builder
+	if err := clustered.DumpIndex(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "longest chain 125") || !strings.Contains(b.String(), "most crowded slots") {
+		t.Errorf("unexpected dump:\n%s", b.String())
+	}
+}
+
+func TestMutationHooks(t *testing.T) {
+	var events []string
+	m := NewWithOptions[string, int](
+		WithOnInsert(func(key string, value int) {
+			events = append(events, fmt.Sprintf("insert %s=%d", key, value))
+		}),
+		WithOnUpdate(func(key string, oldValue, newValue int) {
+			events = append(events, fmt.Sprintf("update %s=%d->%d", key, oldValue, newValue))
+		}),
+		WithOnDelete(func(key string, oldValue int) {
+			events = append(events, fmt.Sprintf("delete %s=%d", key, oldValue))
+		}),
+	)
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.SetIfAbsent("a", 3)
+	m.SetIfPresent("a", 4)
+	m.GetOrSet("b", 1)
+	m.Swap("b", 2)
+	m.CompareAndSwap("b", 2, 3)
+	m.Compute("c", func(int, bool) (int, bool) { return 1, false })
+	m.Compute("c", func(old int, _ bool) (int, bool) { return old + 1, false })
+	m.SetMany(Pair[string, int]{"a", 5}, Pair[string, int]{"d", 1})
+	SetBytes(m, []byte("d"), 2)
+	m.Compute("c", func(int, bool) (int, bool) { return 0, true })
+	m.Del("a")
+	m.GetAndDel("b")
+	m.CompareAndDelete("d", 2)
+	m.Del("missing")
+	expected := []string{
+		"insert a=1", "update a=1->2", "update a=2->4", "insert b=1", "update b=1->2", "update b=2->3",
+		"insert c=1", "update c=1->2", "insert d=1", "update a=4->5", "update d=1->2",
+		"delete c=2", "delete a=5", "delete b=3", "delete d=2",
+	}
+	sort.Strings(events[8:10]) // SetMany writes in the order of the hashes
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("unexpected events\n%v\nexpected\n%v", events, expected)
+	}
+
+	// every removal is reported, including expiry, eviction, Clear and Drain
+	events = events[:0]
+	now := int64(0)
+	m.clock = func() int64 { return now }
+	m.SetWithTTL("e", 1, time.Second)
+	now += int64(2 * time.Second)
+	m.Get("e")
+	m.Set("f", 1)
+	m.Drain(func(string, int) {})
+	m.Set("g", 1)
+	m.Clear()
+	expected = []string{"insert e=1", "delete e=1", "insert f=1", "delete f=1", "insert g=1", "delete g=1"}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("unexpected events\n%v\nexpected\n%v", events, expected)
+	}
+
+	// a derived index kept in sync under concurrent writes
+	var (
+		mu    sync.Mutex
+		index = map[int]int{}
+	)
+	n := New[int, int]()
+	n.OnInsert(func(key, value int) { mu.Lock(); index[key] = value; mu.Unlock() })
+	n.OnDelete(func(key, _ int) { mu.Lock(); delete(index, key); mu.Unlock() })
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 4000; i += 4 {
+				n.Set(i, i)
+				if i%3 == 0 {
+					n.Del(i)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if uintptr(len(index)) != n.Len() {
+		t.Errorf("derived index has %d entries instead of %d", len(index), n.Len())
+	}
+	if NewLike(n).onInsert != nil {
+		t.Error("hooks should not be carried over by NewLike")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 0)
+	values, cancel := m.Watch("a")
+	all, cancelAll := m.WatchAll()
+	m.Set("b", 1)
+	m.Set("a", 1)
+	if val := <-values; val != 1 {
+		t.Errorf("watcher should receive the new value, got %d", val)
+	}
+	if pair := <-all; pair.Key != "b" || pair.Value != 1 {
+		t.Errorf("unexpected pair %v", pair)
+	}
+	if pair := <-all; pair.Key != "a" || pair.Value != 1 {
+		t.Errorf("unexpected pair %v", pair)
+	}
+
+	// a slow watcher only gets the latest value, writers never block
+	for i := 2; i < 1000; i++ {
+		m.Set("a", i)
+	}
+	if val := <-values; val != 999 {
+		t.Errorf("watcher should receive the latest value, got %d", val)
+	}
+	received := 0
+	for len(all) > 0 {
+		pair := <-all
+		received++
+		if received == watchBuffer && pair.Value != 999 {
+			t.Errorf("the newest pair should be kept, got %v", pair)
+		}
+	}
+	if received != watchBuffer {
+		t.Errorf("buffered pairs should be bounded, got %d", received)
+	}
+
+	// the last delivered value matches the map once concurrent writes settle
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Set("a", w*1000+i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	last := <-values
+	if current, _ := m.Get("a"); last != current {
+		t.Errorf("last delivered value %d should be the current one %d", last, current)
+	}
+
+	cancel()
+	cancelAll()
+	m.Set("a", -1)
+	if _, ok := <-values; ok {
+		t.Error("cancelled watcher should be closed")
+	}
+	for range all {
+	}
+	if w := m.watchers.Load(); len(w.keys) != 0 || len(w.all) != 0 {
+		t.Error("cancelled watchers should be removed")
+	}
+}
+
+// countingInstrumentation counts the events reported by a map
+type countingInstrumentation struct {
+	ops              [OpDelete + 1]int64
+	resizes, ended   int64
+	oldSize, newSize uintptr
+}
+
+func (c *countingInstrumentation) Count(op Operation) {
+	atomic.AddInt64(&c.ops[op], 1)
+}
+
+func (c *countingInstrumentation) Resize(oldSize, newSize uintptr) func() {
+	atomic.AddInt64(&c.resizes, 1)
+	c.oldSize, c.newSize = oldSize, newSize
+	return func() { atomic.AddInt64(&c.ended, 1) }
+}
+
+func TestInstrumentation(t *testing.T) {
+	instrumentation := &countingInstrumentation{}
+	m := NewWithOptions[int, int](WithInitialSize(8), WithInstrumentation(instrumentation))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.Set(0, 1)
+	m.Get(1)
+	m.Get(-1)
+	m.Del(1, 2)
+	m.Clear()
+	expected := [...]int64{OpGetHit: 1, OpGetMiss: 1, OpInsert: 100, OpUpdate: 1, OpDelete: 100}
+	if instrumentation.ops != expected {
+		t.Errorf("unexpected operation counts %v", instrumentation.ops)
+	}
+	if instrumentation.resizes == 0 || instrumentation.ended != instrumentation.resizes {
+		t.Errorf("every resize should be reported and ended, got %d and %d", instrumentation.resizes, instrumentation.ended)
+	}
+	if instrumentation.newSize <= instrumentation.oldSize {
+		t.Errorf("growing should report a larger size, got %d to %d", instrumentation.oldSize, instrumentation.newSize)
+	}
+	if NewLike(m).instrument != instrumentation {
+		t.Error("instrumentation should be carried over by NewLike")
+	}
+	if OpGetMiss.String() != "get_miss" || Operation(42).String() != "unknown" {
+		t.Error("unexpected operation names")
+	}
+}
+
+func TestInstrumentationLookups(t *testing.T) {
+	instrumentation := &countingInstrumentation{}
+	m := NewWithOptions[string, int](WithInstrumentation(instrumentation))
+	m.Set("a", 1)
+	m.GetE("a")
+	m.GetE("b")
+	GetBytes(m, []byte("a"))
+	GetBytes(m, []byte("b"))
+	m.GetOrLoad("a", func(string) (int, error) { return 0, nil })
+	m.GetRefresh("a")
+	m.SetLoader(func(key string) (int, error) { return len(key), nil })
+	GetBytes(m, []byte("c"))
+	expected := [...]int64{OpGetHit: 4, OpGetMiss: 3, OpInsert: 2, OpDelete: 0}
+	if instrumentation.ops != expected {
+		t.Errorf("lookups besides Get should be counted once like Get, got %v", instrumentation.ops)
+	}
+}
+
+func TestOnResize(t *testing.T) {
+	var events []ResizeEvent
+	now := int64(0)
+	m := NewWithOptions[int, int](WithInitialSize(8), WithOnResize(func(event ResizeEvent) {
+		events = append(events, event)
+		now += int64(time.Millisecond)
+	}))
+	m.clock = func() int64 { return now }
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	if len(events) == 0 || len(events)%2 != 0 {
+		t.Fatalf("resizes should be reported when they start and finish, got %d events", len(events))
+	}
+	started, finished := events[0], events[1]
+	if started.Finished || started.Duration != 0 || started.OldSize != 8 || started.NewSize <= started.OldSize {
+		t.Errorf("unexpected start event %+v", started)
+	}
+	if !finished.Finished || finished.Duration != time.Millisecond || finished.NewSize != started.NewSize ||
+		!finished.Start.Equal(started.Start) {
+		t.Errorf("unexpected finish event %+v", finished)
+	}
+
+	events = events[:0]
+	m.SetMinFillRate(10)
+	for i := 1; i < 100; i++ {
+		m.Del(i)
+	}
+	if len(events) == 0 || len(events)%2 != 0 || events[0].NewSize >= events[0].OldSize {
+		t.Errorf("shrinking should be reported, got %+v", events)
+	}
+}
+
+func TestString(t *testing.T) {
+	m := New[int, string]()
+	if got := m.String(); got != "map[]" {
+		t.Errorf("unexpected empty map %q", got)
+	}
+	m.Set(1, "a")
+	if got := fmt.Sprint(m); got != "map[1:a]" {
+		t.Errorf("unexpected map %q", got)
+	}
+	if got := fmt.Sprintf("%#v", m); got != `&haxmap.Map[int, string]{1: "a"}` {
+		t.Errorf("unexpected Go syntax %q", got)
+	}
+	for i := 2; i <= 20; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	if got := m.String(); !strings.HasSuffix(got, " ...+4]") || strings.Count(got, ":") != formatLimit {
+		t.Errorf("output should be bounded, got %q", got)
+	}
+	if got := m.GoString(); !strings.HasSuffix(got, ", /* 4 more */}") {
+		t.Errorf("Go syntax should be bounded, got %q", got)
+	}
+
+	var b strings.Builder
+	if err := m.Dump(&b, 5); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"); len(lines) != 6 || lines[5] != "... 15 more" {
+		t.Errorf("unexpected dump %q", b.String())
+	}
+	b.Reset()
+	if err := m.Dump(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(b.String(), "\n") != 20 || !strings.Contains(b.String(), "7: 7\n") {
+		t.Errorf("full dump should list every pair, got %q", b.String())
+	}
+
+	// written through a buffer which reports write errors
+	for i := 20; i < 10000; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+	pr, pw := io.Pipe()
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err := m.Dump(pw, 0); err != io.ErrClosedPipe {
+		t.Errorf("write error should be returned, got %v", err)
+	}
+}
+
+func TestName(t *testing.T) {
+	var events []ResizeEvent
+	m := NewWithOptions[int, int](WithName("sessions"), WithInitialSize(8), WithOnResize(func(event ResizeEvent) {
+		events = append(events, event)
+	}))
+	if m.Name() != "sessions" {
+		t.Errorf("unexpected name %q", m.Name())
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	if len(events) == 0 || events[0].Name != "sessions" {
+		t.Errorf("resize events should carry the name of the map, got %+v", events)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pairs := m.Stream(ctx)
+	<-pairs // the goroutine is now running and blocked on the next pair
+	var b bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"haxmap":"sessions"`) || !strings.Contains(b.String(), `"haxmap_task":"stream"`) {
+		t.Errorf("the stream goroutine should be labeled, got\n%s", b.String())
+	}
+}
+
+func TestFlatMap(t *testing.T) {
+	const n = 10000
+	m := NewFlat[int, int]()
+	for i := 0; i < n; i++ {
+		m.Set(i, i)
+	}
+	m.Set(0, -1)
+	if m.Len() != n {
+		t.Fatalf("map should have %d items but has %d", n, m.Len())
+	}
+	for i := 1; i < n; i++ {
+		if value, ok := m.Get(i); !ok || value != i {
+			t.Fatalf("unexpected value %d, %v for key %d", value, ok, i)
+		}
+	}
+	if value, _ := m.Get(0); value != -1 {
+		t.Errorf("updated value should be -1, got %d", value)
+	}
+	for i := 0; i < n; i += 2 {
+		m.Del(i)
+	}
+	for i := 0; i < n; i++ {
+		if _, ok := m.Get(i); ok != (i%2 == 1) {
+			t.Fatalf("key %d should be present only if odd", i)
+		}
+	}
+	if actual, loaded := m.GetOrSet(1, 0); !loaded || actual != 1 {
+		t.Errorf("existing value should be loaded, got %d, %v", actual, loaded)
+	}
+	if actual, loaded := m.GetOrSet(2, 2); loaded || actual != 2 {
+		t.Errorf("absent value should be stored, got %d, %v", actual, loaded)
+	}
+	sum, visited := 0, 0
+	m.ForEach(func(key, value int) bool {
+		if key != value {
+			t.Errorf("unexpected pair %d: %d", key, value)
+		}
+		sum, visited = sum+value, visited+1
+		return true
+	})
+	if visited != n/2+1 || sum != n*n/4+2 {
+		t.Errorf("unexpected traversal of %d pairs summing to %d", visited, sum)
+	}
+	visited = 0
+	m.ForEach(func(int, int) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("traversal should stop, visited %d pairs", visited)
+	}
+	m.Clear()
+	if _, ok := m.Get(1); ok || m.Len() != 0 {
+		t.Error("map should be empty")
+	}
+
+	// a hasher with few distinct hashes fills the groups of a probe sequence with deleted slots
+	m = NewFlat[int, int]()
+	m.hasher = func(key int) uintptr { return uintptr(key%3 + 1) }
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 100; i++ {
+			m.Set(i, round)
+		}
+		for i := 0; i < 100; i++ {
+			if value, ok := m.Get(i); !ok || value != round {
+				t.Fatalf("unexpected value %d, %v for key %d in round %d", value, ok, i, round)
+			}
+		}
+		m.Del(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+		if m.Len() != 90 {
+			t.Fatalf("map should have 90 items but has %d", m.Len())
+		}
+		for i := 0; i < 10; i++ {
+			if _, ok := m.Get(i); ok {
+				t.Fatalf("deleted key %d should be absent", i)
+			}
+		}
+	}
+}
+
+func TestFlatMapConcurrent(t *testing.T) {
+	m := NewFlat[string, int](10)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(worker*1000 + i)
+				m.Set(key, i)
+				if value, ok := m.Get(key); !ok || value != i {
+					t.Errorf("unexpected value %d, %v for key %s", value, ok, key)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if m.Len() != 4000 {
+		t.Errorf("map should have 4000 items but has %d", m.Len())
+	}
+}
+
+func TestShardedMap(t *testing.T) {
+	m := NewSharded[int, int](3, WithInitialSize(16))
+	if m.Shards() != 4 {
+		t.Fatalf("shards should be rounded up to 4, got %d", m.Shards())
+	}
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker * 1000; i < (worker+1)*1000; i++ {
+				m.Set(i, i)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if m.Len() != 4000 {
+		t.Fatalf("map should have 4000 items but has %d", m.Len())
+	}
+	for idx := 0; idx < m.Shards(); idx++ {
+		shard := m.Shard(idx)
+		if shard.Len() == 0 || shard.Len() == 4000 {
+			t.Errorf("keys should be spread over the shards, shard %d has %d items", idx, shard.Len())
+		}
+		// without rotating the hashes the keys of a shard would only address one quarter of its index
+		dist := shard.DebugDistribution()
+		for quarter := uintptr(0); quarter < 4; quarter++ {
+			entries := uintptr(0)
+			for _, n := range dist.slots[quarter*dist.Slots/4 : (quarter+1)*dist.Slots/4] {
+				entries += n
+			}
+			if entries == 0 {
+				t.Errorf("keys should be spread over the index of shard %d, quarter %d is empty", idx, quarter)
+			}
+		}
+	}
+	for i := 0; i < 4000; i++ {
+		if value, ok := m.Get(i); !ok || value != i {
+			t.Fatalf("unexpected value %d, %v for key %d", value, ok, i)
+		}
+	}
+
+	m.Del(0, 1, 2, 3, 4, 5, 6, 7)
+	if value, ok := m.GetAndDel(8); !ok || value != 8 {
+		t.Errorf("unexpected value %d, %v", value, ok)
+	}
+	if m.Len() != 3991 {
+		t.Errorf("map should have 3991 items but has %d", m.Len())
+	}
+	if actual, loaded := m.GetOrSet(0, 10); loaded || actual != 10 {
+		t.Errorf("absent value should be stored, got %d, %v", actual, loaded)
+	}
+	if m.SetIfAbsent(0, 0) {
+		t.Error("present key should not be set")
+	}
+	if actual, ok := m.Compute(0, func(old int, _ bool) (int, bool) { return old + 1, false }); !ok || actual != 11 {
+		t.Errorf("unexpected computed value %d, %v", actual, ok)
+	}
+
+	visited := 0
+	m.ForEach(func(int, int) bool {
+		visited++
+		return visited < 100
+	})
+	if visited != 100 {
+		t.Errorf("traversal should stop after 100 pairs, visited %d", visited)
+	}
+	var total atomicUintptr
+	m.ForEachParallel(func(int, int) {
+		total.Add(1)
+	})
+	if total.Load() != m.Len() {
+		t.Errorf("parallel traversal should visit %d pairs, visited %d", m.Len(), total.Load())
+	}
+	m.Clear()
+	if m.Len() != 0 {
+		t.Errorf("map should be empty but has %d items", m.Len())
+	}
+}
+
+func TestDeletedElementsUnlinked(t *testing.T) {
+	m := New[int, *[1024]byte]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, new([1024]byte))
+	}
+	for i := 0; i < 1000; i += 2 {
+		m.Del(i)
+	}
+	m.Del(1, 3, 5, 7, 9)
+	if _, ok := m.GetAndDel(11); !ok {
+		t.Error("key 11 should be present")
+	}
+	if stats := m.MemStats(); stats.DeletedElements != 0 || stats.Elements != 494 {
+		t.Errorf("deleted elements should be unlinked right away, got %d live and %d deleted", stats.Elements, stats.DeletedElements)
+	}
+	for i := 13; i < 1000; i += 2 {
+		if _, ok := m.Get(i); !ok {
+			t.Fatalf("key %d should be present", i)
+		}
+	}
+}
+
+func TestInsertAfterDeletedElement(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	m := New[int, int]()
+	m.SetHasher(func(key int) uintptr { return uintptr(key+1) << (strconv.IntSize - 11) }) // keys keep their order in the list
+	for i := 0; i < 2000; i += 2 {
+		m.Set(i, i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 2000; i += 2 {
+				m.Del(i)
+				m.Set(i, i)
+			}
+		}
+	}()
+	for round := 0; round < 20; round++ {
+		for i := 1; i < 2000; i += 2 {
+			m.Set(i, round)
+		}
+	}
+	<-done
+	for i := 1; i < 2000; i += 2 {
+		if val, ok := m.Get(i); !ok || val != 19 {
+			t.Fatalf("key %d inserted after a deleted element is lost, got %d %v", i, val, ok)
+		}
+	}
+	if m.Len() != 2000 {
+		t.Errorf("map should contain 2000 entries but has %d items.", m.Len())
+	}
+}
+
+func TestUnlinkKeepsInsertionAfterDeletedElement(t *testing.T) {
+	var (
+		head    = newListHead[int, int]()
+		a, _    = newElement[int, int](10, 1, 1, nil, false)
+		b, _    = newElement[int, int](30, 3, 3, nil, false)
+		c, _    = newElement[int, int](20, 2, 2, nil, false)
+		d, _    = newElement[int, int](25, 4, 4, nil, false)
+		visible = func() (keys []int) {
+			for item := head.next(); item != nil; item = item.next() {
+				keys = append(keys, item.key)
+			}
+			return
+		}
+	)
+	head.nextPtr.Store(a)
+	a.nextPtr.Store(b)
+	// a is claimed for deletion but not yet marked when c gets inserted after it
+	atomic.StoreUint32(&a.deleted, deleted)
+	if !a.addBefore(c, b) {
+		t.Fatal("insertion after an element not marked yet should succeed")
+	}
+	a.mark()
+	if a.addBefore(d, c) {
+		t.Error("insertion after a marked element should fail")
+	}
+	if keys := visible(); len(keys) != 2 || keys[0] != 2 || keys[1] != 3 {
+		t.Errorf("unlinking the deleted element should keep the element inserted after it, got %v", keys)
+	}
+	if head.nextPtr.Load() != c {
+		t.Error("deleted element should be unlinked along with its marker")
+	}
+}
+
+func TestInlineValues(t *testing.T) {
+	m := New[int, int](1 << 12)
+	if !m.inline {
+		t.Fatal("int values should be stored inline")
+	}
+	key := 0
+	if allocs := testing.AllocsPerRun(100, func() { m.Set(key, key); key++ }); allocs != 1 && !raceEnabled {
+		t.Errorf("inserting an int value should allocate only its element, got %v allocations", allocs)
+	}
+	m.Set(1, 100)
+	m.SetMany(Pair[int, int]{Key: 2, Value: 200}, Pair[int, int]{Key: 1000, Value: 1000})
+	if actual, _ := m.Compute(1, func(old int, _ bool) (int, bool) { return old + 1, false }); actual != 101 {
+		t.Errorf("unexpected computed value %d", actual)
+	}
+	for key, expected := range map[int]int{0: 0, 1: 101, 2: 200, 3: 3, 1000: 1000} {
+		if value, ok := m.Get(key); !ok || value != expected {
+			t.Errorf("unexpected value %d, %v for key %d", value, ok, key)
+		}
+	}
+
+	for _, typ := range []struct {
+		value  any
+		inline bool
+	}{
+		{0.5, true},
+		{[4]uint16{}, true},
+		{struct {
+			a int
+			b [2]bool
+		}{}, true},
+		{"", false},
+		{[]int(nil), false},
+		{new(int), false},
+		{struct{ a any }{}, false},
+		{[1]map[int]int{}, false},
+	} {
+		if pointerFree(reflect.TypeOf(typ.value)) != typ.inline {
+			t.Errorf("values of type %T should be stored inline: %v", typ.value, typ.inline)
+		}
+	}
+}
+
+func TestParallelIndexRebuild(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	m := New[int, int]()
+	for i := 0; i < parallelIndexMin+1000; i++ {
+		m.Set(i, i)
+	}
+	m.Del(10, 20, 30)
+	m.Grow(1 << 20)
+	data := m.metadata.Load()
+	if len(data.index) != 1<<20 {
+		t.Fatalf("index should have grown to %d slots, got %d", 1<<20, len(data.index))
+	}
+	seen := make(map[uintptr]bool)
+	for item := m.listHead.next(); item != nil; item = item.next() {
+		slot := item.keyHash >> data.keyshifts
+		if !seen[slot] && data.index[slot] != item {
+			t.Fatalf("slot %d should point to the first element of its range", slot)
+		}
+		seen[slot] = true
+	}
+	for slot, item := range data.index {
+		if item != nil && !seen[uintptr(slot)] {
+			t.Fatalf("slot %d should be empty", slot)
+		}
+	}
+	for i := 0; i < parallelIndexMin+1000; i++ {
+		if _, ok := m.Get(i); ok != (i != 10 && i != 20 && i != 30) {
+			t.Fatalf("unexpected presence of key %d", i)
+		}
+	}
+}
+
+func TestReadMostly(t *testing.T) {
+	r := NewReadMostly[int, string](0)
+	r.Set(1, "a")
+	r.Set(2, "b")
+	if _, ok := r.Get(1); ok || r.Len() != 0 {
+		t.Error("writes should not be visible before a refresh")
+	}
+	r.Refresh()
+	if value, ok := r.Get(1); !ok || value != "a" || r.Len() != 2 {
+		t.Errorf("unexpected value %q, %v after a refresh", value, ok)
+	}
+	snapshot := r.snapshot.Load()
+	r.Refresh()
+	if r.snapshot.Load() != snapshot {
+		t.Error("the snapshot should be kept if the live map did not change")
+	}
+
+	r.Del(1)
+	r.Set(2, "c")
+	r.Live().Set(3, "d")
+	if value, ok := r.Get(1); !ok || value != "a" {
+		t.Error("deleted keys should remain visible until the next refresh")
+	}
+	r.Refresh()
+	pairs := make(map[int]string)
+	r.ForEach(func(key int, value string) bool {
+		pairs[key] = value
+		return true
+	})
+	if len(pairs) != 2 || pairs[2] != "c" || pairs[3] != "d" {
+		t.Errorf("unexpected snapshot %v", pairs)
+	}
+	if delta := r.Live().SnapshotSince(r.seq); delta.Full || len(delta.Deleted) != 0 || len(delta.Set) != 0 {
+		t.Errorf("the changes should be folded into the snapshot, got %+v", delta)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { r.Get(2) }); allocs != 0 {
+		t.Errorf("lookups should not allocate, got %v allocations", allocs)
+	}
+
+	r = NewReadMostly[int, string](time.Millisecond)
+	defer r.Close()
+	r.Set(1, "a")
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := r.Get(1); !ok; _, ok = r.Get(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("the snapshot should be refreshed in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetPtr(t *testing.T) {
+	type large struct {
+		data [256]int
+	}
+	m := New[int, large]()
+	value := &large{}
+	value.data[0] = 1
+	m.SetPtr(1, value)
+	if ptr, ok := m.GetPtr(1); !ok || ptr != value {
+		t.Error("the stored pointer should be returned as is")
+	}
+	if got, ok := m.Get(1); !ok || got.data[0] != 1 {
+		t.Error("the value stored by pointer should be retrieved by Get")
+	}
+
+	update := &large{}
+	update.data[0] = 2
+	if allocs := testing.AllocsPerRun(100, func() { m.SetPtr(1, update) }); allocs != 0 {
+		t.Errorf("updating a value by pointer should not allocate, got %v allocations", allocs)
+	}
+	if ptr, ok := m.GetPtr(1); !ok || ptr != update || m.Len() != 1 {
+		t.Error("the value should be updated by SetPtr")
+	}
+	if _, ok := m.GetPtr(2); ok {
+		t.Error("absent keys should not be found")
+	}
+
+	inline := New[int, int]()
+	number := 3
+	inline.SetPtr(1, &number)
+	if ptr, ok := inline.GetPtr(1); !ok || ptr != &number {
+		t.Error("values given by pointer should not be stored inline")
+	}
+	inline.SetPtr(2, nil)
+	if value, ok := inline.Get(2); !ok || value != 0 {
+		t.Error("a nil pointer should store the zero value")
+	}
+}
+
+func TestSetVisibleDuringResize(t *testing.T) {
+	m := New[int, int]()
+	m.SetHasher(func(key int) uintptr { return uintptr(key) }) // every key falls into the first slot of the index
+	m.Set(10, 10)
+	// an index swapped in by a resize lacks the entries inserted while it was filled, which is emulated by restoring
+	// the slot the insertion updated
+	data := m.metadata.Load()
+	filled := data.index[0]
+	m.Set(5, 5)
+	data.index[0] = filled
+
+	if value, ok := m.Get(5); !ok || value != 5 {
+		t.Error("a key missing from the index should be found by Get")
+	}
+	if ptr, ok := m.GetPtr(5); !ok || *ptr != 5 {
+		t.Error("a key missing from the index should be found by GetPtr")
+	}
+	if values, found := m.GetMany(5, 10); !found[0] || !found[1] || values[0] != 5 {
+		t.Error("a key missing from the index should be found by GetMany")
+	}
+	if value, err := m.GetE(5); err != nil || value != 5 {
+		t.Error("a key missing from the index should be found by GetE")
+	}
+	if actual, loaded := m.GetOrSet(5, 0); !loaded || actual != 5 {
+		t.Error("a key missing from the index should be found by GetOrSet")
+	}
+
+	data.index[0] = nil
+	if value, ok := m.Get(10); !ok || value != 10 || m.Len() != 2 {
+		t.Error("keys should be found without any element in the index")
+	}
+
+	// the first slot of the index still holds a deleted element until its deletion replaces it
+	m.Set(1, 1)
+	deleted := m.lookup(m.hasher(1), 1)
+	m.Del(1)
+	m.Set(1, 2)
+	data.index[0] = deleted
+	if value, ok := m.Get(1); !ok || value != 2 {
+		t.Error("a deleted element left in the index should not hide the key inserted again")
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	m := New[int, int]()
+	m.SetMaxEntries(10, nil)
+	for i := 0; i < 10; i++ {
+		if err := m.TrySet(i, i); err != nil {
+			t.Errorf("insertion below the limit should succeed, got %v", err)
+		}
+	}
+	if err := m.TrySet(10, 10); err != ErrMapFull {
+		t.Errorf("expected ErrMapFull, got %v", err)
+	}
+	m.Set(11, 11)
+	if _, loaded := m.GetOrSet(12, 12); loaded {
+		t.Error("rejected insertion should not report the key as loaded")
+	}
+	if _, loaded, err := m.TryGetOrSet(12, 12); loaded || err != ErrMapFull {
+		t.Errorf("rejected insertion should be reported, got %v", err)
+	}
+	if m.SetIfAbsent(12, 12) {
+		t.Error("rejected insertion should not be reported as stored")
+	}
+	if _, ok, err := m.TryCompute(12, func(int, bool) (int, bool) { return 12, false }); ok || err != ErrMapFull {
+		t.Errorf("rejected insertion should be reported, got %v", err)
+	}
+	if _, ok, err := m.TryCompute(0, func(old int, _ bool) (int, bool) { return old, false }); !ok || err != nil {
+		t.Errorf("updating an existing key in a full map should succeed, got %v", err)
+	}
+	if _, _, err := m.TryGetOrSet(0, 0); err != nil {
+		t.Errorf("present key should be loaded from a full map, got %v", err)
+	}
+	if m.Len() != 10 {
+		t.Errorf("map should be capped at 10 elements but has %d items.", m.Len())
+	}
+	if err := m.TrySet(0, 100); err != nil {
+		t.Errorf("updating an existing key in a full map should succeed, got %v", err)
+	}
+	if val, _ := m.Get(0); val != 100 {
+		t.Error("existing key should be updated in a full map")
+	}
+
+	m.SetMaxEntries(10, EvictFirst[int, int])
+	for i := 10; i < 20; i++ {
+		m.Set(i, i)
+		if _, ok := m.Get(i); !ok {
+			t.Errorf("key %d should have been inserted by evicting another entry", i)
+		}
+	}
+	if m.Len() != 10 {
+		t.Errorf("map should be capped at 10 elements but has %d items.", m.Len())
+	}
+}
+
+func TestMaxEntriesConcurrent(t *testing.T) {
+	var (
+		m        = New[int, int]()
+		inserted int64
+		wg       sync.WaitGroup
+	)
+	m.SetMaxEntries(100, nil)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if m.TrySet(worker*100+i, i) == nil {
+					atomic.AddInt64(&inserted, 1)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if inserted != 100 || m.Len() != 100 {
+		t.Errorf("exactly 100 insertions should succeed, got %d with %d items in the map", inserted, m.Len())
+	}
+}
+
+func TestMaxFillRate(t *testing.T) {
+	sparse, dense := New[int, int](), New[int, int]()
+	sparse.SetMaxFillRate(25)
+	dense.SetMaxFillRate(75)
+	for i := 0; i < 1000; i++ {
+		sparse.Set(i, i)
+		dense.Set(i, i)
+	}
+	if fr := sparse.Fillrate(); fr > 25 {
+		t.Errorf("fill rate should not exceed 25, got %d", fr)
+	}
+	if fr := dense.Fillrate(); fr > 75 {
+		t.Errorf("fill rate should not exceed 75, got %d", fr)
+	}
+	if len(sparse.metadata.Load().index) <= len(dense.metadata.Load().index) {
+		t.Error("a lower fill rate should result in a larger index")
+	}
+	if NewLike(sparse).maxFillRate != 25 {
+		t.Error("fill rate should be carried over by NewLike")
+	}
+	sparse.SetMaxFillRate(0)
+	if sparse.maxFillRate != maxFillRate {
+		t.Error("a rate of 0 should restore the default fill rate")
+	}
+}
+
+func TestGrowthFactor(t *testing.T) {
+	m := New[int, int]()
+	m.SetGrowthFactor(4)
+	for i := 0; len(m.metadata.Load().index) == defaultSize; i++ {
+		m.Set(i, i)
+	}
+	if n := len(m.metadata.Load().index); n != defaultSize*4 {
+		t.Errorf("map should grow by a factor of 4, got index size %d", n)
+	}
+	m.SetGrowthFactor(3)
+	m.Grow(0)
+	if n := len(m.metadata.Load().index); n != defaultSize*4*4 {
+		t.Errorf("growth factor should be rounded up to 4, got index size %d", n)
+	}
+	m.SetGrowthFactor(1)
+	m.Grow(0)
+	if n := len(m.metadata.Load().index); n != defaultSize*4*4*2 {
+		t.Errorf("growth factor below 2 should be treated as 2, got index size %d", n)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	m := NewWithOptions[string, int](
+		WithInitialSize(64),
+		WithHasher(func(key string) uintptr {
+			return uintptr(len(key))
+		}),
+		WithMaxFillRate(25),
+		WithMinFillRate(5),
+		WithGrowthFactor(4),
+		WithMaxEntries(2),
+		WithEvictor(EvictFirst[string, int]),
+		WithLoader(func(key string) (int, error) {
+			return len(key), nil
+		}),
+	)
+	if n := len(m.metadata.Load().index); n != 64 {
+		t.Errorf("initial size is not as expected, got %d", n)
+	}
+	if m.hasher("four") != 4 {
+		t.Error("hasher option is not applied")
+	}
+	if m.maxFillRate != 25 || m.minFillRate != 5 || m.growthShift != 2 {
+		t.Error("fill rate and growth options are not applied")
+	}
+	if val, ok := m.Get("three"); !ok || val != 5 {
+		t.Error("loader option is not applied")
+	}
+	m.Set("a", 1)
+	m.Set("b", 2)
+	if m.Len() != 2 {
+		t.Errorf("max entries option is not applied, map has %d items", m.Len())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("mismatching typed option should panic")
+		}
+	}()
+	NewWithOptions[int, int](WithHasher(func(key string) uintptr { return 0 }))
+}
+
+func TestSeed(t *testing.T) {
+	a, b := New[string, int](), New[string, int]()
+	if a.Seed() == b.Seed() || a.hasher("key") == b.hasher("key") {
+		t.Error("maps should be seeded randomly")
+	}
+
+	a.SetSeed(42)
+	b = NewWithOptions[string, int](WithSeed(42))
+	for _, key := range []string{"", "key", "a key longer than thirty two bytes to hash"} {
+		if a.hasher(key) != b.hasher(key) {
+			t.Errorf("maps with the same seed should hash %q identically", key)
+		}
+	}
+	if c := NewLike(a); c.Seed() != 42 || c.hasher("key") != a.hasher("key") {
+		t.Error("seed should be carried over by NewLike")
+	}
+
+	f := New[float64, int]()
+	f.SetSeed(7)
+	f.Set(1.3, 1)
+	f.Set(1.7, 2)
+	if val, ok := f.Get(1.3); !ok || val != 1 {
+		t.Error("float key not found with a fixed seed")
+	}
+	if f.hasher(1.3) == f.hasher(1.7) {
+		t.Error("float keys should keep all bits when hashed")
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	for _, algorithm := range []HashAlgorithm{XXHash, WyHash, MapHash} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			m := NewWithOptions[string, int](WithHashAlgorithm(algorithm))
+			n := NewWithOptions[float64, int](WithHashAlgorithm(algorithm))
+			// cover every input length branch of the hashers
+			for i := 0; i < 200; i++ {
+				m.Set(strings.Repeat("k", i), i)
+				n.Set(float64(i)+0.5, i)
+			}
+			for i := 0; i < 200; i++ {
+				if val, ok := m.Get(strings.Repeat("k", i)); !ok || val != i {
+					t.Fatalf("string key of length %d not found", i)
+				}
+				if val, ok := n.Get(float64(i) + 0.5); !ok || val != i {
+					t.Fatalf("float key %v not found", float64(i)+0.5)
+				}
+			}
+			if m.hasher("k") == m.hasher("kk") || n.hasher(1.25) == n.hasher(1.75) {
+				t.Error("distinct keys should hash differently")
+			}
+			if clone := m.Clone(); clone.algorithm != algorithm || clone.hasher("key") != m.hasher("key") {
+				t.Error("algorithm should be carried over by clones")
+			}
+		})
+	}
+
+	// reference test vectors of wyhash final version 4
+	if h := wyHash([]byte("message digest"), 3); uint64(h) != 0x8619124089a3a16b {
+		t.Errorf("wyhash of a short input does not match the reference, got %x", h)
+	}
+	if h := wyHash([]byte(strings.Repeat("1234567890", 8)), 6); uint64(h) != 0xc39cab13b115aad3 {
+		t.Errorf("wyhash of a long input does not match the reference, got %x", h)
+	}
+
+	a := NewWithOptions[string, int](WithHashAlgorithm(WyHash), WithSeed(1))
+	b := NewWithOptions[string, int](WithSeed(1))
+	b.SetHashAlgorithm(WyHash)
+	if a.hasher("key") != b.hasher("key") {
+		t.Error("wyhash with the same seed should be reproducible")
+	}
+}
+
+func TestSetHasherRehash(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	m.SetHasher(func(key int) uintptr {
+		return uintptr(key%7 + 1)
+	})
+	if m.Len() != 1000 {
+		t.Fatalf("rehashing changed the number of entries to %d", m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if val, ok := m.Get(i); !ok || val != i {
+			t.Fatalf("key %d not found after changing the hasher", i)
+		}
+	}
+
+	m.SetSeed(1)
+	m.SetHashAlgorithm(WyHash)
+	for i := 0; i < 1000; i++ {
+		if val, ok := m.Get(i); !ok || val != i {
+			t.Fatalf("key %d not found after changing the hash algorithm", i)
+		}
+	}
+	m.Del(500)
+	if _, ok := m.Get(500); ok || m.Len() != 999 {
+		t.Error("deletion failed after rehashing")
+	}
+
+	// entries are moved as is without being written again
+	notified := 0
+	m = NewWithOptions[int, int](WithChangeTracking(),
+		WithOnInsert(func(int, int) { notified++ }), WithOnUpdate(func(int, int, int) { notified++ }))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.SetWithTTL(100, 100, time.Hour)
+	seq, notified := m.SnapshotSince(0).Seq, 0
+	m.SetSeed(2)
+	if notified != 0 {
+		t.Errorf("rehashing should not notify hooks, got %d notifications", notified)
+	}
+	if delta := m.SnapshotSince(seq); len(delta.Set) != 0 || len(delta.Deleted) != 0 {
+		t.Errorf("rehashing should not be tracked as changes, got %+v", delta)
+	}
+	if elem := m.lookup(m.hasher(100), 100); elem == nil || elem.expiry.Load() == 0 || m.Len() != 101 {
+		t.Error("rehashing should keep the entries and their expiry")
+	}
+}
+
+func TestZeroValueMap(t *testing.T) {
+	var m Map[string, int]
+	if _, ok := m.Get("missing"); ok || m.Len() != 0 {
+		t.Error("zero value map should be empty")
+	}
+	m.Set("one", 1)
+	if val, ok := m.Get("one"); !ok || val != 1 {
+		t.Error("zero value map should be usable")
+	}
+
+	// embedded in a struct and set up concurrently upon first use
+	var s struct {
+		cache Map[int, int]
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.cache.Set(w*100+i, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if s.cache.Len() != 800 {
+		t.Errorf("concurrent first use lost entries, got %d", s.cache.Len())
+	}
+
+	var j Map[string, int]
+	if err := json.Unmarshal([]byte(`{"a":1,"b":2}`), &j); err != nil || j.Len() != 2 {
+		t.Error("zero value map should be decodable from JSON")
+	}
+
+	var c Map[int, int]
+	c.SetMaxFillRate(30)
+	if c.maxFillRate != 30 || c.growthShift != 1 {
+		t.Error("settings applied to a zero value map should be kept")
+	}
+}
+
+func TestPointerAndBoolKeys(t *testing.T) {
+	x, y := new(int), new(int)
+	p := New[*int, string]()
+	p.Set(x, "x")
+	p.Set(y, "y")
+	if val, ok := p.Get(x); !ok || val != "x" || p.Len() != 2 {
+		t.Error("pointer keys should be hashed by address")
+	}
+
+	b := New[bool, int]()
+	b.Set(true, 1)
+	b.Set(false, 0)
+	if val, ok := b.Get(true); !ok || val != 1 || b.Len() != 2 {
+		t.Error("bool keys not supported")
+	}
+}
+
+func TestBytesKeys(t *testing.T) {
+	m := New[string, int]()
+	key := []byte("payload")
+	SetBytes(m, key, 1)
+	key[0] = 'P' // the stored key must not share memory with the slice
+	if val, ok := m.Get("payload"); !ok || val != 1 {
+		t.Fatal("key given as bytes not found")
+	}
+	if _, ok := m.Get("Payload"); ok {
+		t.Fatal("stored key should be a copy of the bytes")
+	}
+
+	key[0] = 'p'
+	SetBytes(m, key, 2)
+	if val, ok := GetBytes(m, key); !ok || val != 2 || m.Len() != 1 {
+		t.Error("update via bytes failed")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { GetBytes(m, key) }); allocs != 0 {
+		t.Errorf("GetBytes should not allocate, got %v allocations", allocs)
+	}
+
+	DelBytes(m, key)
+	if _, ok := GetBytes(m, key); ok || m.Len() != 0 {
+		t.Error("deletion via bytes failed")
+	}
+
+	m.SetLoader(func(key string) (int, error) {
+		return len(key), nil
+	})
+	loaded := []byte("loaded")
+	if val, ok := GetBytes(m, loaded); !ok || val != 6 {
+		t.Error("loader not consulted on a miss")
+	}
+	loaded[0] = 'L'
+	if _, ok := m.Get("loaded"); !ok {
+		t.Error("loaded key should be a copy of the bytes")
+	}
+}
+
+type compositeID struct {
+	tenant, id uint32
+}
+
+func (c compositeID) HashKey() uintptr {
+	return uintptr(c.tenant)*1000 + uintptr(c.id) + 1
+}
+
+// userID is a key type serialized as text like "user-42"
+type userID int
+
+func (u userID) MarshalText() ([]byte, error) {
+	return []byte("user-" + strconv.Itoa(int(u))), nil
+}
+
+func (u *userID) UnmarshalText(text []byte) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(string(text), "user-"))
+	*u = userID(id)
+	return err
+}
+
+func TestKeyHasher(t *testing.T) {
+	m := New[compositeID, string]()
+	if m.Hash(compositeID{tenant: 1, id: 2}) != 1003 {
+		t.Fatal("HashKey method should be used as the hasher")
+	}
+	for i := uint32(0); i < 100; i++ {
+		m.Set(compositeID{tenant: i % 4, id: i}, strconv.Itoa(int(i)))
+	}
+	for i := uint32(0); i < 100; i++ {
+		if val, ok := m.Get(compositeID{tenant: i % 4, id: i}); !ok || val != strconv.Itoa(int(i)) {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+}
+
+func TestBinaryKeyMap(t *testing.T) {
+	m := NewBinaryKeyMap[netip.Addr, string]()
+	for i := 0; i < 50; i++ {
+		if err := m.Set(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if val, ok := m.Get(netip.MustParseAddr("10.0.0.7")); !ok || val != "7" || m.Len() != 50 {
+		t.Error("netip.Addr key not found")
+	}
+	m.Del(netip.MustParseAddr("10.0.0.7"))
+	if _, ok := m.Get(netip.MustParseAddr("10.0.0.7")); ok || m.Len() != 49 {
+		t.Error("deletion failed")
+	}
+
+	count := 0
+	m.ForEach(func(key netip.Addr, value string) bool {
+		if !key.Is4() || value == "" {
+			t.Errorf("unexpected pair %v %q", key, value)
+		}
+		count++
+		return true
+	})
+	if count != 49 {
+		t.Errorf("iterated over %d pairs instead of 49", count)
+	}
+
+	times := NewBinaryKeyMap[time.Time, int]()
+	now := time.Now()
+	times.Set(now, 1)
+	if val, ok := times.Get(now.Round(0)); !ok || val != 1 {
+		t.Error("time.Time key should be found by its marshaled instant")
+	}
+}
+
+func (c compositeID) Equal(other compositeID) bool {
+	// ids are unique across tenants
+	return c.id == other.id
+}
+
+func TestKeyEqual(t *testing.T) {
+	m := NewWithOptions[string, int](
+		WithHasher(func(key string) uintptr {
+			return uintptr(len(key)) + 1
+		}),
+		WithKeyEqual(strings.EqualFold),
+	)
+	m.Set("Key", 1)
+	m.Set("KEY", 2)
+	if val, ok := m.Get("key"); !ok || val != 2 || m.Len() != 1 {
+		t.Error("keys equal under the custom equality should be the same entry")
+	}
+	if _, loaded := m.GetOrSet("kEy", 3); !loaded {
+		t.Error("GetOrSet should find the key with the custom equality")
+	}
+	m.Del("kEY")
+	if m.Len() != 0 {
+		t.Error("deletion should use the custom equality")
+	}
+
+	// entries which became equal are merged
+	n := New[string, int]()
+	n.Set("a", 1)
+	n.Set("A", 2)
+	n.SetHasher(func(key string) uintptr {
+		return uintptr(len(key)) + 1
+	})
+	n.SetKeyEqual(strings.EqualFold)
+	if n.Len() != 1 {
+		t.Errorf("equal entries should be merged, got %d entries", n.Len())
+	}
+
+	// Equal method of KeyHasher keys
+	c := New[compositeID, int]()
+	c.Set(compositeID{tenant: 1, id: 5}, 1)
+	if c.keyEqual == nil || !c.equal(compositeID{tenant: 1, id: 5}, compositeID{tenant: 2, id: 5}) {
+		t.Error("Equal method of the key type should be used")
+	}
+}
+
+func TestSetType(t *testing.T) {
+	a, b := NewSet[int](), NewSet[int]()
+	for i := 0; i < 10; i++ {
+		if !a.Add(i) {
+			t.Fatalf("key %d should be added", i)
+		}
+		b.Add(i + 5)
+	}
+	if a.Add(0) || a.Len() != 10 || !a.Contains(9) || a.Contains(10) {
+		t.Error("set membership is not as expected")
+	}
+
+	sorted := func(s *Set[int]) []int {
+		keys := s.Keys()
+		sort.Ints(keys)
+		return keys
+	}
+	if got := sorted(a.Union(b)); len(got) != 15 || got[0] != 0 || got[14] != 14 {
+		t.Errorf("unexpected union %v", got)
+	}
+	if got := fmt.Sprint(sorted(a.Intersect(b))); got != "[5 6 7 8 9]" {
+		t.Errorf("unexpected intersection %s", got)
+	}
+	if got := fmt.Sprint(sorted(a.Difference(b))); got != "[0 1 2 3 4]" {
+		t.Errorf("unexpected difference %s", got)
+	}
+	if a.Len() != 10 || b.Len() != 10 {
+		t.Error("set algebra should not modify the operands")
+	}
+
+	if !a.Remove(3) || a.Remove(3) || a.Contains(3) {
+		t.Error("removal is not as expected")
+	}
+	count := 0
+	a.ForEach(func(int) bool {
+		count++
+		return count < 5
+	})
+	if count != 5 {
+		t.Error("iteration should stop when the lambda returns false")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter[string]()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.Add("hits", 1)
+				c.Add("key"+strconv.Itoa(i%10), 2)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.Load("hits"); got != 8000 {
+		t.Errorf("lost increments, got %d", got)
+	}
+	snapshot := c.Snapshot()
+	if len(snapshot) != 11 || snapshot["key3"] != 1600 {
+		t.Errorf("unexpected snapshot %v", snapshot)
+	}
+	if c.Add("hits", -8000) != 0 || c.Load("missing") != 0 {
+		t.Error("negative delta or absent key not handled")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { c.Add("hits", 1) }); allocs != 0 {
+		t.Errorf("incrementing an existing counter should not allocate, got %v allocations", allocs)
+	}
+	c.Del("hits")
+	if c.Len() != 10 || c.Load("hits") != 0 {
+		t.Error("deletion failed")
+	}
+}
+
+func TestCounterAddDel(t *testing.T) {
+	c := NewCounter[int]()
+	var (
+		wg      sync.WaitGroup
+		stop    = make(chan struct{})
+		done    = make(chan struct{})
+		drained int64
+	)
+	go func() {
+		defer close(done)
+		// deletions return the final value of the counter so no increment may get lost in between
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				atomic.AddInt64(&drained, c.del(1))
+			}
+		}
+	}()
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				c.Add(1, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+	if total := drained + c.del(1); total != 80000 {
+		t.Errorf("increments racing with deletions got lost, counted %d of 80000", total)
+	}
+}
+
+func TestMultiMap(t *testing.T) {
+	m := NewMultiMap[string, int]()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Append("shared", w*100+i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if got := len(m.GetAll("shared")); got != 800 {
+		t.Fatalf("concurrent appends lost values, got %d", got)
+	}
+
+	m.Append("key", 1, 2, 3, 4)
+	values := m.GetAll("key")
+	values[0] = 100 // must not affect the stored values
+	if got := fmt.Sprint(m.GetAll("key")); got != "[1 2 3 4]" {
+		t.Errorf("unexpected values %s", got)
+	}
+	if removed := m.RemoveValue("key", func(v int) bool { return v%2 == 0 }); removed != 2 {
+		t.Errorf("expected 2 removed values, got %d", removed)
+	}
+	if got := fmt.Sprint(m.GetAll("key")); got != "[1 3]" {
+		t.Errorf("unexpected values after removal %s", got)
+	}
+	m.RemoveValue("key", func(int) bool { return true })
+	if m.GetAll("key") != nil || m.Len() != 1 {
+		t.Error("key without values should be deleted")
+	}
+	if m.RemoveValue("missing", func(int) bool { return true }) != 0 || m.Len() != 1 {
+		t.Error("removing from an absent key should be a no-op")
+	}
+}
+
+func TestBiMap(t *testing.T) {
+	b := NewBiMap[string, int]()
+	if err := b.Put("one", 1); err != nil {
+		t.Fatal(err)
+	}
+	b.Put("two", 2)
+	if val, ok := b.GetByKey("one"); !ok || val != 1 {
+		t.Error("lookup by key failed")
+	}
+	if key, ok := b.GetByValue(2); !ok || key != "two" {
+		t.Error("lookup by value failed")
+	}
+
+	if err := b.Put("uno", 1); err != ErrDuplicateValue {
+		t.Errorf("binding a bound value should fail, got %v", err)
+	}
+	b.ForcePut("uno", 1)
+	if _, ok := b.GetByKey("one"); ok {
+		t.Error("force put should unbind the previous key")
+	}
+	if key, _ := b.GetByValue(1); key != "uno" || b.Len() != 2 {
+		t.Error("force put should bind the value to the new key")
+	}
+
+	b.Put("two", 22) // replaces the value of the key
+	if _, ok := b.GetByValue(2); ok {
+		t.Error("replaced value should be unbound")
+	}
+	b.DelByValue(22)
+	b.DelByKey("uno")
+	if b.Len() != 0 {
+		t.Errorf("deletions failed, %d pairs left", b.Len())
+	}
+
+	// concurrent rebinding while reading both directions, the pairs left must be consistent
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				b.ForcePut("key"+strconv.Itoa(i%10), i%20)
+			}
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		if val, ok := b.GetByKey("key" + strconv.Itoa(i%10)); ok {
+			b.GetByValue(val)
+		}
+	}
+	close(done)
+	wg.Wait()
+	b.ForEach(func(key string, value int) bool {
+		if k, ok := b.GetByValue(value); !ok || k != key {
+			t.Errorf("inconsistent pair %s %d", key, value)
+		}
+		return true
+	})
+}
+
+func TestOrderedMap(t *testing.T) {
+	m := NewOrderedMap[string, int]()
+	for _, key := range []string{"c", "a", "d", "b"} {
+		m.Set(key, len(key))
+	}
+	m.Set("a", 10) // keeps its position
+	m.Del("d")
+	m.Set("d", 4) // moves to the end
+	if got := fmt.Sprint(m.Keys()); got != "[c a b d]" {
+		t.Errorf("unexpected order %s", got)
+	}
+	if val, ok := m.Get("a"); !ok || val != 10 || m.Len() != 4 {
+		t.Error("lookup failed")
+	}
+	m.Del("d", "c")
+	m.Set("e", 5)
+	if got := fmt.Sprint(m.Keys()); got != "[a b e]" {
+		t.Errorf("unexpected order after deleting the ends %s", got)
+	}
+
+	// concurrent insertions and deletions keep the order list consistent with the map
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 50)
+				if (i+w)%3 == 0 {
+					m.Del(key)
+				} else {
+					m.Set(key, i)
+				}
+				m.ForEach(func(string, int) bool { return true })
+			}
+		}(w)
+	}
+	wg.Wait()
+	if keys := m.Keys(); uintptr(len(keys)) != m.Len() {
+		t.Errorf("order list holds %d keys while the map holds %d", len(keys), m.Len())
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	var (
+		m   = New[int, int]()
+		now int64
+	)
+	m.clock = func() int64 { return now }
+	for i := 0; i < 10; i++ {
+		m.SetWithTTL(i, i, time.Duration(i+1)*time.Second)
+	}
+	m.Set(10, 10)
+	m.SetWithTTL(11, 11, 0) // never expires
+	now = int64(5 * time.Second)
+
+	if _, ok := m.Get(3); ok {
+		t.Error("expired entry should be absent")
+	}
+	if val, ok := m.Get(7); !ok || val != 7 {
+		t.Error("entry should not have expired yet")
+	}
+	if m.Len() != 11 { // the expired entry 3 was removed by Get
+		t.Errorf("expected 11 entries, got %d", m.Len())
+	}
+	if keys := m.Keys(); len(keys) != 7 {
+		t.Errorf("iteration should skip expired entries, got %v", keys)
+	}
+	if _, loaded := m.GetOrSet(0, 100); loaded {
+		t.Error("expired entry should be replaced by GetOrSet")
+	}
+	if _, loaded := m.Swap(1, 100); loaded {
+		t.Error("expired entry should be replaced by Swap")
+	}
+	m.Set(9, 9) // clears the expiry
+	m.SetWithTTL(8, 8, 10*time.Second)
+
+	now = int64(100 * time.Second)
+	if removed := m.DeleteExpired(); removed != 4 { // entries 5 to 8, the others were removed by Get and Keys
+		t.Errorf("expected 4 removed entries, got %d", removed)
+	}
+	if keys := m.Keys(); len(keys) != 5 || m.Len() != 5 {
+		t.Errorf("expected the entries 0, 1, 9, 10 and 11 to be left, got %v", keys)
+	}
+
+	// the expiry survives cloning and rehashing
+	m.SetWithTTL(20, 20, time.Second)
+	c := m.Clone()
+	m.SetHasher(func(k int) uintptr { return uintptr(k) + 1 })
+	now += int64(time.Second)
+	if _, ok := m.Get(20); ok {
+		t.Error("expiry should survive rehashing")
+	}
+	if _, ok := c.Get(20); ok {
+		t.Error("expiry should survive cloning")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i, time.Millisecond)
+	}
+	m.StartJanitor(time.Millisecond)
+	defer m.StopJanitor()
+	for deadline := time.Now().Add(5 * time.Second); m.Len() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if m.Len() != 0 {
+		t.Errorf("expected the janitor to remove all entries, %d left", m.Len())
+	}
+}
+
+func TestBoundedSweep(t *testing.T) {
+	var (
+		m   = New[int, int]()
+		now int64
+	)
+	m.clock = func() int64 { return now }
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i, time.Second)
+	}
+	now = int64(time.Second)
+	removed := 0
+	for sweeps := 1; sweeps <= 10; sweeps++ {
+		if n := m.sweep(10); n != 10 {
+			t.Fatalf("sweep %d removed %d entries, expected 10", sweeps, n)
+		}
+		removed += 10
+		if m.Len() != uintptr(100-removed) {
+			t.Fatalf("expected %d entries left after sweep %d, got %d", 100-removed, sweeps, m.Len())
+		}
+	}
+	if m.sweep(10) != 0 || m.sweepFrom.Load() != 0 {
+		t.Error("a sweep over an empty list should start over from the head")
+	}
+
+	m = NewWithOptions[int, int](WithJanitor(time.Millisecond, 10))
+	defer m.StopJanitor()
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i, time.Millisecond)
+	}
+	m.Set(100, 100)
+	for deadline := time.Now().Add(5 * time.Second); m.Len() > 1 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if m.Len() != 1 {
+		t.Errorf("expected the janitor to remove all expiring entries, %d left", m.Len())
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		evicted = make(map[Reason][]int)
+		now     int64
+	)
+	m := NewWithOptions[int, int](WithMaxEntries(3), WithEvictor(EvictFirst[int, int]),
+		WithOnEvict(func(key, value int, reason Reason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if key != value {
+				t.Errorf("callback got value %d for key %d", value, key)
+			}
+			evicted[reason] = append(evicted[reason], key)
+		}))
+	m.clock = func() int64 { return now }
+
+	for i := 1; i <= 4; i++ {
+		m.Set(i, i)
+	}
+	if len(evicted[ReasonEvicted]) != 1 || m.Len() != 3 {
+		t.Errorf("expected one entry evicted for capacity, got %v", evicted)
+	}
+	m.Del(m.Keys()[0])
+	m.SetWithTTL(5, 5, time.Second)
+	now = int64(time.Second)
+	if _, ok := m.Get(5); ok || len(evicted[ReasonExpired]) != 1 || evicted[ReasonExpired][0] != 5 {
+		t.Errorf("expected the expired entry to be reported, got %v", evicted)
+	}
+	m.Clear()
+	if len(evicted[ReasonCleared]) != 2 || m.Len() != 0 {
+		t.Errorf("expected both remaining entries to be reported as cleared, got %v", evicted)
+	}
+	if fmt.Sprint(ReasonExpired, ReasonEvicted, ReasonCleared) != "expired evicted cleared" {
+		t.Error("unexpected reason names")
+	}
+
+	// concurrent readers and refreshers of an expiring entry report it at most once per expiry
+	var reported int64
+	e := New[int, int]()
+	e.clock = m.clock
+	e.OnEvict(func(int, int, Reason) { atomic.AddInt64(&reported, 1) })
+	now = 0
+	e.SetWithTTL(1, 1, time.Nanosecond)
+	now = 1
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Get(1)
+			e.DeleteExpired()
+		}()
+	}
+	wg.Wait()
+	if reported != 1 {
+		t.Errorf("expected the expired entry to be reported once, got %d", reported)
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	var now int64
+	m := NewWithOptions[string, int](WithSlidingExpiration())
+	m.clock = func() int64 { return now }
+	m.SetWithTTL("session", 1, 10*time.Second)
+	m.SetWithTTL("idle", 2, 10*time.Second)
+	for step := 0; step < 5; step++ {
+		now += int64(8 * time.Second)
+		if _, ok := m.Get("session"); !ok {
+			t.Fatalf("accessed entry should not expire, step %d", step)
+		}
+	}
+	if _, ok := m.Get("idle"); ok {
+		t.Error("idle entry should expire")
+	}
+
+	// accesses closer than 1/64 of the ttl do not store a new expiry
+	elem := m.lookup(m.hasher("session"), "session")
+	expiry := elem.expiry.Load()
+	now += int64(100 * time.Millisecond)
+	m.Get("session")
+	if elem.expiry.Load() != expiry {
+		t.Error("expiry should not be stored upon a close access")
+	}
+
+	// without sliding expiration only GetRefresh extends the expiry
+	m.SetSlidingExpiration(false)
+	m.SetWithTTL("session", 1, 10*time.Second)
+	now += int64(8 * time.Second)
+	m.Get("session")
+	if _, ok := m.GetRefresh("session"); !ok {
+		t.Fatal("entry should not have expired yet")
+	}
+	now += int64(8 * time.Second)
+	if _, ok := m.Get("session"); !ok {
+		t.Error("GetRefresh should extend the expiry")
+	}
+	now += int64(8 * time.Second)
+	if _, ok := m.Get("session"); ok {
+		t.Error("Get should not extend the expiry")
+	}
+	m.Set("forever", 3)
+	if _, ok := m.GetRefresh("forever"); !ok || m.lookup(m.hasher("forever"), "forever").expiry.Load() != 0 {
+		t.Error("entries without ttl should not be given an expiry")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	m := New[int, []string]()
+	m.Set(10, []string{"a"})
+	m.Set(-2, nil)
+	m.Set(3, []string{"b", "c"})
+	data, err := json.Marshal(m)
+	if err != nil || string(data) != `{"-2":null,"10":["a"],"3":["b","c"]}` {
+		t.Fatalf("unexpected encoding %s %v", data, err)
+	}
+	d := New[int, []string]()
+	if err = json.Unmarshal(data, d); err != nil || d.Len() != 3 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := d.Get(3); len(val) != 2 || val[1] != "c" {
+		t.Error("decoded value is not as expected")
+	}
+
+	// keys implementing encoding.TextMarshaler, as a field of a struct
+	type state struct {
+		Peers *Map[netip.Addr, int] `json:"peers"`
+	}
+	s := state{Peers: New[netip.Addr, int]()}
+	s.Peers.Set(netip.MustParseAddr("10.0.0.1"), 1)
+	s.Peers.Set(netip.MustParseAddr("::1"), 2)
+	if data, err = json.Marshal(s); err != nil || string(data) != `{"peers":{"10.0.0.1":1,"::1":2}}` {
+		t.Fatalf("unexpected encoding %s %v", data, err)
+	}
+	var decoded state
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded.Peers.Len() != 2 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, ok := decoded.Peers.Get(netip.MustParseAddr("::1")); !ok || val != 2 {
+		t.Error("decoded value is not as expected")
+	}
+
+	if err = json.Unmarshal([]byte(`null`), d); err != nil || d.Len() != 3 {
+		t.Error("null should leave the map as is")
+	}
+	if err = json.Unmarshal([]byte(`{"x":[]}`), d); err == nil {
+		t.Error("invalid integer key should fail")
+	}
+	if err = json.Unmarshal([]byte(`[1]`), d); err == nil {
+		t.Error("non-object should fail")
+	}
+	f := New[float64, int]()
+	f.Set(1.5, 1)
+	if _, err = json.Marshal(f); err == nil {
+		t.Error("unsupported key type should fail")
+	}
+}
+
+func TestGob(t *testing.T) {
+	m := New[string, int]()
+	for i := 0; i < 10000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.SetWithTTL("gone", -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// as a field of a struct, the map gets allocated by the decoder
+	type state struct {
+		Counts *Map[string, int]
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state{Counts: m}); err != nil {
+		t.Fatal(err)
+	}
+	var decoded state
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	d := decoded.Counts
+	if d.Len() != 10000 {
+		t.Fatalf("decoded map should hold 10000 entries, got %d", d.Len())
+	}
+	for i := 0; i < 10000; i++ {
+		if val, ok := d.Get(strconv.Itoa(i)); !ok || val != i {
+			t.Fatalf("decoded value of %d is not as expected", i)
+		}
+	}
+	if _, ok := d.Get("gone"); ok {
+		t.Error("expired entry should not be encoded")
+	}
+	var prev uintptr
+	for item := d.listHead.next(); item != nil; item = item.next() {
+		if item.keyHash < prev {
+			t.Fatal("decoded list should be sorted by hash")
+		}
+		prev = item.keyHash
+	}
+	d.Set("new", 1)
+	if val, ok := d.Get("new"); !ok || val != 1 || d.Len() != 10001 {
+		t.Error("decoded map should accept new entries")
+	}
+
+	// decoding into a non empty map merges the entries
+	data, err := New[string, int]().GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.GobDecode(data); err != nil || d.Len() != 10001 {
+		t.Errorf("decoding an empty map should not change the map %v", err)
+	}
+	small := New[string, int]()
+	small.Set("0", 100)
+	small.Set("a", 1)
+	if data, err = small.GobEncode(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.GobDecode(data); err != nil || d.Len() != 10002 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := d.Get("0"); val != 100 {
+		t.Error("decoded entry should overwrite the existing one")
+	}
+
+	// repeated keys keep the last value
+	e := New[string, int]()
+	e.build([]Pair[string, int]{{"a", 1}, {"b", 2}, {"a", 3}})
+	if val, _ := e.Get("a"); val != 3 || e.Len() != 2 {
+		t.Error("last repeated key should win")
+	}
+	if err = e.GobDecode([]byte("garbage")); err == nil {
+		t.Error("invalid data should fail")
+	}
+
+	// values are copied out of the pairs so that they do not keep the decoded slice alive
+	inline, boxed := New[string, int](), New[string, string]()
+	inlinePairs, boxedPairs := []Pair[string, int]{{"a", 1}}, []Pair[string, string]{{"a", "1"}}
+	inline.build(inlinePairs)
+	boxed.build(boxedPairs)
+	inlinePairs[0].Value, boxedPairs[0].Value = 2, "2"
+	if *inline.valueOf(inline.probe("a")) != 1 || *boxed.valueOf(boxed.probe("a")) != "1" {
+		t.Error("built entries should not point into the pairs")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := New[int, string]()
+	for i := -5000; i < 5000; i++ {
+		m.Set(i, strings.Repeat("v", i&63))
+	}
+	m.SetWithTTL(1<<40, "gone", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("writing failed %d %v", n, err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+	d := New[int, string]()
+	if n, err = d.ReadFrom(&buf); err != nil || n != int64(len(data)) || d.Len() != 10000 {
+		t.Fatalf("reading failed %d %v", n, err)
+	}
+	for i := -5000; i < 5000; i++ {
+		if val, ok := d.Get(i); !ok || val != strings.Repeat("v", i&63) {
+			t.Fatalf("read value of %d is not as expected", i)
+		}
+	}
+	if _, ok := d.Get(1 << 40); ok {
+		t.Error("expired entry should not be written")
+	}
+
+	// the map is left unchanged upon errors
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 1
+	if _, err = d.ReadFrom(bytes.NewReader(corrupt)); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("checksum mismatch should fail, got %v", err)
+	}
+	if _, err = d.ReadFrom(bytes.NewReader(data[:len(data)-10])); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated snapshot should fail, got %v", err)
+	}
+	if _, err = d.ReadFrom(strings.NewReader("HAXM\x09")); err == nil {
+		t.Error("unknown version should fail")
+	}
+	// a corrupt length only allocates as much memory as the snapshot holds
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err = d.ReadFrom(bytes.NewReader(appendUvarint([]byte("HAXM\x01"), maxSnapshotSize))); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated block should fail, got %v", err)
+	}
+	if runtime.ReadMemStats(&after); after.TotalAlloc-before.TotalAlloc > 1<<20 {
+		t.Errorf("reading a truncated block allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+	}
+	if d.Len() != 10000 {
+		t.Error("failed reads should not change the map")
+	}
+
+	// marshaled keys, fixed size values and reading into a non empty map
+	type point struct{ X, Y float64 }
+	p := New[netip.Addr, point]()
+	p.Set(netip.MustParseAddr("10.0.0.1"), point{1, 2})
+	p.Set(netip.MustParseAddr("::1"), point{-3, 0.5})
+	buf.Reset()
+	if _, err = p.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q := New[netip.Addr, point]()
+	q.Set(netip.MustParseAddr("10.0.0.2"), point{})
+	if _, err = q.ReadFrom(&buf); err != nil || q.Len() != 3 {
+		t.Fatalf("reading failed %v", err)
+	}
+	if val, _ := q.Get(netip.MustParseAddr("::1")); val != (point{-3, 0.5}) {
+		t.Error("read value is not as expected")
+	}
+
+	c := New[uint8, complex128]()
+	c.Set(255, complex(1.5, -2))
+	buf.Reset()
+	if _, err = c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	e := New[uint8, complex128]()
+	if _, err = e.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := e.Get(255); !ok || val != complex(1.5, -2) {
+		t.Error("read value is not as expected")
+	}
+
+	if _, err = New[string, []int]().WriteTo(&buf); err == nil {
+		t.Error("unsupported value type should fail")
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 1)
+	data, err := m.MarshalMsgpack()
+	if err != nil || !bytes.Equal(data, []byte{0x81, 0xa1, 'a', 0x01}) {
+		t.Fatalf("unexpected encoding %x %v", data, err)
+	}
+
+	type record struct {
+		Name    string            `msgpack:"name"`
+		Scores  []float64         `msgpack:"scores,omitempty"`
+		Tags    map[string]string `msgpack:"tags"`
+		Next    *record           `msgpack:"next"`
+		Raw     []byte            `msgpack:"raw"`
+		Extra   any               `msgpack:"extra"`
+		Skipped int               `msgpack:"-"`
+		hidden  int
+	}
+	r := New[int64, record]()
+	r.Set(-1<<40, record{Name: strings.Repeat("n", 300), Scores: []float64{1.5, -2}, Tags: map[string]string{"k": "v"}})
+	r.Set(7, record{Next: &record{Name: "next"}, Raw: []byte{0, 1}, Extra: []any{"x", int64(-3), true}, Skipped: 1, hidden: 2})
+	if data, err = r.MarshalMsgpack(); err != nil {
+		t.Fatal(err)
+	}
+	d := New[int64, record]()
+	if err = d.UnmarshalMsgpack(data); err != nil || d.Len() != 2 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := d.Get(-1 << 40); len(val.Name) != 300 || len(val.Scores) != 2 || val.Scores[1] != -2 || val.Tags["k"] != "v" {
+		t.Errorf("decoded value is not as expected %+v", val)
+	}
+	val, _ := d.Get(7)
+	if val.Next == nil || val.Next.Name != "next" || !bytes.Equal(val.Raw, []byte{0, 1}) || val.Skipped != 0 || val.hidden != 0 {
+		t.Errorf("decoded value is not as expected %+v", val)
+	}
+	if extra, ok := val.Extra.([]any); !ok || len(extra) != 3 || extra[0] != "x" || extra[1] != int64(-3) || extra[2] != true {
+		t.Errorf("decoded generic value is not as expected %#v", val.Extra)
+	}
+
+	// binary marshaled keys, integers of all sizes and merging into a non empty map
+	p := New[netip.Addr, uint64]()
+	p.Set(netip.MustParseAddr("10.0.0.1"), math.MaxUint64)
+	p.Set(netip.MustParseAddr("::1"), 200)
+	if data, err = p.MarshalMsgpack(); err != nil {
+		t.Fatal(err)
+	}
+	q := New[netip.Addr, uint64]()
+	q.Set(netip.MustParseAddr("10.0.0.2"), 1)
+	if err = q.UnmarshalMsgpack(data); err != nil || q.Len() != 3 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := q.Get(netip.MustParseAddr("10.0.0.1")); val != math.MaxUint64 {
+		t.Error("decoded value is not as expected")
+	}
+	small := New[netip.Addr, uint8]()
+	if err = small.UnmarshalMsgpack(data); err == nil {
+		t.Error("overflowing value should fail")
+	}
+
+	if err = q.UnmarshalMsgpack([]byte{0xc0}); err != nil || q.Len() != 3 {
+		t.Error("nil should leave the map as is")
+	}
+	if err = q.UnmarshalMsgpack([]byte{0xdf, 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("truncated data should fail")
+	}
+	if err = q.UnmarshalMsgpack([]byte{0x91, 0x01}); err == nil {
+		t.Error("non-map should fail")
+	}
+	if _, err = NewWithOptions[int, func()]().MarshalMsgpack(); err != nil {
+		t.Error("empty map should encode whatever its value type")
+	}
+	f := New[int, func()]()
+	f.Set(1, func() {})
+	if _, err = f.MarshalMsgpack(); err == nil {
+		t.Error("unsupported value type should fail")
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 1)
+	data, err := m.MarshalCBOR()
+	if err != nil || !bytes.Equal(data, []byte{0xa1, 0x61, 'a', 0x01}) {
+		t.Fatalf("unexpected encoding %x %v", data, err)
+	}
+
+	// deterministic encoding does not depend on hashes or insertion order
+	a, b := NewWithOptions[int, string](WithDeterministicCBOR()), NewWithOptions[int, string](WithDeterministicCBOR(), WithSeed(42))
+	for i := 0; i < 100; i++ {
+		a.Set(i-50, strconv.Itoa(i))
+		b.Set(49-i, strconv.Itoa(99-i))
+	}
+	encodedA, err := a.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encodedB, _ := b.MarshalCBOR(); !bytes.Equal(encodedA, encodedB) {
+		t.Error("equal maps should be encoded to equal bytes")
+	}
+	// 0 and positive integers sort before negative ones, shorter encodings before longer ones
+	if !bytes.HasPrefix(encodedA, []byte{0xb8, 100, 0x00, 0x62, '5', '0', 0x01}) {
+		t.Errorf("keys should be sorted by their encoding %x", encodedA[:8])
+	}
+	if !NewLike(a).sortedCBOR {
+		t.Error("deterministic encoding should be carried over by NewLike")
+	}
+
+	type record struct {
+		Name   string            `cbor:"name"`
+		Scores []float64         `json:"scores,omitempty"`
+		Tags   map[string]string `cbor:"tags"`
+		Next   *record           `cbor:"next"`
+		Raw    []byte            `cbor:"raw"`
+		Extra  any               `cbor:"extra"`
+		hidden int
+	}
+	r := NewWithOptions[int64, record](WithDeterministicCBOR())
+	r.Set(-1<<40, record{Name: strings.Repeat("n", 300), Scores: []float64{1.5, 0.1}, Tags: map[string]string{"k": "v", "j": "w"}})
+	r.Set(7, record{Next: &record{Name: "next"}, Raw: []byte{0, 1}, Extra: map[string]any{"x": []any{int64(-3), true}}, hidden: 2})
+	if data, err = r.MarshalCBOR(); err != nil {
+		t.Fatal(err)
+	}
+	d := New[int64, record]()
+	if err = d.UnmarshalCBOR(data); err != nil || d.Len() != 2 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := d.Get(-1 << 40); len(val.Name) != 300 || len(val.Scores) != 2 || val.Scores[1] != 0.1 || val.Tags["j"] != "w" {
+		t.Errorf("decoded value is not as expected %+v", val)
+	}
+	val, _ := d.Get(7)
+	if val.Next == nil || val.Next.Name != "next" || !bytes.Equal(val.Raw, []byte{0, 1}) || val.hidden != 0 {
+		t.Errorf("decoded value is not as expected %+v", val)
+	}
+	if extra, ok := val.Extra.(map[string]any); !ok || fmt.Sprint(extra["x"]) != "[-3 true]" {
+		t.Errorf("decoded generic value is not as expected %#v", val.Extra)
+	}
+
+	// half precision floats, tags and binary marshaled keys
+	f := New[string, float32]()
+	if err = f.UnmarshalCBOR([]byte{0xa2, 0x61, 'h', 0xf9, 0x3c, 0x00, 0xc1, 0x61, 't', 0xf9, 0xc0, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := f.Get("h"); h != 1 {
+		t.Errorf("half precision float should decode to 1, got %v", h)
+	}
+	if tagged, _ := f.Get("t"); tagged != -2 {
+		t.Errorf("tagged half precision float should decode to -2, got %v", tagged)
+	}
+	p := New[netip.Addr, uint64]()
+	p.Set(netip.MustParseAddr("10.0.0.1"), math.MaxUint64)
+	if data, err = p.MarshalCBOR(); err != nil {
+		t.Fatal(err)
+	}
+	q := New[netip.Addr, uint64]()
+	q.Set(netip.MustParseAddr("::1"), 1)
+	if err = q.UnmarshalCBOR(data); err != nil || q.Len() != 2 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	if val, _ := q.Get(netip.MustParseAddr("10.0.0.1")); val != math.MaxUint64 {
+		t.Error("decoded value is not as expected")
+	}
+	small := New[netip.Addr, uint8]()
+	if err = small.UnmarshalCBOR(data); err == nil {
+		t.Error("overflowing value should fail")
+	}
+
+	if err = q.UnmarshalCBOR([]byte{0xf6}); err != nil || q.Len() != 2 {
+		t.Error("null should leave the map as is")
+	}
+	if err = q.UnmarshalCBOR([]byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("truncated data should fail")
+	}
+	if err = q.UnmarshalCBOR([]byte{0xbf, 0xff}); err == nil {
+		t.Error("indefinite length map should fail")
+	}
+	if err = q.UnmarshalCBOR([]byte{0x81, 0x01}); err == nil {
+		t.Error("non-map should fail")
+	}
+	u := New[int, func()]()
+	u.Set(1, func() {})
+	if _, err = u.MarshalCBOR(); err == nil {
+		t.Error("unsupported value type should fail")
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := t.TempDir() + "/store"
+	s, err := OpenStore[string, int](path)
+	if err != nil {
+		t.Skipf("stores are unavailable: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		if err = s.Set(strconv.Itoa(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10000; i += 2 {
+		if err = s.Set(strconv.Itoa(i), -i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10000; i += 5 {
+		if err = s.Del(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(s *Store[string, int]) {
+		t.Helper()
+		if s.Len() != 8000 {
+			t.Fatalf("store should have 8000 entries, got %d", s.Len())
+		}
+		for i := 0; i < 10000; i++ {
+			val, ok, err := s.Get(strconv.Itoa(i))
+			switch {
+			case err != nil:
+				t.Fatal(err)
+			case i%5 == 0 && ok:
+				t.Fatalf("deleted key %d should not be found", i)
+			case i%5 != 0 && i%2 == 0 && (!ok || val != -i), i%5 != 0 && i%2 == 1 && (!ok || val != i):
+				t.Fatalf("unexpected value for key %d: %d %v", i, val, ok)
+			}
+		}
+	}
+	check(s)
+	if s.Garbage() == 0 {
+		t.Error("overwritten and deleted records should be accounted as garbage")
+	}
+
+	// reopening loads the checkpoint of the index
+	garbage := s.Garbage()
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Get("1"); err != ErrStoreClosed {
+		t.Errorf("closed store should fail, got %v", err)
+	}
+	if s, err = OpenStore[string, int](path); err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+	if s.Garbage() != garbage {
+		t.Errorf("garbage should be restored, got %d instead of %d", s.Garbage(), garbage)
+	}
+
+	// records written after the checkpoint are replayed and a torn tail is truncated after a crash
+	stale, err := os.ReadFile(path + ".index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set("extra", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	os.WriteFile(path+".index", stale, 0o644)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{1, 2, 3, 4, 5, 6})
+	f.Close()
+	if s, err = OpenStore[string, int](path); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok, _ := s.Get("extra"); !ok || val != 1 || s.Len() != 8001 {
+		t.Error("records after the checkpoint should be replayed")
+	}
+	if recovered, _ := os.Stat(path); recovered.Size() != info.Size() {
+		t.Errorf("torn tail should be truncated, size %d instead of %d", recovered.Size(), info.Size())
+	}
+	s.Close()
+
+	// a full scan rebuilds the index without a checkpoint
+	os.Remove(path + ".index")
+	if s, err = OpenStore[string, int](path); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Del("extra"); err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+
+	// compaction reclaims garbage while keeping live entries, the checkpoint of the previous log is ignored
+	if err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+	compacted, _ := os.Stat(path)
+	if s.Garbage() != 0 || compacted.Size() >= info.Size() {
+		t.Errorf("compaction should reclaim garbage, size %d, garbage %d", compacted.Size(), s.Garbage())
+	}
+	visited := 0
+	if err = s.ForEach(func(key string, value int) bool {
+		visited++
+		return true
+	}); err != nil || visited != 8000 {
+		t.Errorf("all entries should be visited, got %d %v", visited, err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path+".index", stale, 0o644)
+	if s, err = OpenStore[string, int](path); err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+	s.Close()
+
+	if err = os.WriteFile(path, []byte("not a store"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenStore[string, int](path); err != ErrCorruptStore {
+		t.Errorf("malformed log should fail, got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	// fan-out to several workers
+	var (
+		sum int64
+		wg  sync.WaitGroup
+	)
+	pairs := m.Stream(context.Background())
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range pairs {
+				atomic.AddInt64(&sum, int64(pair.Value))
+			}
+		}()
+	}
+	wg.Wait()
+	if sum != 999*1000/2 {
+		t.Errorf("all pairs should be streamed, got sum %d", sum)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := 0
+	for range m.Stream(ctx) {
+		if received++; received == 10 {
+			cancel()
+		}
+	}
+	if received < 10 || received > 11 {
+		t.Errorf("stream should stop once cancelled, received %d", received)
+	}
+
+	errStop := errors.New("stop")
+	emitted := 0
+	err := m.Emit(context.Background(), func(int, int) error {
+		if emitted++; emitted == 5 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || emitted != 5 {
+		t.Errorf("emit should stop at the first error, got %v after %d", err, emitted)
+	}
+	if err = m.Emit(ctx, func(int, int) error { return nil }); err != context.Canceled {
+		t.Errorf("emit should fail with a done context, got %v", err)
+	}
+}
+
+func TestSnapshotSince(t *testing.T) {
+	m := NewWithOptions[string, int](WithChangeTracking())
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		m.Set(key, i)
+	}
+	replica := make(map[string]int)
+	apply := func(delta Delta[string, int]) {
+		if delta.Full {
+			replica = make(map[string]int)
+		}
+		for _, key := range delta.Deleted {
+			delete(replica, key)
+		}
+		for _, pair := range delta.Set {
+			replica[pair.Key] = pair.Value
+		}
+	}
+	check := func() {
+		t.Helper()
+		if len(replica) != int(m.Len()) {
+			t.Fatalf("replica holds %d entries instead of %d", len(replica), m.Len())
+		}
+		m.ForEach(func(key string, value int) bool {
+			if replica[key] != value {
+				t.Fatalf("replica holds %d for %s instead of %d", replica[key], key, value)
+			}
+			return true
+		})
+	}
+
+	full := m.SnapshotSince(0)
+	if !full.Full || len(full.Set) != 5 {
+		t.Fatalf("first snapshot should be full, got %+v", full)
+	}
+	apply(full)
+
+	m.Set("b", 10)
+	m.Del("c")
+	m.Swap("f", 5)
+	m.Compute("d", func(int, bool) (int, bool) { return 0, true })
+	m.CompareAndSwap("e", 4, 40)
+	delta := m.SnapshotSince(full.Seq)
+	if delta.Full || len(delta.Set) != 3 || len(delta.Deleted) != 2 {
+		t.Fatalf("delta should hold 3 writes and 2 deletions, got %+v", delta)
+	}
+	apply(delta)
+	check()
+	if next := m.SnapshotSince(delta.Seq); len(next.Set) != 0 || len(next.Deleted) != 0 || next.Seq != delta.Seq {
+		t.Errorf("delta of an unchanged map should be empty, got %+v", next)
+	}
+
+	m.Del("a")
+	m.TrimChanges(delta.Seq)
+	if d := m.SnapshotSince(full.Seq); !d.Full {
+		t.Error("delta older than the trimmed changes should be full")
+	}
+	d := m.SnapshotSince(delta.Seq)
+	if d.Full || len(d.Deleted) != 1 || d.Deleted[0] != "a" {
+		t.Errorf("deletions after the trimmed ones should be kept, got %+v", d)
+	}
+	apply(d)
+	check()
+
+	// concurrent writers while deltas are taken
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(i % 100)
+				if i%7 == w {
+					m.Del(key)
+				} else {
+					m.Set(key, i*w)
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	seq := d.Seq
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		delta := m.SnapshotSince(seq)
+		apply(delta)
+		seq = delta.Seq
+	}
+	// a write stamped after the delta was taken must be part of the next one, even if the map no longer changes
+	if final := m.SnapshotSince(seq); len(final.Set) != 0 || len(final.Deleted) != 0 {
+		t.Fatalf("writes finished before the last delta should be part of it, got %+v", final)
+	}
+	check()
+
+	if untracked := New[string, int](); !untracked.SnapshotSince(1).Full {
+		t.Error("snapshot of an untracked map should be full")
+	}
+}
+
+func TestJSONTextKeys(t *testing.T) {
+	// mirrors encoding/json for a built-in map of the same types
+	builtin := map[userID][]int{3: {1}, 12: nil, -1: {2, 3}}
+	expected, err := json.Marshal(builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewFromMap(builtin)
+	data, err := json.Marshal(m)
+	if err != nil || string(data) != string(expected) {
+		t.Fatalf("encoding %s should match the one of a built-in map %s, error %v", data, expected, err)
+	}
+	if string(data) != `{"user--1":[2,3],"user-12":null,"user-3":[1]}` {
+		t.Errorf("keys should be marshaled as text, got %s", data)
+	}
+
+	d := New[userID, []int]()
+	if err = json.Unmarshal(expected, d); err != nil || d.Len() != 3 {
+		t.Fatalf("decoding failed %v", err)
+	}
+	for key, value := range builtin {
+		if val, ok := d.Get(key); !ok || len(val) != len(value) {
+			t.Errorf("key %d did not survive the round-trip", key)
+		}
+	}
+	if err = json.Unmarshal([]byte(`{"user-x":[]}`), d); err == nil {
+		t.Error("error of the text unmarshaler should be returned")
+	}
+}
+
+func TestProtoMap(t *testing.T) {
+	src := make(map[string]*Animal, 1000)
+	for i := 0; i < 1000; i++ {
+		src[strconv.Itoa(i)] = &Animal{name: strconv.Itoa(i)}
+	}
+	m := FromProtoMap(src)
+	if m.Len() != 1000 {
+		t.Fatalf("map should hold 1000 entries, got %d", m.Len())
+	}
+	for key, value := range src {
+		if val, ok := m.Get(key); !ok || val != value {
+			t.Fatalf("value of %s should be shared with the source map", key)
+		}
+	}
+	if data := m.metadata.Load(); uintptr(len(data.index)) < fitSize(1000, maxFillRate) {
+		t.Error("map should be allocated to fit all entries")
+	}
+
+	var fields map[string]*Animal
+	if fields = AppendToProtoMap(fields, m); len(fields) != 1000 || fields["7"] != src["7"] {
+		t.Error("nil map should be allocated and filled")
+	}
+	fields = map[string]*Animal{"7": nil, "extra": nil}
+	if fields = AppendToProtoMap(fields, m); len(fields) != 1001 || fields["7"] != src["7"] {
+		t.Error("existing map should be overwritten and extended")
+	}
+}
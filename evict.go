@@ -0,0 +1,36 @@
+package haxmap
+
+// Reason tells why an entry was removed from the map without being deleted by the user, see OnEvict
+type Reason uint8
+
+const (
+	// ReasonExpired denotes an entry whose ttl ran out, see SetWithTTL
+	ReasonExpired Reason = iota
+	// ReasonEvicted denotes an entry evicted to make room in a full bounded map, see SetMaxEntries
+	ReasonEvicted
+	// ReasonCleared denotes an entry removed by Clear
+	ReasonCleared
+)
+
+// String returns the name of the reason
+func (r Reason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonCleared:
+		return "cleared"
+	}
+	return "unknown"
+}
+
+// OnEvict sets a callback notified of every entry removed by expiry, capacity eviction or Clear, nil disables it
+// Entries deleted by the user, through Del, GetAndDel, Drain or Compute, are not reported
+// The callback is called synchronously by the goroutine which removed the entry, like the janitor or a reader
+// coming across an expired entry, hence it should be fast and must not block
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) OnEvict(callback func(key K, value V, reason Reason)) {
+	m.initialize()
+	m.onEvict = callback
+}
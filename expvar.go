@@ -0,0 +1,41 @@
+package haxmap
+
+import "expvar"
+
+// expvarStats is the JSON representation of a map published with expvar
+type expvarStats struct {
+	Len            uintptr `json:"len"`
+	FillRate       uintptr `json:"fill_rate"`
+	Resizes        uintptr `json:"resizes"`
+	Lookups        uintptr `json:"lookups"`
+	ProbeSteps     uintptr `json:"probe_steps"`
+	MaxProbeLength uintptr `json:"max_probe_length"`
+	InsertRetries  uintptr `json:"insert_retries"`
+}
+
+// Expvar returns an expvar.Var reporting the length, the fill rate and the statistics of the map as a JSON object
+// The values are read from the map whenever the variable is formatted, like when /debug/vars is served
+// The counters of Stats are reported as 0 unless they were enabled, see EnableStats
+func (m *Map[K, V]) Expvar() expvar.Var {
+	m.initialize()
+	return expvar.Func(func() any {
+		stats := m.Stats()
+		return expvarStats{
+			Len:            m.Len(),
+			FillRate:       stats.FillRate,
+			Resizes:        stats.Resizes,
+			Lookups:        stats.Lookups,
+			ProbeSteps:     stats.ProbeSteps,
+			MaxProbeLength: stats.MaxProbeLength,
+			InsertRetries:  stats.InsertRetries,
+		}
+	})
+}
+
+// PublishExpvar publishes the variable returned by Expvar under the given name, see expvar.Publish
+// Like expvar.Publish it panics if the name is already in use
+func (m *Map[K, V]) PublishExpvar(name string) expvar.Var {
+	v := m.Expvar()
+	expvar.Publish(name, v)
+	return v
+}
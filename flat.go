@@ -0,0 +1,319 @@
+package haxmap
+
+import (
+	"math/bits"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+const (
+	flatGroupSize = 8                          // number of slots per group, one control byte each
+	flatMaxLoad   = 7                          // eighths of the slots which may be used before rehashing
+	ctrlEmpty     = 0x80                       // control byte of a slot which never held an entry
+	ctrlDeleted   = 0xFE                       // control byte of a slot whose entry was deleted, probing continues past it
+	ctrlLSB       = uint64(0x0101010101010101) // least significant bit of every control byte
+	ctrlMSB       = uint64(0x8080808080808080) // most significant bit of every control byte
+)
+
+type (
+	// FlatMap is an alternative backend to Map storing its entries in open-addressing tables in the style of SwissTable
+	// Entries are stored inline in groups of 8 slots along with 8 control bytes holding 7 bits of the hash of every
+	// slot, a lookup matches the control bytes of a group with a few word-wide instructions and only compares the keys
+	// of the candidates, hence it touches one or two cache lines instead of chasing the pointers of a list
+	// The tables are split into shards guarded by a read-write mutex each, so unlike with Map a writer blocks the
+	// readers of its shard, which suits read-mostly maps with small keys and values such as integers
+	// Keys are hashed and compared like the keys of Map, create a FlatMap with NewFlat
+	FlatMap[K hashable, V any] struct {
+		hasher   func(K) uintptr
+		keyEqual func(a, b K) bool
+		shift    uintptr // right shift of a hash selecting its shard from the most significant bits
+		shards   []flatShard[K, V]
+	}
+
+	// flatShard is an open-addressing table whose number of groups is a power of 2
+	flatShard[K hashable, V any] struct {
+		mu      sync.RWMutex
+		groups  []flatGroup[K, V]
+		count   uintptr  // number of slots holding an entry
+		deleted uintptr  // number of deleted slots, reclaimed by the next rehash
+		_       [64]byte // keeps the mutexes of neighbouring shards on distinct cache lines
+	}
+
+	// flatGroup holds 8 slots, the control byte of slot i is stored in bits 8i to 8i+7 of ctrl and is either
+	// ctrlEmpty, ctrlDeleted or the 7 least significant bits of the hash of the key of the slot
+	flatGroup[K hashable, V any] struct {
+		ctrl   uint64
+		keys   [flatGroupSize]K
+		values [flatGroupSize]V
+	}
+)
+
+// NewFlat returns a new FlatMap instance with an optional number of entries to make room for
+func NewFlat[K hashable, V any](size ...uintptr) *FlatMap[K, V] {
+	var proto Map[K, V] // borrows the default hasher and key equality of Map
+	proto.seed = randomSeed()
+	proto.setDefaultHasher()
+	proto.setDefaultKeyEqual()
+
+	shards := roundUpPower2(uintptr(runtime.GOMAXPROCS(0)))
+	f := &FlatMap[K, V]{
+		hasher:   proto.hasher,
+		keyEqual: proto.keyEqual,
+		shift:    strconv.IntSize - log2(shards),
+		shards:   make([]flatShard[K, V], shards),
+	}
+	var perShard uintptr
+	if len(size) > 0 {
+		perShard = (size[0] + shards - 1) / shards
+	}
+	for idx := range f.shards {
+		f.shards[idx].groups = newFlatGroups[K, V](flatGroupsFor(perShard))
+	}
+	return f
+}
+
+// Get retrieves an element from the map under given hash key
+func (f *FlatMap[K, V]) Get(key K) (value V, ok bool) {
+	h := f.hasher(key)
+	s := f.shard(h)
+	s.mu.RLock()
+	if g, i, found := s.find(h, key, f.keyEqual); found {
+		value, ok = s.groups[g].values[i], true
+	}
+	s.mu.RUnlock()
+	return
+}
+
+// Set tries to update an element if key is present else it inserts a new element
+func (f *FlatMap[K, V]) Set(key K, value V) {
+	h := f.hasher(key)
+	s := f.shard(h)
+	s.mu.Lock()
+	if g, i, found := s.find(h, key, f.keyEqual); found {
+		s.groups[g].values[i] = value
+	} else {
+		s.insert(h, key, value, f.hasher)
+	}
+	s.mu.Unlock()
+}
+
+// GetOrSet returns the existing value for the key if present
+// Otherwise, it stores and returns the given value
+// The loaded result is true if the value was loaded, false if stored
+func (f *FlatMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	h := f.hasher(key)
+	s := f.shard(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, i, found := s.find(h, key, f.keyEqual); found {
+		return s.groups[g].values[i], true
+	}
+	s.insert(h, key, value, f.hasher)
+	return value, false
+}
+
+// Del deletes key/keys from the map
+func (f *FlatMap[K, V]) Del(keys ...K) {
+	for _, key := range keys {
+		h := f.hasher(key)
+		s := f.shard(h)
+		s.mu.Lock()
+		if g, i, found := s.find(h, key, f.keyEqual); found {
+			s.remove(g, i)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Len returns the number of key-value pairs within the map
+func (f *FlatMap[K, V]) Len() (count uintptr) {
+	for idx := range f.shards {
+		s := &f.shards[idx]
+		s.mu.RLock()
+		count += s.count
+		s.mu.RUnlock()
+	}
+	return
+}
+
+// ForEach iterates over key-value pairs and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// The pairs of a shard are copied before calling lambda on them, hence lambda may modify the map
+func (f *FlatMap[K, V]) ForEach(lambda func(K, V) bool) {
+	var pairs []Pair[K, V]
+	for idx := range f.shards {
+		s := &f.shards[idx]
+		s.mu.RLock()
+		pairs = pairs[:0]
+		for g := range s.groups {
+			group := &s.groups[g]
+			for full := matchFull(group.ctrl); full != 0; full &= full - 1 {
+				i := matchedSlot(full)
+				pairs = append(pairs, Pair[K, V]{Key: group.keys[i], Value: group.values[i]})
+			}
+		}
+		s.mu.RUnlock()
+		for _, pair := range pairs {
+			if !lambda(pair.Key, pair.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Clear removes all entries, the tables keep their size
+func (f *FlatMap[K, V]) Clear() {
+	for idx := range f.shards {
+		s := &f.shards[idx]
+		s.mu.Lock()
+		s.groups = newFlatGroups[K, V](uintptr(len(s.groups)))
+		s.count, s.deleted = 0, 0
+		s.mu.Unlock()
+	}
+}
+
+// shard returns the shard of a hash
+func (f *FlatMap[K, V]) shard(h uintptr) *flatShard[K, V] {
+	return &f.shards[h>>f.shift]
+}
+
+// find returns the group and the slot holding the key, probing the groups from the one addressed by the hash in
+// triangular steps, which visits every group of a power of 2 table, until a group with an empty slot
+func (s *flatShard[K, V]) find(h uintptr, key K, equal func(a, b K) bool) (g, i uintptr, found bool) {
+	mask := uintptr(len(s.groups) - 1)
+	g = (h >> 7) & mask
+	for step := uintptr(1); ; step++ {
+		group := &s.groups[g]
+		for match := matchHash(group.ctrl, h); match != 0; match &= match - 1 {
+			i = matchedSlot(match)
+			if keysEqual(equal, group.keys[i], key) {
+				return g, i, true
+			}
+		}
+		if matchEmpty(group.ctrl) != 0 {
+			return 0, 0, false
+		}
+		g = (g + step) & mask
+	}
+}
+
+// insert stores a key which is absent in the first free slot of its probe sequence, rehashing the table beforehand
+// if it is full up to the maximum load
+func (s *flatShard[K, V]) insert(h uintptr, key K, value V, hasher func(K) uintptr) {
+	if capacity := uintptr(len(s.groups)) * flatGroupSize; (s.count+s.deleted+1)*8 > capacity*flatMaxLoad {
+		if (s.count+1)*16 > capacity*flatMaxLoad { // more than half full even without the deleted slots
+			s.rehash(uintptr(len(s.groups))*2, hasher)
+		} else {
+			s.rehash(uintptr(len(s.groups)), hasher)
+		}
+	}
+	g, i := s.free(h)
+	group := &s.groups[g]
+	if group.ctrl>>(i*8)&0xFF == ctrlDeleted {
+		s.deleted--
+	}
+	group.setCtrl(i, byte(h&0x7F))
+	group.keys[i], group.values[i] = key, value
+	s.count++
+}
+
+// free returns the first empty or deleted slot of the probe sequence of a hash
+func (s *flatShard[K, V]) free(h uintptr) (g, i uintptr) {
+	mask := uintptr(len(s.groups) - 1)
+	g = (h >> 7) & mask
+	for step := uintptr(1); ; step++ {
+		if match := matchEmptyOrDeleted(s.groups[g].ctrl); match != 0 {
+			return g, matchedSlot(match)
+		}
+		g = (g + step) & mask
+	}
+}
+
+// remove clears a slot, which becomes empty if its group has an empty slot since probing stops at such a group anyway,
+// and deleted otherwise so that probing continues past it
+func (s *flatShard[K, V]) remove(g, i uintptr) {
+	group := &s.groups[g]
+	if matchEmpty(group.ctrl) != 0 {
+		group.setCtrl(i, ctrlEmpty)
+	} else {
+		group.setCtrl(i, ctrlDeleted)
+		s.deleted++
+	}
+	var (
+		key   K
+		value V
+	)
+	group.keys[i], group.values[i] = key, value // releases references for the garbage collector
+	s.count--
+}
+
+// rehash moves the entries to a table of the given number of groups, dropping the deleted slots
+// The hashes are computed again from the keys as the control bytes only keep 7 bits of them
+func (s *flatShard[K, V]) rehash(groups uintptr, hasher func(K) uintptr) {
+	old := s.groups
+	s.groups, s.deleted = newFlatGroups[K, V](groups), 0
+	for g := range old {
+		group := &old[g]
+		for full := matchFull(group.ctrl); full != 0; full &= full - 1 {
+			i := matchedSlot(full)
+			h := hasher(group.keys[i])
+			ng, ni := s.free(h)
+			s.groups[ng].setCtrl(ni, byte(h&0x7F))
+			s.groups[ng].keys[ni], s.groups[ng].values[ni] = group.keys[i], group.values[i]
+		}
+	}
+}
+
+// setCtrl sets the control byte of a slot
+func (group *flatGroup[K, V]) setCtrl(i uintptr, ctrl byte) {
+	group.ctrl = group.ctrl&^(0xFF<<(i*8)) | uint64(ctrl)<<(i*8)
+}
+
+// newFlatGroups returns a table of empty groups
+func newFlatGroups[K hashable, V any](n uintptr) []flatGroup[K, V] {
+	groups := make([]flatGroup[K, V], n)
+	for g := range groups {
+		groups[g].ctrl = ctrlLSB * ctrlEmpty
+	}
+	return groups
+}
+
+// flatGroupsFor returns the number of groups, a power of 2, fitting the number of entries under the maximum load
+func flatGroupsFor(entries uintptr) uintptr {
+	slots := (entries*8 + flatMaxLoad - 1) / flatMaxLoad
+	if slots <= flatGroupSize {
+		return 1
+	}
+	return roundUpPower2((slots + flatGroupSize - 1) / flatGroupSize)
+}
+
+// The match functions return a mask with the most significant bit of the matching control bytes set, they process the
+// 8 control bytes of a group at once with integer arithmetic instead of SIMD instructions
+
+// matchHash matches the control bytes equal to the 7 least significant bits of the hash, it might report a false
+// positive right after a true one, which is harmless as the keys are compared
+func matchHash(ctrl uint64, h uintptr) uint64 {
+	x := ctrl ^ (ctrlLSB * uint64(h&0x7F))
+	return (x - ctrlLSB) &^ x & ctrlMSB
+}
+
+// matchEmpty matches the empty control bytes, the only ones with bit 7 set and bit 1 unset
+func matchEmpty(ctrl uint64) uint64 {
+	return ctrl &^ (ctrl << 6) & ctrlMSB
+}
+
+// matchEmptyOrDeleted matches the empty and deleted control bytes, the only ones with bit 7 set and bit 0 unset
+func matchEmptyOrDeleted(ctrl uint64) uint64 {
+	return ctrl &^ (ctrl << 7) & ctrlMSB
+}
+
+// matchFull matches the control bytes of the slots holding an entry, whose bit 7 is unset
+func matchFull(ctrl uint64) uint64 {
+	return ^ctrl & ctrlMSB
+}
+
+// matchedSlot returns the slot of the lowest control byte of a match
+func matchedSlot(match uint64) uintptr {
+	return uintptr(bits.TrailingZeros64(match) >> 3)
+}
@@ -0,0 +1,80 @@
+package haxmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// formatLimit is the number of pairs printed by String and GoString, so that logging a large map stays cheap
+const formatLimit = 16
+
+// String implements the fmt.Stringer interface.
+// The pairs are printed like a built-in map by fmt in the order of the list, at most the first 16 of them followed by
+// the number of pairs left out
+func (m *Map[K, V]) String() string {
+	var b strings.Builder
+	b.WriteString("map[")
+	m.format(&b, " ", "%v:%v", "...+%d")
+	b.WriteByte(']')
+	return b.String()
+}
+
+// GoString implements the fmt.GoStringer interface.
+// The pairs are printed as a Go composite literal with the same limit as String, the pairs left out being counted in a
+// trailing comment
+func (m *Map[K, V]) GoString() string {
+	var (
+		key   K
+		value V
+		b     strings.Builder
+	)
+	fmt.Fprintf(&b, "&haxmap.Map[%T, %T]{", key, value)
+	m.format(&b, ", ", "%#v: %#v", "/* %d more */")
+	b.WriteByte('}')
+	return b.String()
+}
+
+// format writes the first pairs of the list up to formatLimit, then the number of remaining pairs if any
+func (m *Map[K, V]) format(b *strings.Builder, sep, pair, more string) {
+	m.initialize()
+	n := 0
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		if n == formatLimit {
+			if count := m.Len(); count > formatLimit {
+				b.WriteString(sep)
+				fmt.Fprintf(b, more, count-formatLimit)
+			}
+			return
+		}
+		if n > 0 {
+			b.WriteString(sep)
+		}
+		fmt.Fprintf(b, pair, item.key, *m.valueOf(item))
+		n++
+	}
+}
+
+// Dump writes the pairs of the map one per line in the order of the list, at most limit of them followed by the number
+// of pairs left out, a limit of zero or less dumps every pair
+// The pairs are written through a buffer as they are traversed hence the dump of a large map is not held in memory,
+// the traversal stops at the first write error
+func (m *Map[K, V]) Dump(w io.Writer, limit int) error {
+	m.initialize()
+	bw := bufio.NewWriter(w)
+	n := 0
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		if limit > 0 && n == limit {
+			if count := m.Len(); count > uintptr(limit) {
+				fmt.Fprintf(bw, "... %d more\n", count-uintptr(limit))
+			}
+			break
+		}
+		if _, err := fmt.Fprintf(bw, "%v: %v\n", item.key, *m.valueOf(item)); err != nil {
+			return err
+		}
+		n++
+	}
+	return bw.Flush()
+}
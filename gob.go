@@ -0,0 +1,93 @@
+package haxmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+)
+
+// GobEncode implements the gob.GobEncoder interface.
+// The entries are encoded as a slice of pairs collected in a single pass over the list, expiries are not encoded
+// hence entries stored with a ttl are decoded as entries which never expire
+func (m *Map[K, V]) GobEncode() ([]byte, error) {
+	m.initialize()
+	pairs := make([]Pair[K, V], 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		pairs = append(pairs, Pair[K, V]{Key: item.key, Value: *m.valueOf(item)})
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(pairs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements the gob.GobDecoder interface.
+// Decoding into an empty map links all entries in ascending order of hash and builds the index in a single pass
+// instead of inserting them one by one, a non empty map gets the entries inserted with SetMany
+// It must not be called concurrently with other operations on the map
+func (m *Map[K, V]) GobDecode(data []byte) error {
+	m.initialize()
+	var pairs []Pair[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&pairs); err != nil {
+		return err
+	}
+	if m.Len() > 0 || m.maxEntries > 0 {
+		m.SetMany(pairs...)
+		return nil
+	}
+	m.build(pairs)
+	return nil
+}
+
+// build links the pairs into the list of an empty map and indexes them in a single pass
+// if a key is repeated, the last pair for that key wins
+func (m *Map[K, V]) build(pairs []Pair[K, V]) {
+	elements := make([]*element[K, V], len(pairs))
+	for idx := range pairs {
+		// the value is copied like in Set, pointing into pairs would keep the whole decoded slice alive
+		elements[idx], _ = newElement(m.hasher(pairs[idx].Key), pairs[idx].Key, pairs[idx].Value, nil, m.inline)
+	}
+	// stable so that the last duplicate key comes last
+	sort.SliceStable(elements, func(i, j int) bool {
+		return elements[i].keyHash < elements[j].keyHash
+	})
+
+	var (
+		tail  = m.listHead
+		first = 0 // first element of the run of elements sharing the hash of the tail
+		count uintptr
+	)
+	for idx, elem := range elements {
+		if tail != m.listHead && elem.keyHash == tail.keyHash {
+			duplicate := false
+			for _, prev := range elements[first:idx] {
+				if prev.nextPtr.Load() != nil || prev == tail { // linked
+					if m.equal(prev.key, elem.key) {
+						m.notify(prev, prev.value.Swap(elem.value.Load()), elem.value.Load())
+						duplicate = true
+						break
+					}
+				}
+			}
+			if duplicate {
+				continue
+			}
+		} else {
+			first = idx
+		}
+		tail.nextPtr.Store(elem)
+		tail = elem
+		count++
+		m.changed(elem)
+		m.notify(elem, nil, elem.value.Load())
+	}
+	m.numItems.Store(count)
+
+	data := m.metadata.Load()
+	if size := fitSize(count, m.maxFillRate); roundUpPower2(size) > uintptr(len(data.index)) {
+		m.GrowAndWait(size) // re-indexes the list while growing
+	} else {
+		m.fillIndexItems(data)
+	}
+}
@@ -27,6 +27,7 @@ WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
 import (
 	"encoding/binary"
+	"hash/maphash"
 	"math/bits"
 	"reflect"
 	"unsafe"
@@ -49,8 +50,6 @@ const (
 	prime5 uint64 = 2870177450012600261
 )
 
-var prime1v = prime1
-
 func u64(b []byte) uint64 { return binary.LittleEndian.Uint64(b) }
 func u32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
 
@@ -78,235 +77,324 @@ func rol27(x uint64) uint64 { return bits.RotateLeft64(x, 27) }
 func rol31(x uint64) uint64 { return bits.RotateLeft64(x, 31) }
 
 // xxHash implementation for known key type sizes, minimal with no branching
-var (
-	// byte hasher, key size -> 1 byte
-	byteHasher = func(key uint8) uintptr {
-		h := prime5 + 1
-		h ^= uint64(key) * prime5
-		h = bits.RotateLeft64(h, 11) * prime1
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
-	}
+// every hasher takes a seed which is randomized per map, see randomSeed
+
+// byte hasher, key size -> 1 byte
+func byteHasher(key uint8, seed uint64) uintptr {
+	h := seed + prime5 + 1
+	h ^= uint64(key) * prime5
+	h = bits.RotateLeft64(h, 11) * prime1
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return uintptr(h)
+}
 
-	// word hasher, key size -> 2 bytes
-	wordHasher = func(key uint16) uintptr {
-		h := prime5 + 2
-		h ^= (uint64(key) & 0xff) * prime5
-		h = bits.RotateLeft64(h, 11) * prime1
-		h ^= ((uint64(key) >> 8) & 0xff) * prime5
-		h = bits.RotateLeft64(h, 11) * prime1
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
-	}
+// word hasher, key size -> 2 bytes
+func wordHasher(key uint16, seed uint64) uintptr {
+	h := seed + prime5 + 2
+	h ^= (uint64(key) & 0xff) * prime5
+	h = bits.RotateLeft64(h, 11) * prime1
+	h ^= ((uint64(key) >> 8) & 0xff) * prime5
+	h = bits.RotateLeft64(h, 11) * prime1
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return uintptr(h)
+}
+
+// dword hasher, key size -> 4 bytes
+func dwordHasher(key uint32, seed uint64) uintptr {
+	h := seed + prime5 + 4
+	h ^= uint64(key) * prime1
+	h = bits.RotateLeft64(h, 23)*prime2 + prime3
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return uintptr(h)
+}
+
+// qword hasher, key size -> 8 bytes
+func qwordHasher(key uint64, seed uint64) uintptr {
+	k1 := key * prime2
+	k1 = bits.RotateLeft64(k1, 31)
+	k1 *= prime1
+	h := (seed + prime5 + 8) ^ k1
+	h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return uintptr(h)
+}
+
+// oword hasher, key size -> 16 bytes
+func owordHasher(b [owordSize]byte, seed uint64) uintptr {
+	h := seed + prime5 + 16
+
+	val := uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
 
-	// dword hasher, key size -> 4 bytes
-	dwordHasher = func(key uint32) uintptr {
-		h := prime5 + 4
-		h ^= uint64(key) * prime1
-		h = bits.RotateLeft64(h, 23)*prime2 + prime3
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
+	k1 := val * prime2
+	k1 = bits.RotateLeft64(k1, 31)
+	k1 *= prime1
+
+	h ^= k1
+	h = bits.RotateLeft64(h, 27)*prime1 + prime4
+
+	val = uint64(b[8]) | uint64(b[9])<<8 | uint64(b[10])<<16 | uint64(b[11])<<24 |
+		uint64(b[12])<<32 | uint64(b[13])<<40 | uint64(b[14])<<48 | uint64(b[15])<<56
+
+	k1 = val * prime2
+	k1 = bits.RotateLeft64(k1, 31)
+	k1 *= prime1
+
+	h ^= k1
+	h = bits.RotateLeft64(h, 27)*prime1 + prime4
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+
+	return uintptr(h)
+}
+
+// xxHash algorithm for key of any size for golang string data type
+// Long keys are processed in 32 byte stripes by 4 independent accumulators at roughly 9 GB/s on current amd64 cores,
+// on par with the AES based hash/maphash, see MapHash. There is no SIMD variant as every round multiplies 64 bit
+// lanes, which neither AVX2 nor NEON support, and a scalar assembly loop measured no faster than the compiled one
+// Keys hashed repeatedly can cache their hash by implementing KeyHasher instead
+func stringHasher(key string, seed uint64) uintptr {
+	sh := (*reflect.StringHeader)(unsafe.Pointer(&key))
+	b := unsafe.Slice((*byte)(unsafe.Pointer(sh.Data)), sh.Len)
+	n := sh.Len
+	var h uint64
+
+	if n >= 32 {
+		v1 := seed + prime1 + prime2
+		v2 := seed + prime2
+		v3 := seed
+		v4 := seed - prime1
+		for len(b) >= 32 {
+			v1 = round(v1, u64(b[0:8:len(b)]))
+			v2 = round(v2, u64(b[8:16:len(b)]))
+			v3 = round(v3, u64(b[16:24:len(b)]))
+			v4 = round(v4, u64(b[24:32:len(b)]))
+			b = b[32:len(b):len(b)]
+		}
+		h = rol1(v1) + rol7(v2) + rol12(v3) + rol18(v4)
+		h = mergeRound(h, v1)
+		h = mergeRound(h, v2)
+		h = mergeRound(h, v3)
+		h = mergeRound(h, v4)
+	} else {
+		h = seed + prime5
 	}
 
-	// separate dword hasher for float32 type
-	// required for casting float32 to unsigned integer type without any loss of bits
-	// Example :- casting uint32(1.3) will drop off the 0.3 decimal part but using *(*uint32)(unsafe.Pointer(&key)) will retain all bits (both the integer as well as the decimal part)
-	// this will ensure correctness of the hash
-	float32Hasher = func(key float32) uintptr {
-		h := prime5 + 4
-		h ^= uint64(*(*uint32)(unsafe.Pointer(&key))) * prime1
-		h = bits.RotateLeft64(h, 23)*prime2 + prime3
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
+	h += uint64(n)
+
+	i, end := 0, len(b)
+	for ; i+8 <= end; i += 8 {
+		k1 := round(0, u64(b[i:i+8:len(b)]))
+		h ^= k1
+		h = rol27(h)*prime1 + prime4
+	}
+	if i+4 <= end {
+		h ^= uint64(u32(b[i:i+4:len(b)])) * prime1
+		h = rol23(h)*prime2 + prime3
+		i += 4
+	}
+	for ; i < end; i++ {
+		h ^= uint64(b[i]) * prime5
+		h = rol11(h) * prime1
 	}
 
-	// qword hasher, key size -> 8 bytes
-	qwordHasher = func(key uint64) uintptr {
-		k1 := key * prime2
-		k1 = bits.RotateLeft64(k1, 31)
-		k1 *= prime1
-		h := (prime5 + 8) ^ k1
-		h = bits.RotateLeft64(h, 27)*prime1 + prime4
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+
+	return uintptr(h)
+}
+
+// HashAlgorithm selects the algorithm used by the default hasher of a map
+type HashAlgorithm uint8
+
+const (
+	// XXHash is the default algorithm, specialized for every key size
+	// It is the 64-bit xxHash (XXH64), xxHash3 is not provided as its per-length code paths and secret would dwarf
+	// the rest of the package while wyhash already covers the fast path for short keys
+	XXHash HashAlgorithm = iota
+	// WyHash hashes the raw bytes of the keys with wyhash
+	WyHash
+	// MapHash hashes the raw bytes of the keys with hash/maphash from the standard library
+	// maphash uses its own random seed which cannot be fixed, the seed of the map is ignored
+	MapHash
+)
+
+// String returns the name of the algorithm
+func (a HashAlgorithm) String() string {
+	switch a {
+	case XXHash:
+		return "xxhash"
+	case WyHash:
+		return "wyhash"
+	case MapHash:
+		return "maphash"
 	}
+	return "unknown"
+}
+
+// KeyHasher is implemented by key types which provide their own hash, like UUIDs or composite IDs
+// Maps with such keys call HashKey instead of the default hasher unless SetHasher is called
+// If the key type also has an `Equal(K) bool` method, keys are compared with it instead of ==
+type KeyHasher interface {
+	HashKey() uintptr
+}
+
+var keyHasherType = reflect.TypeOf((*KeyHasher)(nil)).Elem()
+
+// nilKeyHash is the hash of a nil interface key whose HashKey method cannot be called
+// it is non-zero as the zero hash belongs to the list head
+const nilKeyHash uintptr = 1
 
-	// separate qword hasher for float64 type
-	// for reason see definition of float32Hasher on line 127
-	float64Hasher = func(key float64) uintptr {
-		k1 := *(*uint64)(unsafe.Pointer(&key)) * prime2
-		k1 = bits.RotateLeft64(k1, 31)
-		k1 *= prime1
-		h := (prime5 + 8) ^ k1
-		h = bits.RotateLeft64(h, 27)*prime1 + prime4
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
+// keyEqualer is implemented by key types which compare themselves with an Equal method
+type keyEqualer[K any] interface {
+	Equal(K) bool
+}
+
+// setDefaultKeyEqual makes key types implementing both KeyHasher and an Equal(K) bool method compare themselves
+// the Equal method of keys without a HashKey method is ignored as the default hasher might disagree with it
+// The method sets are checked on the type as the zero value of an interface key type holds no method at all
+func (m *Map[K, V]) setDefaultKeyEqual() {
+	typ := reflect.TypeOf((*K)(nil)).Elem()
+	if typ.Implements(keyHasherType) && typ.Implements(reflect.TypeOf((*keyEqualer[K])(nil)).Elem()) {
+		m.keyEqual = func(a, b K) bool {
+			// a nil interface key has no method to call and only equals another nil key
+			if any(a) == nil || any(b) == nil {
+				return any(a) == nil && any(b) == nil
+			}
+			return any(a).(keyEqualer[K]).Equal(b)
+		}
 	}
+}
 
-	// separate qword hasher for complex64 type
-	complex64Hasher = func(key complex64) uintptr {
-		k1 := *(*uint64)(unsafe.Pointer(&key)) * prime2
-		k1 = bits.RotateLeft64(k1, 31)
-		k1 *= prime1
-		h := (prime5 + 8) ^ k1
-		h = bits.RotateLeft64(h, 27)*prime1 + prime4
-		h ^= h >> 33
-		h *= prime2
-		h ^= h >> 29
-		h *= prime3
-		h ^= h >> 32
-		return uintptr(h)
+// randomSeed returns a random hash seed, every map gets its own seed like the golang runtime map
+// so that an attacker cannot precompute keys colliding in all maps (HashDoS)
+func randomSeed() uint64 {
+	// the zero maphash.Hash is seeded randomly upon first use
+	return new(maphash.Hash).Sum64()
+}
+
+// keyBytes returns the memory of a key, the contents of the string for string keys
+func keyBytes[K hashable](key *K, isString bool) []byte {
+	if isString {
+		sh := (*reflect.StringHeader)(unsafe.Pointer(key))
+		return unsafe.Slice((*byte)(unsafe.Pointer(sh.Data)), sh.Len)
 	}
-)
+	return unsafe.Slice((*byte)(unsafe.Pointer(key)), unsafe.Sizeof(*key))
+}
 
 func (m *Map[K, V]) setDefaultHasher() {
-	// default hash functions
-	switch reflect.TypeOf(*new(K)).Kind() {
-	case reflect.String:
-		// use default xxHash algorithm for key of any size for golang string data type
+	var (
+		typ  = reflect.TypeOf((*K)(nil)).Elem()
+		kind = typ.Kind()
+		seed = m.seed
+	)
+	if typ.Implements(keyHasherType) {
 		m.hasher = func(key K) uintptr {
-			sh := (*reflect.StringHeader)(unsafe.Pointer(&key))
-			b := unsafe.Slice((*byte)(unsafe.Pointer(sh.Data)), sh.Len)
-			n := sh.Len
-			var h uint64
-
-			if n >= 32 {
-				v1 := prime1v + prime2
-				v2 := prime2
-				v3 := uint64(0)
-				v4 := -prime1v
-				for len(b) >= 32 {
-					v1 = round(v1, u64(b[0:8:len(b)]))
-					v2 = round(v2, u64(b[8:16:len(b)]))
-					v3 = round(v3, u64(b[16:24:len(b)]))
-					v4 = round(v4, u64(b[24:32:len(b)]))
-					b = b[32:len(b):len(b)]
-				}
-				h = rol1(v1) + rol7(v2) + rol12(v3) + rol18(v4)
-				h = mergeRound(h, v1)
-				h = mergeRound(h, v2)
-				h = mergeRound(h, v3)
-				h = mergeRound(h, v4)
-			} else {
-				h = prime5
+			if any(key) == nil {
+				return nilKeyHash
 			}
+			return any(key).(KeyHasher).HashKey()
+		}
+		return
+	}
+	switch kind {
+	case reflect.Struct, reflect.Array, reflect.Interface:
+		// the raw memory of composite keys may hold pointers to equal contents at distinct addresses
+		m.hasher = comparableHasher[K]()
+		return
+	}
+	switch m.algorithm {
+	case WyHash:
+		isString := kind == reflect.String
+		m.hasher = func(key K) uintptr {
+			return wyHash(keyBytes(&key, isString), seed)
+		}
+		return
+	case MapHash:
+		isString, mapSeed := kind == reflect.String, maphash.MakeSeed()
+		m.hasher = func(key K) uintptr {
+			var h maphash.Hash
+			h.SetSeed(mapSeed)
+			h.Write(keyBytes(&key, isString))
+			return uintptr(h.Sum64())
+		}
+		return
+	}
 
-			h += uint64(n)
-
-			i, end := 0, len(b)
-			for ; i+8 <= end; i += 8 {
-				k1 := round(0, u64(b[i:i+8:len(b)]))
-				h ^= k1
-				h = rol27(h)*prime1 + prime4
-			}
-			if i+4 <= end {
-				h ^= uint64(u32(b[i:i+4:len(b)])) * prime1
-				h = rol23(h)*prime2 + prime3
-				i += 4
-			}
-			for ; i < end; i++ {
-				h ^= uint64(b[i]) * prime5
-				h = rol11(h) * prime1
-			}
-
-			h ^= h >> 33
-			h *= prime2
-			h ^= h >> 29
-			h *= prime3
-			h ^= h >> 32
-
-			return uintptr(h)
+	// default xxHash functions seeded with the seed of the map
+	// keys are reinterpreted as unsigned integers of the same size via unsafe instead of being cast
+	// Example :- casting uint32(1.3) will drop off the 0.3 decimal part but using *(*uint32)(unsafe.Pointer(&key)) will retain all bits (both the integer as well as the decimal part)
+	// this will ensure correctness of the hash for float and complex types
+	switch kind {
+	case reflect.String:
+		m.hasher = func(key K) uintptr {
+			return stringHasher(*(*string)(unsafe.Pointer(&key)), seed)
 		}
-	case reflect.Int, reflect.Uint, reflect.Uintptr, reflect.UnsafePointer:
+	case reflect.Int, reflect.Uint, reflect.Uintptr, reflect.UnsafePointer, reflect.Pointer, reflect.Chan:
 		switch intSizeBytes {
 		case 2:
 			// word hasher
-			m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&wordHasher))
+			m.hasher = func(key K) uintptr {
+				return wordHasher(*(*uint16)(unsafe.Pointer(&key)), seed)
+			}
 		case 4:
 			// dword hasher
-			m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&dwordHasher))
+			m.hasher = func(key K) uintptr {
+				return dwordHasher(*(*uint32)(unsafe.Pointer(&key)), seed)
+			}
 		case 8:
 			// qword hasher
-			m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&qwordHasher))
+			m.hasher = func(key K) uintptr {
+				return qwordHasher(*(*uint64)(unsafe.Pointer(&key)), seed)
+			}
 		}
-	case reflect.Int8, reflect.Uint8:
+	case reflect.Int8, reflect.Uint8, reflect.Bool:
 		// byte hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&byteHasher))
+		m.hasher = func(key K) uintptr {
+			return byteHasher(*(*uint8)(unsafe.Pointer(&key)), seed)
+		}
 	case reflect.Int16, reflect.Uint16:
 		// word hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&wordHasher))
-	case reflect.Int32, reflect.Uint32:
+		m.hasher = func(key K) uintptr {
+			return wordHasher(*(*uint16)(unsafe.Pointer(&key)), seed)
+		}
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
 		// dword hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&dwordHasher))
-	case reflect.Float32:
-		// custom float32 dword hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&float32Hasher))
-	case reflect.Int64, reflect.Uint64:
+		m.hasher = func(key K) uintptr {
+			return dwordHasher(*(*uint32)(unsafe.Pointer(&key)), seed)
+		}
+	case reflect.Int64, reflect.Uint64, reflect.Float64, reflect.Complex64:
 		// qword hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&qwordHasher))
-	case reflect.Float64:
-		// custom float64 qword hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&float64Hasher))
-	case reflect.Complex64:
-		// custom complex64 qword hasher
-		m.hasher = *(*func(K) uintptr)(unsafe.Pointer(&complex64Hasher))
+		m.hasher = func(key K) uintptr {
+			return qwordHasher(*(*uint64)(unsafe.Pointer(&key)), seed)
+		}
 	case reflect.Complex128:
-		// oword hasher, key size -> 16 bytes
+		// oword hasher
 		m.hasher = func(key K) uintptr {
-			b := *(*[owordSize]byte)(unsafe.Pointer(&key))
-			h := prime5 + 16
-
-			val := uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-				uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
-
-			k1 := val * prime2
-			k1 = bits.RotateLeft64(k1, 31)
-			k1 *= prime1
-
-			h ^= k1
-			h = bits.RotateLeft64(h, 27)*prime1 + prime4
-
-			val = uint64(b[8]) | uint64(b[9])<<8 | uint64(b[10])<<16 | uint64(b[11])<<24 |
-				uint64(b[12])<<32 | uint64(b[13])<<40 | uint64(b[14])<<48 | uint64(b[15])<<56
-
-			k1 = val * prime2
-			k1 = bits.RotateLeft64(k1, 31)
-			k1 *= prime1
-
-			h ^= k1
-			h = bits.RotateLeft64(h, 27)*prime1 + prime4
-
-			h ^= h >> 33
-			h *= prime2
-			h ^= h >> 29
-			h *= prime3
-			h ^= h >> 32
-
-			return uintptr(h)
+			return owordHasher(*(*[owordSize]byte)(unsafe.Pointer(&key)), seed)
 		}
 	}
 }
@@ -0,0 +1,14 @@
+//go:build go1.24
+
+package haxmap
+
+import "hash/maphash"
+
+// comparableHasher returns a hasher for composite comparable keys which hashes them like the golang runtime map
+// maphash uses its own random seed which cannot be fixed, the seed of the map is ignored
+func comparableHasher[K hashable]() func(K) uintptr {
+	seed := maphash.MakeSeed()
+	return func(key K) uintptr {
+		return uintptr(maphash.Comparable(seed, key))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !go1.24
+
+package haxmap
+
+import "fmt"
+
+// comparableHasher returns a hasher for composite comparable keys, hashing them requires maphash.Comparable
+// from go1.24 hence the returned hasher panics until a custom one is set with SetHasher
+func comparableHasher[K hashable]() func(K) uintptr {
+	return func(key K) uintptr {
+		panic(fmt.Sprintf("haxmap: no default hasher for key type %T before go1.24, use SetHasher", key))
+	}
+}
@@ -0,0 +1,38 @@
+//go:build go1.24
+
+package haxmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestComparableKeys(t *testing.T) {
+	type endpoint struct {
+		Host string
+		Port int
+	}
+	m := New[endpoint, int]()
+	for i := 0; i < 100; i++ {
+		// build the host dynamically so that equal keys hold strings at distinct addresses
+		m.Set(endpoint{Host: "host" + strconv.Itoa(i%10), Port: i}, i)
+	}
+	for i := 0; i < 100; i++ {
+		if val, ok := m.Get(endpoint{Host: "host" + strconv.Itoa(i%10), Port: i}); !ok || val != i {
+			t.Fatalf("struct key %d not found", i)
+		}
+	}
+
+	a := New[[2]string, bool]()
+	a.Set([2]string{"a", "b"}, true)
+	if _, ok := a.Get([2]string{"a", "b"}); !ok {
+		t.Error("array key not found")
+	}
+
+	i := New[any, int]()
+	i.Set("key", 1)
+	i.Set(1, 2)
+	if val, ok := i.Get(1); !ok || val != 2 || i.Len() != 2 {
+		t.Error("interface keys not supported")
+	}
+}
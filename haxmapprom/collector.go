@@ -0,0 +1,123 @@
+// Package haxmapprom exports the statistics of haxmaps and of caches built on top of them as Prometheus metrics
+package haxmapprom
+
+import (
+	"sync"
+
+	"github.com/alphadose/haxmap"
+	"github.com/alphadose/haxmap/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// Map is implemented by every *haxmap.Map whatever its key and value types
+	Map interface {
+		Len() uintptr
+		Stats() haxmap.Stats
+	}
+
+	// Cache is implemented by every cache of the cache package whatever its key and value types
+	Cache interface {
+		Len() uintptr
+		Stats() cache.Stats
+	}
+
+	// Collector implements the prometheus.Collector interface over named maps and caches
+	// Every metric is labeled with the name the map or cache was added with, values are read upon collection
+	Collector struct {
+		mu     sync.RWMutex
+		maps   map[string]Map
+		caches map[string]Cache
+
+		entries, fillRate, resizes, lookups, probeSteps, maxProbe, retries *prometheus.Desc
+		hits, misses, hitRatio, loads, loadErrors, evictions, expirations  *prometheus.Desc
+	}
+)
+
+// NewCollector returns a new collector whose metrics are prefixed with the namespace, if not empty
+func NewCollector(namespace string) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "haxmap", name), help, []string{"name"}, nil)
+	}
+	return &Collector{
+		maps:        make(map[string]Map),
+		caches:      make(map[string]Cache),
+		entries:     desc("entries", "Number of entries."),
+		fillRate:    desc("fill_rate", "Fill rate of the index as a ratio."),
+		resizes:     desc("resizes_total", "Number of times the index was grown or shrunk."),
+		lookups:     desc("lookups_total", "Number of lookups by key."),
+		probeSteps:  desc("probe_steps_total", "Number of list elements visited by lookups."),
+		maxProbe:    desc("max_probe_length", "Largest number of list elements visited by a single lookup."),
+		retries:     desc("insert_retries_total", "Number of insertions restarted after losing a race with a concurrent write."),
+		hits:        desc("cache_hits_total", "Number of cache lookups which found the key."),
+		misses:      desc("cache_misses_total", "Number of cache lookups which did not find the key."),
+		hitRatio:    desc("cache_hit_ratio", "Ratio of cache lookups which found the key."),
+		loads:       desc("cache_loads_total", "Number of calls of the cache loader."),
+		loadErrors:  desc("cache_load_errors_total", "Number of calls of the cache loader which returned an error."),
+		evictions:   desc("cache_evictions_total", "Number of entries evicted to make room for new ones."),
+		expirations: desc("cache_expirations_total", "Number of entries removed once expired."),
+	}
+}
+
+// AddMap adds a map whose metrics are labeled with the name, replacing any map or cache previously added with that name
+// The lookup, probe, resize and retry counters of the map are only maintained once enabled, see (*haxmap.Map).EnableStats
+func (c *Collector) AddMap(name string, m Map) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.caches, name)
+	c.maps[name] = m
+}
+
+// AddCache adds a cache whose metrics are labeled with the name, replacing any map or cache previously added with that
+// name
+func (c *Collector) AddCache(name string, cache Cache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.maps, name)
+	c.caches[name] = cache
+}
+
+// Remove removes the map or cache added with the name, if any
+func (c *Collector) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.maps, name)
+	delete(c.caches, name)
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.entries, c.fillRate, c.resizes, c.lookups, c.probeSteps, c.maxProbe, c.retries,
+		c.hits, c.misses, c.hitRatio, c.loads, c.loadErrors, c.evictions, c.expirations,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for name, m := range c.maps {
+		stats := m.Stats()
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(m.Len()), name)
+		ch <- prometheus.MustNewConstMetric(c.fillRate, prometheus.GaugeValue, float64(stats.FillRate)/100, name)
+		ch <- prometheus.MustNewConstMetric(c.resizes, prometheus.CounterValue, float64(stats.Resizes), name)
+		ch <- prometheus.MustNewConstMetric(c.lookups, prometheus.CounterValue, float64(stats.Lookups), name)
+		ch <- prometheus.MustNewConstMetric(c.probeSteps, prometheus.CounterValue, float64(stats.ProbeSteps), name)
+		ch <- prometheus.MustNewConstMetric(c.maxProbe, prometheus.GaugeValue, float64(stats.MaxProbeLength), name)
+		ch <- prometheus.MustNewConstMetric(c.retries, prometheus.CounterValue, float64(stats.InsertRetries), name)
+	}
+	for name, cache := range c.caches {
+		stats := cache.Stats()
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(cache.Len()), name)
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits), name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses), name)
+		ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, stats.HitRatio(), name)
+		ch <- prometheus.MustNewConstMetric(c.loads, prometheus.CounterValue, float64(stats.Loads), name)
+		ch <- prometheus.MustNewConstMetric(c.loadErrors, prometheus.CounterValue, float64(stats.LoadErrors), name)
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations), name)
+	}
+}
@@ -0,0 +1,70 @@
+package haxmapprom
+
+import (
+	"testing"
+
+	"github.com/alphadose/haxmap"
+	"github.com/alphadose/haxmap/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	m := haxmap.NewWithOptions[int, string](haxmap.WithStats())
+	for i := 0; i < 100; i++ {
+		m.Set(i, "value")
+	}
+	m.Get(1)
+	c := cache.NewLRU[string, int](10)
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("b")
+
+	collector := NewCollector("app")
+	collector.AddMap("users", m)
+	collector.AddCache("sessions", c)
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	values := func() map[string]float64 {
+		t.Helper()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				key := family.GetName() + "/" + metric.GetLabel()[0].GetValue()
+				if metric.GetCounter() != nil {
+					values[key] = metric.GetCounter().GetValue()
+				} else {
+					values[key] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+		return values
+	}
+	got := values()
+	for key, expected := range map[string]float64{
+		"app_haxmap_entries/users":             100,
+		"app_haxmap_fill_rate/users":           float64(m.Fillrate()) / 100,
+		"app_haxmap_lookups_total/users":       float64(m.Stats().Lookups),
+		"app_haxmap_entries/sessions":          1,
+		"app_haxmap_cache_hits_total/sessions": 1,
+		"app_haxmap_cache_hit_ratio/sessions":  0.5,
+	} {
+		if value, ok := got[key]; !ok || value != expected {
+			t.Errorf("metric %s should be %v, got %v %v", key, expected, value, ok)
+		}
+	}
+	if got["app_haxmap_resizes_total/users"] == 0 {
+		t.Error("resizes should be reported")
+	}
+
+	collector.Remove("sessions")
+	if _, ok := values()["app_haxmap_entries/sessions"]; ok {
+		t.Error("removed cache should not be reported")
+	}
+}
@@ -0,0 +1,49 @@
+package haxmap
+
+// OnInsert sets a hook called after every insertion of an absent key with the inserted value, nil disables it
+// Hooks are called synchronously by the goroutine which modified the map once the modification is visible, hence
+// concurrent modifications of the same key may be reported out of order and hooks should be fast and must not block
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) OnInsert(hook func(key K, value V)) {
+	m.initialize()
+	m.onInsert = hook
+}
+
+// OnUpdate sets a hook called after every replacement of the value of a present key with the old and new values,
+// nil disables it, see OnInsert
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) OnUpdate(hook func(key K, oldValue, newValue V)) {
+	m.initialize()
+	m.onUpdate = hook
+}
+
+// OnDelete sets a hook called after every removal of an entry with its last value, nil disables it, see OnInsert
+// Unlike OnEvict, every removal is reported whatever its cause: deletions by Del, GetAndDel, Compute, Drain and the
+// like as well as expiry, capacity eviction and Clear, so that data derived from the entries can be kept in sync
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) OnDelete(hook func(key K, oldValue V)) {
+	m.initialize()
+	m.onDelete = hook
+}
+
+// notify calls the insertion hook if old is nil or else the update hook for a write of the value to the element,
+// then publishes the write to the watchers of the key if any
+func (m *Map[K, V]) notify(elem *element[K, V], old, value *V) {
+	if m.instrument != nil {
+		if old == nil {
+			m.instrument.Count(OpInsert)
+		} else {
+			m.instrument.Count(OpUpdate)
+		}
+	}
+	if old == nil {
+		if m.onInsert != nil {
+			m.onInsert(elem.key, *value)
+		}
+	} else if m.onUpdate != nil {
+		m.onUpdate(elem.key, *old, *value)
+	}
+	if w := m.watchers.Load(); w != nil {
+		w.publish(elem, m.tomb)
+	}
+}
@@ -0,0 +1,60 @@
+package haxmap
+
+import "reflect"
+
+// inlineElement allocates an element along with its initial value so that inserting a new key costs a single
+// allocation and the value shares the memory of its element instead of living in a separate box
+// The element is kept alive by pointers to its value as well, which the garbage collector follows to the whole object
+// Replaced values stay in the element until it is reclaimed hence only values without pointers are stored inline,
+// otherwise a stale value would keep the memory it references alive
+type inlineElement[K hashable, V any] struct {
+	element[K, V]
+	value V
+}
+
+// newElement allocates an element holding the value, inline if the map stores values inline, and returns the element
+// along with the pointer to its value, which is ptr if not nil as values given by pointer are never copied
+func newElement[K hashable, V any](hash uintptr, key K, value V, ptr *V, inline bool) (*element[K, V], *V) {
+	var (
+		elem   *element[K, V]
+		stored *V
+	)
+	if ptr != nil {
+		elem, stored = &element[K, V]{keyHash: hash, key: key}, ptr
+	} else if inline {
+		alloc := &inlineElement[K, V]{element: element[K, V]{keyHash: hash, key: key}, value: value}
+		elem, stored = &alloc.element, &alloc.value
+	} else {
+		elem, stored = &element[K, V]{keyHash: hash, key: key}, box(value)
+	}
+	elem.value.Store(stored)
+	return elem, stored
+}
+
+// box allocates a copy of the value, taking the address of a parameter instead would move it to the heap upon every
+// call including those which store it inline
+func box[V any](value V) *V {
+	boxed := new(V)
+	*boxed = value
+	return boxed
+}
+
+// pointerFree reports whether values of the type hold no pointers, which makes them eligible for inline storage
+func pointerFree(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return typ.Len() == 0 || pointerFree(typ.Elem())
+	case reflect.Struct:
+		for idx := 0; idx < typ.NumField(); idx++ {
+			if !pointerFree(typ.Field(idx).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package haxmap
+
+// Operation identifies an operation reported to an Instrumentation
+type Operation uint8
+
+const (
+	// OpGetHit denotes a Get which found the key, GetE, GetBytes, GetOrLoad and GetRefresh count as a Get
+	OpGetHit Operation = iota
+	// OpGetMiss denotes a Get which did not find the key, before any loader call
+	OpGetMiss
+	// OpInsert denotes a write which inserted an absent key
+	OpInsert
+	// OpUpdate denotes a write which replaced the value of a present key
+	OpUpdate
+	// OpDelete denotes the removal of an entry whatever its cause, see OnDelete
+	OpDelete
+)
+
+// String returns the name of the operation, suitable as a metric attribute
+func (op Operation) String() string {
+	switch op {
+	case OpGetHit:
+		return "get_hit"
+	case OpGetMiss:
+		return "get_miss"
+	case OpInsert:
+		return "insert"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Instrumentation receives telemetry events of a map so that it can be wired to a tracing or metrics pipeline, like
+// OpenTelemetry, without this package depending on it. Its methods are called synchronously by the goroutines
+// operating on the map hence they must be safe for concurrent use, fast and non-blocking
+type Instrumentation interface {
+	// Count is called once per operation
+	Count(op Operation)
+	// Resize is called when the index starts being resized from oldSize to newSize slots, the returned function is
+	// called once the resized index is in use, like ending a span, unless it is nil
+	Resize(oldSize, newSize uintptr) (end func())
+}
+
+// SetInstrumentation sets the instrumentation receiving the telemetry events of the map, nil disables it
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) SetInstrumentation(instrumentation Instrumentation) {
+	m.initialize()
+	m.instrument = instrumentation
+}
+
+// count reports an operation to the instrumentation if any
+func (m *Map[K, V]) count(op Operation) {
+	if m.instrument != nil {
+		m.instrument.Count(op)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build go1.20
+
+package haxmap
+
+import "testing"
+
+type (
+	// accountKey is an interface key type implementing KeyHasher along with an Equal method
+	accountKey interface {
+		HashKey() uintptr
+		Equal(accountKey) bool
+	}
+
+	// accountID is an account number whose hundreds are a version ignored by comparisons
+	accountID int
+)
+
+func (a accountID) HashKey() uintptr {
+	return uintptr(a%100) + 1
+}
+
+func (a accountID) Equal(other accountKey) bool {
+	b, ok := other.(accountID)
+	return ok && a%100 == b%100
+}
+
+func TestInterfaceKeyEqual(t *testing.T) {
+	// the zero value of an interface key type holds no method
+	m := New[accountKey, int]()
+	m.Set(accountID(105), 1)
+	if val, ok := m.Get(accountID(5)); m.keyEqual == nil || !ok || val != 1 {
+		t.Error("Equal method of the interface key type should be used")
+	}
+	if m.Set(accountID(6), 2); m.Len() != 2 {
+		t.Errorf("distinct keys should be distinct entries, got %d entries", m.Len())
+	}
+}
+
+func TestInterfaceKeyNil(t *testing.T) {
+	m := New[accountKey, int]()
+	m.Set(nil, 1)
+	m.Set(accountID(0), 2)
+	if val, ok := m.Get(nil); !ok || val != 1 {
+		t.Errorf("nil key should be stored, got %d %v", val, ok)
+	}
+	if val, ok := m.Get(accountID(0)); !ok || val != 2 {
+		t.Errorf("nil key should not equal a non-nil key, got %d %v", val, ok)
+	}
+	if m.Del(nil); m.Len() != 1 {
+		t.Errorf("nil key should be deleted, got %d entries", m.Len())
+	}
+}
@@ -0,0 +1,35 @@
+//go:build go1.23
+
+package haxmap
+
+import "iter"
+
+// All returns an iterator over the key-value pairs of the map to be used with range-over-func
+// The list is traversed lazily hence entries set or deleted during the iteration might or might not be yielded
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	m.initialize()
+	return func(yield func(K, V) bool) {
+		for item := m.skipExpired(m.listHead.next()); item != nil && yield(item.key, *m.valueOf(item)); item = m.skipExpired(item.next()) {
+		}
+	}
+}
+
+// KeysSeq returns an iterator over the keys of the map to be used with range-over-func
+// It is the lazy counterpart of Keys() which collects all keys into a slice
+func (m *Map[K, V]) KeysSeq() iter.Seq[K] {
+	m.initialize()
+	return func(yield func(K) bool) {
+		for item := m.skipExpired(m.listHead.next()); item != nil && yield(item.key); item = m.skipExpired(item.next()) {
+		}
+	}
+}
+
+// ValuesSeq returns an iterator over the values of the map to be used with range-over-func
+// It is the lazy counterpart of Values() which collects all values into a slice
+func (m *Map[K, V]) ValuesSeq() iter.Seq[V] {
+	m.initialize()
+	return func(yield func(V) bool) {
+		for item := m.skipExpired(m.listHead.next()); item != nil && yield(*m.valueOf(item)); item = m.skipExpired(item.next()) {
+		}
+	}
+}
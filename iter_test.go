@@ -0,0 +1,44 @@
+//go:build go1.23
+
+package haxmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRangeIterators(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, strconv.Itoa(i))
+	}
+
+	count := 0
+	for key, value := range m.All() {
+		if value != strconv.Itoa(key) {
+			t.Errorf("item is not as expected for key %d: %q", key, value)
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("expected 100 pairs, got %d", count)
+	}
+
+	keys := 0
+	for range m.KeysSeq() {
+		if keys++; keys == 10 {
+			break
+		}
+	}
+	if keys != 10 {
+		t.Errorf("iteration should stop on break, got %d keys", keys)
+	}
+
+	values := make(map[string]bool)
+	for value := range m.ValuesSeq() {
+		values[value] = true
+	}
+	if len(values) != 100 {
+		t.Errorf("expected 100 values, got %d", len(values))
+	}
+}
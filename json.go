@@ -0,0 +1,139 @@
+package haxmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// MarshalJSON implements the json.Marshaler interface.
+// The map is encoded as a JSON object like a built-in map by encoding/json: keys of string kind are used as is,
+// keys implementing encoding.TextMarshaler are marshaled and integer keys are formatted, other keys are unsupported
+// Keys are sorted so that the output is deterministic, the entries are collected in a single pass over the list
+func (m *Map[K, V]) MarshalJSON() ([]byte, error) {
+	m.initialize()
+	type entry struct {
+		key   string
+		value V
+	}
+	entries := make([]entry, 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		key, err := encodeKey(item.key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, value: *m.valueOf(item)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx := range entries {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(entries[idx].key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(entries[idx].value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// Keys are decoded following the rules of MarshalJSON except that, like encoding/json, keys implementing
+// encoding.TextUnmarshaler are unmarshaled even if they are of string kind, entries already present are kept unless
+// overwritten
+// All pairs are decoded first and inserted with SetMany, which grows the map once to fit them
+func (m *Map[K, V]) UnmarshalJSON(data []byte) error {
+	m.initialize()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil { // null leaves the map as is
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("haxmap: cannot unmarshal %v into a map", tok)
+	}
+	var pairs []Pair[K, V]
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		var pair Pair[K, V]
+		if pair.Key, err = decodeKey[K](tok.(string)); err != nil {
+			return err
+		}
+		if err = dec.Decode(&pair.Value); err != nil {
+			return err
+		}
+		pairs = append(pairs, pair)
+	}
+	if _, err = dec.Token(); err != nil { // closing brace
+		return err
+	}
+	m.SetMany(pairs...)
+	return nil
+}
+
+// encodeKey formats a key as the name of a JSON object member
+func encodeKey[K hashable](key K) (string, error) {
+	rv := reflect.ValueOf(&key).Elem()
+	if rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	if marshaler, ok := any(key).(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		return string(text), err
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("haxmap: unsupported JSON key type %T", key)
+}
+
+// decodeKey parses the name of a JSON object member into a key
+func decodeKey[K hashable](name string) (key K, err error) {
+	if unmarshaler, ok := any(&key).(encoding.TextUnmarshaler); ok {
+		err = unmarshaler.UnmarshalText([]byte(name))
+		return
+	}
+	rv := reflect.ValueOf(&key).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(name)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(name, 10, rv.Type().Bits()); err == nil {
+			rv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		if n, err = strconv.ParseUint(name, 10, rv.Type().Bits()); err == nil {
+			rv.SetUint(n)
+		}
+	default:
+		err = fmt.Errorf("haxmap: unsupported JSON key type %T", key)
+	}
+	return
+}
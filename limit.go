@@ -0,0 +1,125 @@
+package haxmap
+
+import "errors"
+
+// ErrMapFull is returned by TrySet when a bounded map is full and no entry could be evicted
+var ErrMapFull = errors.New("haxmap: map is full")
+
+// Evictor picks the entry to evict when an insertion into a full bounded map requires room
+// It returns false if no entry should be evicted in which case the insertion is rejected
+type Evictor[K hashable, V any] func(m *Map[K, V]) (victim K, ok bool)
+
+// EvictFirst is an Evictor which evicts the first entry of the list
+// The list is sorted by hash hence this is an arbitrary yet cheap choice of victim
+func EvictFirst[K hashable, V any](m *Map[K, V]) (victim K, ok bool) {
+	m.initialize()
+	if item := m.listHead.next(); item != nil {
+		return item.key, true
+	}
+	return
+}
+
+// SetMaxEntries bounds the number of entries in the map, a limit of 0 removes the bound which is the default
+// Once the map is full, insertions of new keys either evict entries picked by the evictor
+// or, if the evictor is nil, are rejected while updates of existing keys are always applied
+// Rejected insertions are silently dropped by Set, SetMany and Swap, SetIfAbsent returns false,
+// GetOrSet cannot tell them apart from a stored value nor Compute from a deletion, whereas TrySet, TryGetOrSet and
+// TryCompute return ErrMapFull
+func (m *Map[K, V]) SetMaxEntries(limit uintptr, evictor Evictor[K, V]) {
+	m.initialize()
+	m.maxEntries, m.evictor = limit, evictor
+}
+
+// TrySet is like Set but returns ErrMapFull if the key is absent and the bounded map is full
+func (m *Map[K, V]) TrySet(key K, value V) error {
+	m.initialize()
+	return m.trySet(key, value, nil, expiration{})
+}
+
+// trySet inserts or updates the entry with the given expiration, see TrySet and SetWithTTL
+func (m *Map[K, V]) trySet(key K, value V, ptr *V, exp expiration) error {
+	if m.maxEntries == 0 {
+		m.set(key, value, ptr, exp)
+		return nil
+	}
+	var (
+		h        = m.hasher(key)
+		valPtr   *V
+		alloc    *element[K, V]
+		created  = false
+		old      *V
+		reserved = false
+		data     = m.metadata.Load()
+		existing = data.indexElement(h)
+	)
+	if !m.has(h, key) {
+		if !m.reserve() {
+			return ErrMapFull
+		}
+		reserved = true
+	}
+	if existing == nil || existing.keyHash > h {
+		existing = m.listHead
+	}
+	if alloc, created, old, valPtr = existing.inject(h, key, value, ptr, exp, m.keyEqual, m.inline, m.tomb); alloc == nil {
+		for existing = m.listHead; alloc == nil; alloc, created, old, valPtr = existing.inject(h, key, value, ptr, exp, m.keyEqual, m.inline, m.tomb) {
+			m.stats.retried()
+		}
+	}
+	m.settle(created, reserved)
+	m.changed(alloc)
+	m.notify(alloc, old, valPtr)
+
+	count := data.addItemToIndex(alloc)
+	if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		m.grow(0) // grow by the growth factor
+	}
+	return nil
+}
+
+// reserve accounts for a new entry in a bounded map ahead of its insertion, evicting entries if the map is full
+// it returns false if the map is full and no entry could be evicted
+func (m *Map[K, V]) reserve() bool {
+	for {
+		if count := m.numItems.Load(); count < m.maxEntries {
+			if m.numItems.CompareAndSwap(count, count+1) {
+				return true
+			}
+			continue
+		}
+		if m.evictor == nil {
+			return false
+		}
+		victim, ok := m.evictor(m)
+		if !ok {
+			return false
+		}
+		// a concurrent caller might have evicted the same victim, keep trying only if that made room
+		value, evicted := m.GetAndDel(victim)
+		if evicted && m.onEvict != nil {
+			m.onEvict(victim, value, ReasonEvicted)
+		}
+		if !evicted && m.numItems.Load() >= m.maxEntries {
+			return false
+		}
+	}
+}
+
+// settle accounts for the outcome of an insertion, reserved denoting whether room was reserved beforehand
+func (m *Map[K, V]) settle(created, reserved bool) {
+	if created && !reserved {
+		m.numItems.Add(1) // the key was deleted concurrently after being found present
+	} else if !created && reserved {
+		m.numItems.Add(^uintptr(0)) // the key was inserted concurrently, release the reservation
+	}
+}
+
+// has checks whether the key is present in the map without consulting the loader
+func (m *Map[K, V]) has(h uintptr, key K) bool {
+	for elem := m.seek(m.metadata.Load(), h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+		if m.equal(elem.key, key) {
+			return !elem.isDeleted() && m.live(elem)
+		}
+	}
+	return false
+}
@@ -8,6 +8,9 @@ const (
 	deleted
 )
 
+// expiry of an element claimed for removal by the caller who found it expired
+const expiryClaimed int64 = -1
+
 // Below implementation is a lock-free linked list based on https://www.cl.cam.ac.uk/research/srg/netos/papers/2001-caslists.pdf by Timothy L. Harris
 // Performance improvements suggested in https://arxiv.org/pdf/2010.15755.pdf were also added
 
@@ -19,24 +22,42 @@ func newListHead[K hashable, V any]() *element[K, V] {
 	return e
 }
 
+// expiration of an entry, the zero value never expires
+type expiration struct {
+	at  int64 // unix time in nanoseconds
+	ttl int64 // lifetime in nanoseconds
+}
+
 // a single node in the list
+// nodes are never recycled as concurrent readers might still traverse a deleted node, the garbage collector reclaims
+// them once unreachable
 type element[K hashable, V any] struct {
+	// unix time in nanoseconds at which the entry expires, 0 if it never does
+	// first field to be 64-bit aligned on 32-bit platforms
+	expiry  atomicInt64
+	ttl     atomicInt64  // lifetime in nanoseconds the expiry is extended by upon access when sliding
+	version atomicUint64 // sequence number of the last write if changes are tracked, see EnableChangeTracking
 	keyHash uintptr
 	key     K
 	// The next element in the list. If this pointer has the marked flag set it means THIS element, not the next one, is deleted.
 	nextPtr atomicPointer[element[K, V]]
 	value   atomicPointer[V]
 	deleted uint32
+	marker  bool // whether this is the marker freezing the successor of a deleted element, see mark
 }
 
 // next returns the next element
 // this also deletes all marked elements while traversing the list
 func (self *element[K, V]) next() *element[K, V] {
 	for nextElement := self.nextPtr.Load(); nextElement != nil; {
+		if nextElement.marker { // this element is deleted and its successor frozen, move on without unlinking
+			return nextElement.next()
+		}
 		// if our next element is itself deleted (by the same criteria) then we will just replace
-		// it with its next() (which should be the first node behind it that isn't itself deleted) and then check again
+		// it with its successor behind the marker and then check again
 		if nextElement.isDeleted() {
-			self.nextPtr.CompareAndSwap(nextElement, nextElement.next()) // actual deletion happens here after nodes are marked deleted lazily
+			marker := nextElement.mark()
+			self.nextPtr.CompareAndSwap(nextElement, marker.nextPtr.Load()) // actual deletion happens here after nodes are marked deleted lazily
 			nextElement = self.nextPtr.Load()
 		} else {
 			return nextElement
@@ -45,7 +66,27 @@ func (self *element[K, V]) next() *element[K, V] {
 	return nil
 }
 
+// mark links a marker after a deleted element unless already done and returns it
+// Once marked the successor of the element never changes, insertions after it fail and unlinking it replaces it
+// with the successor behind its marker, which hence cannot lose an element inserted concurrently after it (Harris)
+// The marker is a deleted copy of the element so that lookups loading next pointers directly skip it like the
+// element itself
+func (self *element[K, V]) mark() *element[K, V] {
+	for {
+		next := self.nextPtr.Load()
+		if next != nil && next.marker {
+			return next
+		}
+		marker := &element[K, V]{keyHash: self.keyHash, key: self.key, deleted: deleted, marker: true}
+		marker.nextPtr.Store(next)
+		if self.nextPtr.CompareAndSwap(next, marker) {
+			return marker
+		}
+	}
+}
+
 // addBefore inserts an element before the specified element
+// it fails if this element is marked as deleted as its successor must not change anymore, see mark
 func (self *element[K, V]) addBefore(allocatedElement, before *element[K, V]) bool {
 	if self.next() != before {
 		return false
@@ -54,14 +95,47 @@ func (self *element[K, V]) addBefore(allocatedElement, before *element[K, V]) bo
 	return self.nextPtr.CompareAndSwap(before, allocatedElement)
 }
 
-// inject updates an existing value in the list if present or adds a new entry
-func (self *element[K, V]) inject(c uintptr, key K, value *V) (*element[K, V], bool) {
+// inject updates an existing value in the list if present or adds a new entry, returning the replaced value if any
+// along with the pointer to the stored value, a new entry stores its value inline if requested, see inlineElement
+// ptr is stored as is instead of a copy of value if not nil, see SetPtr
+// the expiration is stored ahead of the value so that readers of the new value observe its expiry
+// an existing element claimed for deletion, whose value is the tombstone, is not updated, see store
+func (self *element[K, V]) inject(c uintptr, key K, value V, ptr *V, exp expiration, eq func(a, b K) bool, inline bool, tomb *V) (*element[K, V], bool, *V, *V) {
+	left, curr, right := self.search(c, key, eq)
+	if curr != nil {
+		curr.ttl.Store(exp.ttl)
+		if !curr.refresh(exp.at) {
+			return nil, false, nil, nil // claimed for removal as expired, retry once it is unlinked
+		}
+		stored := ptr
+		if stored == nil {
+			stored = box(value)
+		}
+		old := curr.store(stored, tomb)
+		if old == nil {
+			return nil, false, nil, nil // claimed for deletion, retry once it is unlinked
+		}
+		return curr, false, old, stored
+	}
+	if left != nil {
+		alloc, stored := newElement(c, key, value, ptr, inline)
+		alloc.expiry.Store(exp.at)
+		alloc.ttl.Store(exp.ttl)
+		if left.addBefore(alloc, right) {
+			return alloc, true, nil, stored
+		}
+	}
+	return nil, false, nil, nil
+}
+
+// insert adds a new entry to the list only if the key is absent
+// the existing element is returned as is without updating its value if the key is present
+func (self *element[K, V]) insert(c uintptr, key K, value *V, eq func(a, b K) bool) (*element[K, V], bool) {
 	var (
 		alloc             *element[K, V]
-		left, curr, right = self.search(c, key)
+		left, curr, right = self.search(c, key, eq)
 	)
 	if curr != nil {
-		curr.value.Store(value)
 		return curr, false
 	}
 	if left != nil {
@@ -75,7 +149,8 @@ func (self *element[K, V]) inject(c uintptr, key K, value *V) (*element[K, V], b
 }
 
 // search for an element in the list and return left_element, searched_element and right_element respectively
-func (self *element[K, V]) search(c uintptr, key K) (*element[K, V], *element[K, V], *element[K, V]) {
+// keys are compared with eq or with == if eq is nil
+func (self *element[K, V]) search(c uintptr, key K, eq func(a, b K) bool) (*element[K, V], *element[K, V], *element[K, V]) {
 	var (
 		left, right *element[K, V]
 		curr        = self
@@ -89,7 +164,7 @@ func (self *element[K, V]) search(c uintptr, key K) (*element[K, V], *element[K,
 			right = curr
 			curr = nil
 			return left, curr, right
-		} else if c == curr.keyHash && key == curr.key {
+		} else if c == curr.keyHash && keysEqual(eq, key, curr.key) {
 			return left, curr, right
 		}
 		left = curr
@@ -98,11 +173,82 @@ func (self *element[K, V]) search(c uintptr, key K) (*element[K, V], *element[K,
 	}
 }
 
+// keysEqual compares keys with eq or with == if eq is nil
+func keysEqual[K hashable](eq func(a, b K) bool, a, b K) bool {
+	if eq == nil {
+		return a == b
+	}
+	return eq(a, b)
+}
+
 // remove marks a node for deletion
 // the node will be removed in the next iteration via `element.next()`
 // CAS ensures each node can be marked for deletion exactly once
 func (self *element[K, V]) remove() bool {
-	return atomic.CompareAndSwapUint32(&self.deleted, notDeleted, deleted)
+	if !atomic.CompareAndSwapUint32(&self.deleted, notDeleted, deleted) {
+		return false
+	}
+	self.mark()
+	return true
+}
+
+// store replaces the value of the element and returns the replaced one, unless the element was claimed for deletion
+// by swapping its value for the tombstone, see Map.removeIf, or got deleted meanwhile, in which case it returns nil
+// so that the caller retries on a new element instead of writing a value which would be deleted along with this one
+func (self *element[K, V]) store(value, tomb *V) *V {
+	for {
+		old := self.value.Load()
+		if old == tomb {
+			return nil
+		}
+		if self.value.CompareAndSwap(old, value) {
+			if self.isDeleted() {
+				return nil
+			}
+			return old
+		}
+	}
+}
+
+// expired reports whether the entry has an expiry which is due at the given unix time in nanoseconds
+func (self *element[K, V]) expired(now int64) bool {
+	expiry := self.expiry.Load()
+	return expiry != 0 && now >= expiry
+}
+
+// claimExpired claims the element for removal if its expiry is due at the given time
+// the claim fails if the expiry was refreshed concurrently or the element was claimed by another caller
+func (self *element[K, V]) claimExpired(now int64) bool {
+	expiry := self.expiry.Load()
+	return expiry > 0 && now >= expiry && self.expiry.CompareAndSwap(expiry, expiryClaimed)
+}
+
+// touch extends the expiry of the element to now plus its ttl unless it has no expiry or expired already
+// the expiry is only stored once it moves by at least 1/64 of the ttl to spare hot keys a write upon every access
+func (self *element[K, V]) touch(now int64) {
+	ttl := self.ttl.Load()
+	for {
+		expiry := self.expiry.Load()
+		if expiry <= 0 || now >= expiry || now+ttl-expiry < ttl>>6 {
+			return
+		}
+		if self.expiry.CompareAndSwap(expiry, now+ttl) {
+			return
+		}
+	}
+}
+
+// refresh replaces the expiry of the element unless it was claimed for removal
+func (self *element[K, V]) refresh(expiry int64) bool {
+	for {
+		current := self.expiry.Load()
+		if current == expiryClaimed {
+			return false
+		}
+		if self.expiry.CompareAndSwap(current, expiry) {
+			return true
+		}
+	}
 }
 
 // if current element is deleted
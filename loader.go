@@ -0,0 +1,131 @@
+package haxmap
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrKeyNotFound is returned by GetE when the key is absent and no loader is set
+var ErrKeyNotFound = errors.New("haxmap: key not found")
+
+type (
+	// an in-flight loader call shared by all callers missing the same key
+	loadCall[K hashable, V any] struct {
+		wg     sync.WaitGroup
+		key    K
+		value  V
+		err    error
+		panicV any // value the call panicked with, panicked again in every caller sharing it
+	}
+
+	// loadGroup coalesces concurrent loader or constructor calls for the same key into one (singleflight)
+	// calls are indexed by the hash of their key and keys compared like in the map, which might differ from ==
+	loadGroup[K hashable, V any] struct {
+		mu    sync.Mutex
+		calls map[uintptr][]*loadCall[K, V]
+	}
+)
+
+// SetLoader sets a read-through loader which is invoked by Get and GetE when the key is absent
+// The loaded value is stored in the map before being returned, errors are not cached
+// Concurrent misses for the same key result in a single loader call whose result is shared by all callers, a panic of
+// the loader is propagated to all of them
+func (m *Map[K, V]) SetLoader(loader func(K) (V, error)) {
+	m.initialize()
+	m.loader = loader
+}
+
+// GetE retrieves an element from the map, loading it upon a miss if a loader was set via SetLoader
+// It returns the error of the loader if loading failed and ErrKeyNotFound if the key is absent and no loader is set
+func (m *Map[K, V]) GetE(key K) (value V, err error) {
+	m.initialize()
+	if elem := m.probe(key); elem != nil {
+		value = *m.valueOf(elem)
+		return
+	}
+	if m.loader == nil {
+		err = ErrKeyNotFound
+		return
+	}
+	return m.load(key, m.loader)
+}
+
+// GetOrLoad retrieves an element from the map, loading it with the given loader upon a miss
+// Concurrent misses for the same key result in a single loader call whose result is shared by all callers,
+// including those missing the key in Get or GetE if a loader was set via SetLoader, errors are not cached
+// A hit hashes the key once and takes no lock, only misses go through the table of in-flight loads
+func (m *Map[K, V]) GetOrLoad(key K, loader func(K) (V, error)) (V, error) {
+	m.initialize()
+	if elem := m.probe(key); elem != nil {
+		return *m.valueOf(elem), nil
+	}
+	return m.load(key, loader)
+}
+
+// load fetches the value of an absent key from the loader and stores it in the map
+// the zero value is returned alongside the error if the loader fails
+func (m *Map[K, V]) load(key K, loader func(K) (V, error)) (V, error) {
+	h := m.hasher(key)
+	return m.loads.do(h, key, m.keyEqual, func() (value V, err error) {
+		if elem := m.lookup(h, key); elem != nil { // loaded by a call which just finished
+			return *m.valueOf(elem), nil
+		}
+		if value, err = loader(key); err != nil {
+			return *new(V), err
+		}
+		m.Set(key, value)
+		return
+	})
+}
+
+// do executes fn for the given key with hash h unless a call for the same key is already in-flight
+// in which case it waits for that call to finish and returns its results, keys are compared with eq or with == if nil
+// If fn panics, the panic is propagated to every caller sharing the call instead of handing them the zero value
+func (g *loadGroup[K, V]) do(h uintptr, key K, eq func(a, b K) bool, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	for _, call := range g.calls[h] {
+		if keysEqual(eq, call.key, key) {
+			g.mu.Unlock()
+			call.wg.Wait()
+			if call.panicV != nil {
+				panic(call.panicV)
+			}
+			return call.value, call.err
+		}
+	}
+	call := &loadCall[K, V]{key: key}
+	call.wg.Add(1)
+	g.calls[h] = append(g.calls[h], call)
+	g.mu.Unlock()
+
+	defer func() {
+		call.panicV = recover()
+		g.mu.Lock()
+		g.release(h, call)
+		g.mu.Unlock()
+		call.wg.Done()
+		if call.panicV != nil {
+			panic(call.panicV)
+		}
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
+
+// release removes a finished call from the calls of its hash, the caller must hold the mutex
+func (g *loadGroup[K, V]) release(h uintptr, call *loadCall[K, V]) {
+	calls := g.calls[h]
+	for idx := range calls {
+		if calls[idx] == call {
+			calls[idx] = calls[len(calls)-1]
+			calls[len(calls)-1] = nil
+			calls = calls[:len(calls)-1]
+			break
+		}
+	}
+	if len(calls) == 0 {
+		delete(g.calls, h)
+	} else {
+		g.calls[h] = calls
+	}
+}
@@ -1,25 +1,27 @@
 package haxmap
 
 import (
-	"encoding/json"
 	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"unsafe"
-
-	"golang.org/x/exp/constraints"
 )
 
 const (
 	// defaultSize is the default size for a zero allocated map
 	defaultSize = 8
 
-	// maxFillRate is the maximum fill rate for the slice before a resize will happen
+	// maxFillRate is the default maximum fill rate for the slice before a resize will happen
 	maxFillRate = 50
 
 	// intSizeBytes is the size in byte of an int or uint value
 	intSizeBytes = strconv.IntSize >> 3
+
+	// parallelIndexMin is the number of entries from which resizes rebuild the index with several goroutines
+	parallelIndexMin = 1 << 16
 )
 
 // indicates resizing operation status enums
@@ -28,9 +30,19 @@ const (
 	resizingInProgress
 )
 
+// indicates lazy initialization status enums
+const (
+	uninitialized uint32 = iota
+	initializing
+	initialized
+)
+
 type (
+	// hashable keys are any comparable type
+	// numbers, strings and pointers get a specialized default hasher, composite keys like structs, arrays
+	// and interfaces are hashed with maphash.Comparable which requires go1.24, else SetHasher must be called
 	hashable interface {
-		constraints.Integer | constraints.Float | constraints.Complex | ~string | uintptr | ~unsafe.Pointer
+		comparable
 	}
 
 	// metadata of the hashmap
@@ -45,13 +57,54 @@ type (
 	}
 
 	// Map implements the concurrent hashmap
+	// The zero value is an empty map ready to use, it is set up with the defaults of New upon first use
+	// A map does not spawn any goroutine unless StartJanitor is called, resizes are done synchronously by the caller
+	// which rebuilds the index of maps holding at least 65536 entries with one short-lived goroutine per processor
+	// whose insertion pushed the fill rate over the limit while other callers keep operating on the current index
 	Map[K hashable, V any] struct {
 		listHead    *element[K, V] // Harris lock-free list of elements in ascending order of hash
 		hasher      func(K) uintptr
+		seed        uint64                        // seed of the default hasher, randomized per map
+		algorithm   HashAlgorithm                 // algorithm of the default hasher
+		keyEqual    func(a, b K) bool             // custom key equality, nil to compare keys with ==
 		metadata    atomicPointer[metadata[K, V]] // atomic.Pointer for safe access even during resizing
 		resizing    atomicUint32
+		state       atomicUint32 // lazy initialization status, see initialize
 		numItems    atomicUintptr
 		defaultSize uintptr
+		maxFillRate uintptr                       // fill rate above which the map grows
+		growthShift uintptr                       // log2 of the growth factor applied when the map grows
+		minFillRate uintptr                       // fill rate below which the map shrinks automatically, 0 if disabled
+		maxEntries  uintptr                       // maximum number of entries, 0 if unbounded
+		evictor     Evictor[K, V]                 // picks entries to evict when a bounded map is full, nil to reject insertions
+		loader      func(K) (V, error)            // read-through loader invoked upon a miss, nil if disabled
+		loads       *loadGroup[K, V]              // coalesces concurrent loader calls for the same key
+		computes    *loadGroup[K, V]              // coalesces concurrent constructor calls of GetOrCompute for the same key
+		expiries    atomicUint32                  // hasExpiries once an entry was stored with a ttl, enables expiry checks
+		clock       func() int64                  // current unix time in nanoseconds, replaceable in tests
+		janitorMu   sync.Mutex                    // guards janitor
+		janitor     chan struct{}                 // closed to stop the background sweep started by SetJanitor
+		sweepFrom   atomicUintptr                 // hash from which the next bounded sweep of the janitor starts
+		sliding     bool                          // whether accesses by key extend the expiry of entries
+		onEvict     func(K, V, Reason)            // notified of entries removed by expiry, eviction or Clear, nil if disabled
+		onInsert    func(K, V)                    // notified of inserted entries, nil if disabled
+		onUpdate    func(K, V, V)                 // notified of updated entries with their old and new values, nil if disabled
+		onDelete    func(K, V)                    // notified of every removed entry, nil if disabled
+		watchers    atomicPointer[watchers[K, V]] // subscribers of Watch and WatchAll, nil until the first subscription
+		instrument  Instrumentation               // receives telemetry events, nil if disabled
+		onResize    func(ResizeEvent)             // notified when resizes of the index start and finish, nil if disabled
+		changes     *changelog[K]                 // sequence of writes and deletions for SnapshotSince, nil if not tracked
+		sortedCBOR  bool                          // whether MarshalCBOR sorts keys, see SetDeterministicCBOR
+		stats       opStats                       // counters reported by Stats
+		name        string                        // identifies the map in pprof labels and resize events, see SetName
+		inline      bool                          // whether new entries store their value inline, see inlineElement
+		tomb        *V                            // value of elements claimed for deletion, see removeIf
+	}
+
+	// Pair is a key-value pair used by bulk operations on the map
+	Pair[K hashable, V any] struct {
+		Key   K
+		Value V
 	}
 
 	// used in deletion of map elements
@@ -59,24 +112,72 @@ type (
 		keyHash uintptr
 		key     K
 	}
+
+	// used in bulk lookup of map elements
+	lookupRequest[K hashable] struct {
+		keyHash  uintptr
+		key      K
+		position int // position of the key in the user provided batch
+	}
+
+	// used in bulk insertion of map elements
+	insertionRequest[K hashable, V any] struct {
+		keyHash uintptr
+		key     K
+		value   V
+	}
 )
 
 // New returns a new HashMap instance with an optional specific initialization size
 func New[K hashable, V any](size ...uintptr) *Map[K, V] {
-	m := &Map[K, V]{listHead: newListHead[K, V]()}
-	m.numItems.Store(0)
-	m.defaultSize = defaultSize
+	m := &Map[K, V]{}
 	if len(size) > 0 && size[0] > 0 {
 		m.defaultSize = size[0]
 	}
-	m.allocate(m.defaultSize)
-	m.setDefaultHasher()
+	m.initialize()
+	return m
+}
+
+// NewFromMap returns a new HashMap instance holding all entries of the given built-in map
+// The map is pre-allocated to fit all entries without resizing unless a larger size is provided
+// The entries are linked in a single pass and indexed once instead of being inserted one by one
+func NewFromMap[K hashable, V any](src map[K]V, size ...uintptr) *Map[K, V] {
+	initialSize := fitSize(uintptr(len(src)), maxFillRate)
+	if len(size) > 0 && size[0] > initialSize {
+		initialSize = size[0]
+	}
+	m := New[K, V](initialSize)
+	pairs := make([]Pair[K, V], 0, len(src))
+	for key, value := range src {
+		pairs = append(pairs, Pair[K, V]{Key: key, Value: value})
+	}
+	m.build(pairs)
+	return m
+}
+
+// NewLike returns a new empty map with the same configuration as the template map
+// The hasher, initial size and policies of the template are carried over but none of its entries are copied
+// Mutation hooks are not carried over either as they usually maintain data derived from the entries of the template
+func NewLike[K hashable, V any](template *Map[K, V]) *Map[K, V] {
+	template.initialize()
+	m := New[K, V](template.defaultSize)
+	m.hasher, m.seed, m.algorithm, m.keyEqual = template.hasher, template.seed, template.algorithm, template.keyEqual
+	m.loader, m.clock, m.onEvict, m.sliding = template.loader, template.clock, template.onEvict, template.sliding
+	m.maxFillRate, m.minFillRate, m.growthShift = template.maxFillRate, template.minFillRate, template.growthShift
+	m.maxEntries, m.evictor, m.sortedCBOR = template.maxEntries, template.evictor, template.sortedCBOR
+	m.instrument, m.onResize = template.instrument, template.onResize
+	if template.changes != nil {
+		m.EnableChangeTracking()
+	}
+	m.stats.enabled = template.stats.enabled
 	return m
 }
 
 // Del deletes key/keys from the map
-// Bulk deletion is more efficient than deleting keys one by one
+// Bulk deletion is more efficient than deleting keys one by one as the keys are hashed and sorted upfront
+// so that consecutive deletions resume the list traversal from the previously visited element
 func (m *Map[K, V]) Del(keys ...K) {
+	m.initialize()
 	size := len(keys)
 	switch {
 	case size == 0:
@@ -90,18 +191,15 @@ func (m *Map[K, V]) Del(keys ...K) {
 			existing = m.listHead.next()
 		}
 		for ; existing != nil && existing.keyHash <= h; existing = existing.next() {
-			if existing.key == keys[0] {
-				if existing.remove() { // mark node for lazy removal on next pass
+			if m.equal(existing.key, keys[0]) {
+				if existing.remove() { // mark node for removal, unlinked right after it leaves the index
 					m.removeItemFromIndex(existing) // remove node from map index
 				}
 				return
 			}
 		}
 	default: // delete multiple entries
-		var (
-			delQ = make([]deletionRequest[K], size)
-			iter = 0
-		)
+		delQ := make([]deletionRequest[K], size)
 		for idx := 0; idx < size; idx++ {
 			delQ[idx].keyHash, delQ[idx].key = m.hasher(keys[idx]), keys[idx]
 		}
@@ -111,23 +209,30 @@ func (m *Map[K, V]) Del(keys ...K) {
 			return delQ[i].keyHash < delQ[j].keyHash
 		})
 
-		elem := m.metadata.Load().indexElement(delQ[0].keyHash)
-
-		if elem == nil || elem.keyHash > delQ[0].keyHash {
-			elem = m.listHead.next()
-		}
-
-		for elem != nil && iter < size {
-			if elem.keyHash == delQ[iter].keyHash && elem.key == delQ[iter].key {
-				if elem.remove() { // mark node for lazy removal on next pass
-					m.removeItemFromIndex(elem) // remove node from map index
+		var (
+			data = m.metadata.Load()
+			prev *element[K, V]
+		)
+		for idx := 0; idx < size; idx++ {
+			h := delQ[idx].keyHash
+			elem := data.indexElement(h)
+			if elem == nil || elem.keyHash > h {
+				elem = m.listHead.next()
+			}
+			// resume from the previously visited element if it is closer than the indexed one
+			if prev != nil && elem != nil && prev.keyHash > elem.keyHash && !prev.isDeleted() {
+				elem = prev
+			}
+			for ; elem != nil && elem.keyHash <= h; elem = elem.next() {
+				if elem.keyHash == h && m.equal(elem.key, delQ[idx].key) {
+					if elem.remove() { // mark node for removal, unlinked right after it leaves the index
+						m.removeItemFromIndex(elem) // remove node from map index
+					}
+					break
+				}
+				if elem.keyHash < h { // elements with the same hash are not skipped as the next key might collide
+					prev = elem
 				}
-				iter++
-				elem = elem.next()
-			} else if elem.keyHash > delQ[iter].keyHash {
-				iter++
-			} else {
-				elem = elem.next()
 			}
 		}
 	}
@@ -135,28 +240,118 @@ func (m *Map[K, V]) Del(keys ...K) {
 
 // Get retrieves an element from the map
 // returns `false“ if element is absent
+// If a loader was set via SetLoader, a miss loads and stores the value instead
 func (m *Map[K, V]) Get(key K) (value V, ok bool) {
+	m.initialize()
+	if elem := m.probe(key); elem != nil {
+		value, ok = *m.valueOf(elem), true
+		return
+	}
+	if m.loader != nil {
+		var err error
+		value, err = m.load(key, m.loader)
+		ok = err == nil
+		return
+	}
+	ok = false
+	return
+}
+
+// probe returns the element of the key or nil if the key is absent, accounting for the lookup in the statistics
+func (m *Map[K, V]) probe(key K) *element[K, V] {
 	h := m.hasher(key)
 	// inline search
-	for elem := m.metadata.Load().indexElement(h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
-		if elem.key == key {
-			value, ok = *elem.value.Load(), !elem.isDeleted()
-			return
+	var steps uintptr
+	for elem := m.seek(m.metadata.Load(), h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+		steps++
+		if m.equal(elem.key, key) {
+			if !elem.isDeleted() && m.live(elem) {
+				m.stats.probed(steps)
+				m.count(OpGetHit)
+				return elem
+			}
+			break
+		}
+	}
+	m.stats.probed(steps)
+	m.count(OpGetMiss)
+	return nil
+}
+
+// GetMany retrieves multiple elements from the map
+// values[i] and found[i] hold the result for keys[i], the same as returned by Get(keys[i])
+// Bulk lookup is more efficient than getting keys one by one as the keys are hashed and sorted upfront
+// so that consecutive lookups resume the list traversal from the previously visited element
+func (m *Map[K, V]) GetMany(keys ...K) (values []V, found []bool) {
+	m.initialize()
+	size := len(keys)
+	values, found = make([]V, size), make([]bool, size)
+	if size == 0 {
+		return
+	}
+	getQ := make([]lookupRequest[K], size)
+	for idx := 0; idx < size; idx++ {
+		getQ[idx].keyHash, getQ[idx].key, getQ[idx].position = m.hasher(keys[idx]), keys[idx], idx
+	}
+
+	// sort in ascending order of keyhash
+	sort.Slice(getQ, func(i, j int) bool {
+		return getQ[i].keyHash < getQ[j].keyHash
+	})
+
+	var (
+		data = m.metadata.Load()
+		prev *element[K, V]
+	)
+	for idx := 0; idx < size; idx++ {
+		h := getQ[idx].keyHash
+		start := m.seek(data, h)
+		// resume from the previously visited element if it is closer than the indexed one
+		if prev != nil && (start == nil || prev.keyHash > start.keyHash) {
+			start = prev
+		}
+		for elem := start; elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+			if elem.keyHash < h { // elements with the same hash are not skipped as the next key might collide
+				prev = elem
+			}
+			if m.equal(elem.key, getQ[idx].key) {
+				if !elem.isDeleted() && m.live(elem) {
+					values[getQ[idx].position], found[getQ[idx].position] = *m.valueOf(elem), true
+				}
+				break
+			}
+		}
+		if !found[getQ[idx].position] && m.loader != nil {
+			var err error
+			if values[getQ[idx].position], err = m.load(getQ[idx].key, m.loader); err == nil {
+				found[getQ[idx].position] = true
+			}
 		}
 	}
-	ok = false
 	return
 }
 
 // Set tries to update an element if key is present else it inserts a new element
-// If a resizing operation is happening concurrently while calling Set()
-// then the item might show up in the map only after the resize operation is finished
+// The element is visible to every subsequent lookup from any goroutine once Set returns, even while the map is resized
+// An expiry set by SetWithTTL is cleared
 func (m *Map[K, V]) Set(key K, value V) {
+	m.initialize()
+	m.set(key, value, nil, expiration{})
+}
+
+// set inserts or updates the entry with the given expiration, see SetWithTTL
+// ptr is stored instead of a copy of value if not nil, see SetPtr
+func (m *Map[K, V]) set(key K, value V, ptr *V, exp expiration) {
+	if m.maxEntries > 0 {
+		m.trySet(key, value, ptr, exp)
+		return
+	}
 	var (
 		h        = m.hasher(key)
-		valPtr   = &value
+		valPtr   *V
 		alloc    *element[K, V]
 		created  = false
+		old      *V
 		data     = m.metadata.Load()
 		existing = data.indexElement(h)
 	)
@@ -164,120 +359,331 @@ func (m *Map[K, V]) Set(key K, value V) {
 	if existing == nil || existing.keyHash > h {
 		existing = m.listHead
 	}
-	if alloc, created = existing.inject(h, key, valPtr); alloc != nil {
+	if alloc, created, old, valPtr = existing.inject(h, key, value, ptr, exp, m.keyEqual, m.inline, m.tomb); alloc != nil {
 		if created {
 			m.numItems.Add(1)
 		}
 	} else {
-		for existing = m.listHead; alloc == nil; alloc, created = existing.inject(h, key, valPtr) {
+		for existing = m.listHead; alloc == nil; alloc, created, old, valPtr = existing.inject(h, key, value, ptr, exp, m.keyEqual, m.inline, m.tomb) {
+			m.stats.retried()
 		}
 		if created {
 			m.numItems.Add(1)
 		}
 	}
+	m.changed(alloc)
+	m.notify(alloc, old, valPtr)
 
 	count := data.addItemToIndex(alloc)
-	if resizeNeeded(uintptr(len(data.index)), count) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
-		m.grow(0) // double in size
+	if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		m.grow(0) // grow by the growth factor
+	}
+}
+
+// SetIfAbsent stores the value only if the key is absent, an existing value is never overwritten
+// It returns a boolean indicating whether the value was stored or not, which is false as well if the insertion
+// was rejected by a full bounded map
+func (m *Map[K, V]) SetIfAbsent(key K, value V) bool {
+	m.initialize()
+	_, loaded, err := m.getOrSet(key, value)
+	return !loaded && err == nil
+}
+
+// SetIfPresent updates the value only if the key is present, an absent key is never inserted
+// It returns a boolean indicating whether the value was updated or not
+func (m *Map[K, V]) SetIfPresent(key K, value V) bool {
+	m.initialize()
+	var (
+		h        = m.hasher(key)
+		existing = m.metadata.Load().indexElement(h)
+	)
+	if existing == nil || existing.keyHash > h {
+		existing = m.listHead
+	}
+	for {
+		_, current, _ := existing.search(h, key, m.keyEqual)
+		if current == nil || !m.live(current) {
+			return false
+		}
+		if old := current.store(&value, m.tomb); old != nil {
+			m.changed(current)
+			m.notify(current, old, &value)
+			return true
+		}
+		existing = m.listHead // claimed for deletion, retry once it is unlinked
+	}
+}
+
+// SetMany sets multiple key-value pairs in the map, overwriting existing values
+// Bulk insertion is more efficient than setting pairs one by one as the keys are hashed and sorted upfront
+// so that consecutive insertions resume the list traversal from the previously inserted element
+// If a key is repeated within the batch, the last pair for that key wins
+func (m *Map[K, V]) SetMany(pairs ...Pair[K, V]) {
+	m.initialize()
+	size := len(pairs)
+	if size == 0 {
+		return
+	}
+	if m.maxEntries > 0 { // bounded maps admit entries one by one
+		for idx := 0; idx < size; idx++ {
+			m.TrySet(pairs[idx].Key, pairs[idx].Value)
+		}
+		return
+	}
+	insQ := make([]insertionRequest[K, V], size)
+	for idx := 0; idx < size; idx++ {
+		insQ[idx].keyHash, insQ[idx].key, insQ[idx].value = m.hasher(pairs[idx].Key), pairs[idx].Key, pairs[idx].Value
+	}
+
+	// sort in ascending order of keyhash, stable so that the last duplicate key is inserted last
+	sort.SliceStable(insQ, func(i, j int) bool {
+		return insQ[i].keyHash < insQ[j].keyHash
+	})
+
+	// grow upfront once instead of resizing multiple times midway
+	if count := m.Len() + uintptr(size); resizeNeeded(uintptr(len(m.metadata.Load().index)), count, m.maxFillRate) {
+		m.Grow(fitSize(count, m.maxFillRate))
+	}
+
+	var prev *element[K, V]
+	for idx := 0; idx < size; idx++ {
+		var (
+			h        = insQ[idx].keyHash
+			data     = m.metadata.Load()
+			existing = data.indexElement(h)
+			alloc    *element[K, V]
+			created  = false
+			old      *V
+			stored   *V
+		)
+		if existing == nil || existing.keyHash > h {
+			existing = m.listHead
+		}
+		// resume from the previously inserted element if it is closer than the indexed one
+		if prev != nil && prev.keyHash > existing.keyHash && !prev.isDeleted() {
+			existing = prev
+		}
+		if alloc, created, old, stored = existing.inject(h, insQ[idx].key, insQ[idx].value, nil, expiration{}, m.keyEqual, m.inline, m.tomb); alloc == nil {
+			for existing = m.listHead; alloc == nil; alloc, created, old, stored = existing.inject(h, insQ[idx].key, insQ[idx].value, nil, expiration{}, m.keyEqual, m.inline, m.tomb) {
+				m.stats.retried()
+			}
+		}
+		if created {
+			m.numItems.Add(1)
+		}
+		prev = alloc
+		m.changed(alloc)
+		m.notify(alloc, old, stored)
+
+		count := data.addItemToIndex(alloc)
+		if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+			m.grow(0) // grow by the growth factor
+		}
 	}
 }
 
 // GetOrSet returns the existing value for the key if present
 // Otherwise, it stores and returns the given value
 // The loaded result is true if the value was loaded, false if stored
+// The check and the insertion happen atomically, concurrent callers never overwrite each other's value
+// An insertion rejected by a full bounded map also returns the given value and false, see TryGetOrSet
 func (m *Map[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	m.initialize()
+	actual, loaded, _ = m.getOrSet(key, value)
+	return
+}
+
+// TryGetOrSet is like GetOrSet but returns ErrMapFull if the key is absent and the bounded map is full
+func (m *Map[K, V]) TryGetOrSet(key K, value V) (actual V, loaded bool, err error) {
+	m.initialize()
+	return m.getOrSet(key, value)
+}
+
+// getOrSet implements GetOrSet, reporting insertions rejected by a full bounded map with ErrMapFull
+func (m *Map[K, V]) getOrSet(key K, value V) (actual V, loaded bool, err error) {
 	var (
 		h        = m.hasher(key)
 		data     = m.metadata.Load()
 		existing = data.indexElement(h)
 	)
 	// try to get the element if present
-	for elem := existing; elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
-		if elem.key == key && !elem.isDeleted() {
-			actual, loaded = *elem.value.Load(), true
+	for elem := m.seek(data, h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+		if m.equal(elem.key, key) && !elem.isDeleted() && m.live(elem) {
+			actual, loaded = *m.valueOf(elem), true
 			return
 		}
 	}
 	// Get() failed because element is absent
-	// store the value given by user
-	actual, loaded = value, false
-
+	// store the value given by user unless a concurrent caller stored one first
 	var (
-		alloc   *element[K, V]
-		created = false
-		valPtr  = &value
+		alloc    *element[K, V]
+		created  = false
+		valPtr   = &value
+		reserved = m.maxEntries > 0
 	)
+	actual, loaded = value, false
+	if reserved && !m.reserve() {
+		err = ErrMapFull
+		return
+	}
 	if existing == nil || existing.keyHash > h {
 		existing = m.listHead
 	}
-	if alloc, created = existing.inject(h, key, valPtr); alloc != nil {
-		if created {
-			m.numItems.Add(1)
+	for {
+		if alloc, created = existing.insert(h, key, valPtr, m.keyEqual); alloc == nil {
+			for existing = m.listHead; alloc == nil; alloc, created = existing.insert(h, key, valPtr, m.keyEqual) {
+				m.stats.retried()
+			}
 		}
-	} else {
-		for existing = m.listHead; alloc == nil; alloc, created = existing.inject(h, key, valPtr) {
-		}
-		if created {
-			m.numItems.Add(1)
+		if created || m.live(alloc) {
+			break
 		}
+		existing = m.listHead // the entry found expired and got removed, insert anew
 	}
+	m.settle(created, reserved)
+	if !created {
+		actual, loaded = *m.valueOf(alloc), true
+		return
+	}
+	m.changed(alloc)
+	m.notify(alloc, nil, valPtr)
 
 	count := data.addItemToIndex(alloc)
-	if resizeNeeded(uintptr(len(data.index)), count) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
-		m.grow(0) // double in size
+	if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		m.grow(0) // grow by the growth factor
 	}
 	return
 }
 
 // GetOrCompute is similar to GetOrSet but the value to be set is obtained from a constructor
-// the value constructor is called only once
+// the value constructor is called at most once per key, even when invoked by concurrent callers
+// callers racing on the same absent key wait for the single constructor call and report the value as loaded
 func (m *Map[K, V]) GetOrCompute(key K, valueFn func() V) (actual V, loaded bool) {
-	var (
-		h        = m.hasher(key)
-		data     = m.metadata.Load()
-		existing = data.indexElement(h)
-	)
+	m.initialize()
+	h := m.hasher(key)
 	// try to get the element if present
-	for elem := existing; elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
-		if elem.key == key && !elem.isDeleted() {
-			actual, loaded = *elem.value.Load(), true
+	for elem := m.seek(m.metadata.Load(), h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+		if m.equal(elem.key, key) && !elem.isDeleted() && m.live(elem) {
+			actual, loaded = *m.valueOf(elem), true
 			return
 		}
 	}
 	// Get() failed because element is absent
 	// compute the value from the constructor and store it
-	value := valueFn()
-	actual, loaded = value, false
+	loaded = true
+	// constructors have their own group as sharing the result of a failed loader would return the zero value
+	actual, _ = m.computes.do(h, key, m.keyEqual, func() (V, error) {
+		// a previous constructor call might have stored the key after our miss
+		for elem := m.seek(m.metadata.Load(), h); elem != nil && elem.keyHash <= h; elem = elem.nextPtr.Load() {
+			if m.equal(elem.key, key) && !elem.isDeleted() && m.live(elem) {
+				return *m.valueOf(elem), nil
+			}
+		}
+		value, stored := m.GetOrSet(key, valueFn())
+		loaded = stored
+		return value, nil
+	})
+	return
+}
 
-	var (
-		alloc   *element[K, V]
-		created = false
-		valPtr  = &value
-	)
-	if existing == nil || existing.keyHash > h {
-		existing = m.listHead
-	}
-	if alloc, created = existing.inject(h, key, valPtr); alloc != nil {
-		if created {
-			m.numItems.Add(1)
+// Compute atomically updates a map entry given its key with the result of `valueFn`
+// valueFn receives the current value and whether the key is present, and returns the new value
+// along with a boolean `delete` which removes the entry instead of storing the new value if true
+// valueFn might be called multiple times under contention hence it should be free of side effects
+// It returns the value stored in the map and a boolean indicating whether the entry is present after the update,
+// which is false as well if the insertion was rejected by a full bounded map, see TryCompute
+func (m *Map[K, V]) Compute(key K, valueFn func(oldValue V, loaded bool) (newValue V, delete bool)) (actual V, ok bool) {
+	m.initialize()
+	actual, ok, _ = m.compute(key, valueFn)
+	return
+}
+
+// TryCompute is like Compute but returns ErrMapFull if valueFn computed a value for an absent key and the bounded map
+// is full
+func (m *Map[K, V]) TryCompute(key K, valueFn func(oldValue V, loaded bool) (newValue V, delete bool)) (actual V, ok bool, err error) {
+	m.initialize()
+	return m.compute(key, valueFn)
+}
+
+// compute implements Compute, reporting insertions rejected by a full bounded map with ErrMapFull
+func (m *Map[K, V]) compute(key K, valueFn func(oldValue V, loaded bool) (newValue V, delete bool)) (actual V, ok bool, err error) {
+	h := m.hasher(key)
+	for {
+		data := m.metadata.Load()
+		existing := data.indexElement(h)
+		if existing == nil || existing.keyHash > h {
+			existing = m.listHead
 		}
-	} else {
-		for existing = m.listHead; alloc == nil; alloc, created = existing.inject(h, key, valPtr) {
+		if _, current, _ := existing.search(h, key, m.keyEqual); current != nil {
+			if !m.live(current) {
+				continue // the entry expired and got removed, retry with the latest state
+			}
+			oldPtr := current.value.Load()
+			if oldPtr == m.tomb {
+				continue // claimed for deletion, retry once it is unlinked
+			}
+			newValue, del := valueFn(*oldPtr, true)
+			if del {
+				// the value is compared and claimed in a single step
+				// so that a value replaced concurrently after valueFn was called is never deleted
+				if m.removeIf(current, oldPtr) {
+					m.removeItemFromIndex(current)
+					return
+				}
+			} else if current.value.CompareAndSwap(oldPtr, &newValue) && !current.isDeleted() {
+				m.changed(current)
+				m.notify(current, oldPtr, &newValue)
+				actual, ok = newValue, true
+				return
+			}
+			continue // value was modified concurrently, retry with the latest state
 		}
-		if created {
-			m.numItems.Add(1)
+
+		newValue, del := valueFn(*new(V), false)
+		if del {
+			return
+		}
+		reserved := m.maxEntries > 0
+		if reserved && !m.reserve() {
+			err = ErrMapFull
+			return
 		}
+		alloc, created := existing.insert(h, key, &newValue, m.keyEqual)
+		m.settle(created, reserved)
+		if !created {
+			m.stats.retried()
+			continue // key was inserted concurrently, retry with the latest state
+		}
+		m.changed(alloc)
+		m.notify(alloc, nil, &newValue)
+		count := data.addItemToIndex(alloc)
+		if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+			m.grow(0) // grow by the growth factor
+		}
+		actual, ok = newValue, true
+		return
 	}
+}
 
-	count := data.addItemToIndex(alloc)
-	if resizeNeeded(uintptr(len(data.index)), count) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
-		m.grow(0) // double in size
-	}
-	return
+// Upsert inserts the value if the key is absent, otherwise it atomically replaces the existing value
+// with the result of merge(existing, value)
+// merge might be called multiple times under contention hence it should be free of side effects
+// It returns the value stored in the map
+func (m *Map[K, V]) Upsert(key K, value V, merge func(existing, incoming V) V) V {
+	m.initialize()
+	actual, _ := m.Compute(key, func(oldValue V, loaded bool) (V, bool) {
+		if !loaded {
+			return value, false
+		}
+		return merge(oldValue, value), false
+	})
+	return actual
 }
 
 // GetAndDel deletes the key from the map, returning the previous value if any.
+// Among concurrent callers deleting the same key exactly one observes the value and `ok` as true
 func (m *Map[K, V]) GetAndDel(key K) (value V, ok bool) {
+	m.initialize()
 	var (
 		h        = m.hasher(key)
 		existing = m.metadata.Load().indexElement(h)
@@ -286,9 +692,9 @@ func (m *Map[K, V]) GetAndDel(key K) (value V, ok bool) {
 		existing = m.listHead.next()
 	}
 	for ; existing != nil && existing.keyHash <= h; existing = existing.next() {
-		if existing.key == key {
-			value, ok = *existing.value.Load(), !existing.isDeleted()
-			if existing.remove() {
+		if m.equal(existing.key, key) {
+			if m.live(existing) && existing.remove() { // only the caller marking the node for deletion claims its value
+				value, ok = *m.valueOf(existing), true
 				m.removeItemFromIndex(existing)
 			}
 			return
@@ -301,6 +707,7 @@ func (m *Map[K, V]) GetAndDel(key K) (value V, ok bool) {
 // and setting it to `newValue` if the above comparison is successful
 // It returns a boolean indicating whether the CompareAndSwap was successful or not
 func (m *Map[K, V]) CompareAndSwap(key K, oldValue, newValue V) bool {
+	m.initialize()
 	var (
 		h        = m.hasher(key)
 		existing = m.metadata.Load().indexElement(h)
@@ -308,17 +715,36 @@ func (m *Map[K, V]) CompareAndSwap(key K, oldValue, newValue V) bool {
 	if existing == nil || existing.keyHash > h {
 		existing = m.listHead
 	}
-	if _, current, _ := existing.search(h, key); current != nil {
-		if oldPtr := current.value.Load(); reflect.DeepEqual(*oldPtr, oldValue) {
-			return current.value.CompareAndSwap(oldPtr, &newValue)
+	for {
+		_, current, _ := existing.search(h, key, m.keyEqual)
+		if current == nil || !m.live(current) {
+			return false
+		}
+		existing = m.listHead // retries start from the head as the current element might get deleted
+		oldPtr := current.value.Load()
+		if oldPtr == m.tomb {
+			continue // claimed for deletion, retry once it is unlinked
+		}
+		if !reflect.DeepEqual(*oldPtr, oldValue) {
+			return false
+		}
+		if current.value.CompareAndSwap(oldPtr, &newValue) && !current.isDeleted() {
+			m.changed(current)
+			m.notify(current, oldPtr, &newValue)
+			return true
 		}
 	}
-	return false
 }
 
-// Swap atomically swaps the value of a map entry given its key
-// It returns the old value if swap was successful and a boolean `swapped` indicating whether the swap was successful or not
-func (m *Map[K, V]) Swap(key K, newValue V) (oldValue V, swapped bool) {
+// CompareAndDelete atomically deletes a map entry given its key if its current value is equal to `oldValue`
+// It returns a boolean indicating whether the entry was deleted or not
+func (m *Map[K, V]) CompareAndDelete(key K, oldValue V) bool {
+	return m.deleteIf(key, func(value V) bool { return reflect.DeepEqual(value, oldValue) })
+}
+
+// deleteIf atomically deletes a map entry given its key if its current value matches
+func (m *Map[K, V]) deleteIf(key K, match func(V) bool) bool {
+	m.initialize()
 	var (
 		h        = m.hasher(key)
 		existing = m.metadata.Load().indexElement(h)
@@ -326,49 +752,387 @@ func (m *Map[K, V]) Swap(key K, newValue V) (oldValue V, swapped bool) {
 	if existing == nil || existing.keyHash > h {
 		existing = m.listHead
 	}
-	if _, current, _ := existing.search(h, key); current != nil {
-		oldValue, swapped = *current.value.Swap(&newValue), true
-	} else {
-		swapped = false
+	for {
+		_, current, _ := existing.search(h, key, m.keyEqual)
+		if current == nil || !m.live(current) {
+			return false
+		}
+		existing = m.listHead // retries start from the head as the current element might get deleted
+		oldPtr := current.value.Load()
+		if oldPtr == m.tomb {
+			continue // claimed for deletion, retry once it is unlinked
+		}
+		if !match(*oldPtr) {
+			return false
+		}
+		// the value is compared and claimed in a single step so that a value replaced concurrently is never deleted
+		if m.removeIf(current, oldPtr) {
+			m.removeItemFromIndex(current)
+			return true
+		}
+	}
+}
+
+// Swap atomically stores the new value for the key and returns the previous value if any
+// The loaded result reports whether the key was present, analogous to sync.Map.Swap
+// If the key is absent the new value is inserted like in Set()
+func (m *Map[K, V]) Swap(key K, newValue V) (oldValue V, loaded bool) {
+	m.initialize()
+	var (
+		h        = m.hasher(key)
+		valPtr   = &newValue
+		data     = m.metadata.Load()
+		existing = data.indexElement(h)
+		alloc    *element[K, V]
+		created  = false
+		reserved = false
+		old      *V
+	)
+	if m.maxEntries > 0 && !m.has(h, key) {
+		if !m.reserve() {
+			return // bounded map is full
+		}
+		reserved = true
+	}
+	if existing == nil || existing.keyHash > h {
+		existing = m.listHead
+	}
+	for {
+		if alloc, created = existing.insert(h, key, valPtr, m.keyEqual); alloc == nil {
+			for existing = m.listHead; alloc == nil; alloc, created = existing.insert(h, key, valPtr, m.keyEqual) {
+				m.stats.retried()
+			}
+		}
+		if created {
+			break
+		}
+		if m.live(alloc) {
+			if old = alloc.store(valPtr, m.tomb); old != nil {
+				break
+			}
+		}
+		existing = m.listHead // the entry found expired or claimed for deletion got removed, insert anew
+	}
+	m.settle(created, reserved)
+	if !created {
+		oldValue, loaded = *old, true
+		m.changed(alloc)
+		m.notify(alloc, old, valPtr)
+		return
+	}
+	m.changed(alloc)
+	m.notify(alloc, nil, valPtr)
+
+	count := data.addItemToIndex(alloc)
+	if resizeNeeded(uintptr(len(data.index)), count, m.maxFillRate) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		m.grow(0) // grow by the growth factor
 	}
 	return
 }
 
 // ForEach iterates over key-value pairs and executes the lambda provided for each such pair
 // lambda must return `true` to continue iteration and `false` to break iteration
+// The iteration is weakly consistent, it traverses the live list in ascending order of hash without blocking writers:
+//   - each key is yielded at most once
+//   - entries present and unmodified for the whole iteration are always yielded
+//   - entries set, updated or deleted concurrently might or might not be yielded, with either their old or new value
+//
+// Deleting the current key from within the lambda is safe and neither skips nor repeats any of the remaining entries
+// since a deleted element keeps pointing to its successor
 func (m *Map[K, V]) ForEach(lambda func(K, V) bool) {
-	for item := m.listHead.next(); item != nil && lambda(item.key, *item.value.Load()); item = item.next() {
+	m.initialize()
+	for item := m.skipExpired(m.listHead.next()); item != nil && lambda(item.key, *m.valueOf(item)); item = m.skipExpired(item.next()) {
+	}
+}
+
+// Clone returns a copy of the map with the same configuration, index size and entries
+// Like ForEach, the copy is taken in a single weakly consistent pass over the list
+// The list is copied in a single pass reusing the already computed hashes so no key is hashed again
+// Values are copied by assignment hence values of pointer or reference types are shared with the original map
+func (m *Map[K, V]) Clone() *Map[K, V] {
+	m.initialize()
+	return m.copyIf(uintptr(len(m.metadata.Load().index)), nil)
+}
+
+// Filter returns a new map with the same configuration holding only the entries for which the predicate returns true
+// Like Clone, the matching entries are copied reusing the already computed hashes so no key is hashed again
+func (m *Map[K, V]) Filter(predicate func(K, V) bool) *Map[K, V] {
+	m.initialize()
+	return m.copyIf(0, predicate)
+}
+
+// Merge folds all entries of the other map into this map by traversing the other map's list once
+// For keys present in both maps the value is set to resolve(ours, theirs), or theirs if resolve is nil
+// Merging a map into itself is a no-op
+func (m *Map[K, V]) Merge(other *Map[K, V], resolve func(ours, theirs V) V) {
+	m.initialize()
+	if other == m {
+		return
+	}
+	other.initialize()
+	for item := other.skipExpired(other.listHead.next()); item != nil; item = other.skipExpired(item.next()) {
+		if resolve == nil {
+			m.Set(item.key, *m.valueOf(item))
+		} else {
+			m.Upsert(item.key, *m.valueOf(item), resolve)
+		}
+	}
+}
+
+// ForEachReverse iterates over key-value pairs in the reverse order of ForEach and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// The list is singly linked hence the pairs are collected in a single weakly consistent pass (see ForEach)
+// into a buffer which is then traversed backwards
+func (m *Map[K, V]) ForEachReverse(lambda func(K, V) bool) {
+	m.initialize()
+	pairs := make([]Pair[K, V], 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		pairs = append(pairs, Pair[K, V]{Key: item.key, Value: *m.valueOf(item)})
+	}
+	for idx := len(pairs) - 1; idx >= 0 && lambda(pairs[idx].Key, pairs[idx].Value); idx-- {
+	}
+}
+
+// ForEachParallel iterates over key-value pairs on multiple goroutines and executes the lambda provided for each such pair
+// The list is partitioned into contiguous hash ranges using the map index, each range being traversed by one worker
+// The lambda is called concurrently hence it must be safe for concurrent use, it returns once all workers are done
+// If workers is not positive, runtime.GOMAXPROCS(0) workers are used
+func (m *Map[K, V]) ForEachParallel(workers int, lambda func(K, V)) {
+	m.initialize()
+	var (
+		data  = m.metadata.Load()
+		slots = uintptr(len(data.index))
+		wg    sync.WaitGroup
+	)
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if uintptr(workers) > slots {
+		workers = int(slots)
+	}
+	for worker := 0; worker < workers; worker++ {
+		var (
+			lower = (uintptr(worker) * slots / uintptr(workers)) << data.keyshifts
+			upper = (uintptr(worker+1) * slots / uintptr(workers)) << data.keyshifts
+			last  = worker == workers-1 // the last range is unbounded as its upper bound overflows
+		)
+		wg.Add(1)
+		go m.labeled("foreach", func() {
+			defer wg.Done()
+			item := data.indexElement(lower)
+			if item == nil || item.keyHash > lower {
+				item = m.listHead.next()
+			}
+			for ; item != nil && item.keyHash < lower; item = item.next() {
+			}
+			for item = m.skipExpired(item); item != nil && (last || item.keyHash < upper); item = m.skipExpired(item.next()) {
+				lambda(item.key, *m.valueOf(item))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// Keys returns all keys present in the map by traversing the list once
+func (m *Map[K, V]) Keys() []K {
+	m.initialize()
+	keys := make([]K, 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		keys = append(keys, item.key)
+	}
+	return keys
+}
+
+// Values returns all values present in the map by traversing the list once
+func (m *Map[K, V]) Values() []V {
+	m.initialize()
+	values := make([]V, 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		values = append(values, *m.valueOf(item))
 	}
+	return values
 }
 
 // Grow resizes the hashmap to a new size, gets rounded up to next power of 2
-// To double the size of the hashmap use newSize 0
+// To grow the hashmap by its growth factor (double by default) use newSize 0
 // No resizing is done in case of another resize operation already being in progress
 // Growth and map bucket policy is inspired from https://github.com/cornelk/hashmap
 func (m *Map[K, V]) Grow(newSize uintptr) {
+	m.initialize()
 	if m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
 		m.grow(newSize)
 	}
 }
 
+// GrowAndWait resizes the hashmap to a new size like Grow, gets rounded up to next power of 2
+// To grow the hashmap by its growth factor (double by default) use newSize 0
+// Unlike Grow, it waits for any in-progress resize operation to finish instead of skipping the resize
+// and never shrinks the map, hence the map holds at least newSize index slots once it returns
+func (m *Map[K, V]) GrowAndWait(newSize uintptr) {
+	m.initialize()
+	m.waitResize()
+	if newSize == 0 || roundUpPower2(newSize) > uintptr(len(m.metadata.Load().index)) {
+		m.grow(newSize)
+	} else {
+		m.resizing.Store(notResizing)
+	}
+}
+
+// Compact physically unlinks all deleted elements from the list and rebuilds the index at its current size
+// Deleted elements are unlinked as they are removed, those left over by concurrent deletions or by elements removed
+// while the list was being re-indexed are otherwise only unlinked by traversals passing over them
+// Once unlinked they are reclaimed by the garbage collector as soon as no concurrent reader references them anymore
+// It waits for any in-progress resize operation to finish
+func (m *Map[K, V]) Compact() {
+	m.initialize()
+	m.waitResize()
+	m.grow(uintptr(len(m.metadata.Load().index))) // re-indexing traverses the whole list which unlinks deleted elements
+}
+
+// Reserve grows the hashmap so that n additional entries can be inserted without triggering any resize
+// Unlike Grow, which works in raw index slots, the required index size is derived from the maximum fill rate
+// It waits for any in-progress resize operation to finish and never shrinks the map
+func (m *Map[K, V]) Reserve(n uintptr) {
+	m.initialize()
+	m.GrowAndWait(fitSize(m.Len()+n, m.maxFillRate))
+}
+
+// Shrink resizes the hashmap down to the smallest size, no smaller than its initial size, which keeps the fill rate
+// at most at half of the maximum fill rate thereby releasing the memory of an index grown by since deleted entries
+// No resizing is done in case of another resize operation already being in progress
+func (m *Map[K, V]) Shrink() {
+	m.initialize()
+	if m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		m.shrink()
+	}
+}
+
+// SetMinFillRate enables automatic shrinking of the map whenever a deletion drops its fill rate below the given percentage
+// The map is then shrunk like with Shrink(), a rate of 0 disables automatic shrinking which is the default
+// Rates are capped at a quarter of the maximum fill rate so that a shrunk map does not qualify for shrinking again
+func (m *Map[K, V]) SetMinFillRate(rate uintptr) {
+	m.initialize()
+	if rate > m.maxFillRate/4 {
+		rate = m.maxFillRate / 4
+	}
+	m.minFillRate = rate
+}
+
+// SetGrowthFactor sets the factor by which the map grows once its fill rate exceeds the maximum, 2 by default
+// Higher factors reduce the number of resizes of fast growing maps at the cost of memory
+// The index is addressed by the high bits of the hash and hence always holds a power of 2 slots,
+// so the factor gets rounded up to next power of 2 and factors below 2 are treated as 2
+func (m *Map[K, V]) SetGrowthFactor(factor uintptr) {
+	m.initialize()
+	if factor < 2 {
+		factor = 2
+	}
+	m.growthShift = log2(factor)
+}
+
+// SetMaxFillRate sets the fill rate as a percentage of the index size above which the map grows, 50 by default
+// Lower rates trade memory for shorter traversals on lookup, higher rates make the map denser
+// Rates are clamped to the range [1, 100], a rate of 0 restores the default
+func (m *Map[K, V]) SetMaxFillRate(rate uintptr) {
+	m.initialize()
+	switch {
+	case rate == 0:
+		rate = maxFillRate
+	case rate > 100:
+		rate = 100
+	}
+	m.maxFillRate = rate
+	if m.minFillRate > rate/4 {
+		m.minFillRate = rate / 4
+	}
+}
+
 // Clear the map by removing all entries in the map.
 // This operation resets the underlying metadata to its initial state.
+// The list and the index are swapped for fresh ones so the index shrinks back to its initial size.
+// If an eviction callback is set, it is called for each cleared entry, see OnEvict
 func (m *Map[K, V]) Clear() {
-	index := make([]*element[K, V], m.defaultSize)
-	header := (*reflect.SliceHeader)(unsafe.Pointer(&index))
-	newdata := &metadata[K, V]{
-		keyshifts: strconv.IntSize - log2(m.defaultSize),
-		data:      unsafe.Pointer(header.Data),
-		index:     index,
+	m.initialize()
+	for item := m.detach(); item != nil; item = item.nextPtr.Load() {
+		if item.remove() { // claim the node so that no concurrent deletion can hand it out again
+			m.numItems.Add(^uintptr(0))
+			m.changes.removed(item.key)
+			m.count(OpDelete)
+			if m.onDelete != nil {
+				m.onDelete(item.key, *m.valueOf(item))
+			}
+			if m.onEvict != nil {
+				m.onEvict(item.key, *m.valueOf(item), ReasonCleared)
+			}
+		}
+	}
+}
+
+// Drain atomically detaches all entries from the map and hands each key-value pair to the lambda provided
+// The map is left empty like after Clear() and each drained entry is passed to exactly one lambda call
+// even when Drain, GetAndDel or Del are called concurrently on the same entries
+func (m *Map[K, V]) Drain(lambda func(K, V)) {
+	m.initialize()
+	for item := m.detach(); item != nil; item = item.nextPtr.Load() {
+		if item.remove() { // claim the node so that no concurrent deletion can hand it out again
+			m.numItems.Add(^uintptr(0))
+			m.changes.removed(item.key)
+			m.count(OpDelete)
+			if m.onDelete != nil {
+				m.onDelete(item.key, *m.valueOf(item))
+			}
+			lambda(item.key, *m.valueOf(item))
+		}
 	}
-	m.listHead.nextPtr.Store(nil)
-	m.metadata.Store(newdata)
-	m.numItems.Store(0)
 }
 
 // SetHasher sets the hash function to the one provided by the user
+// Entries already present are rehashed with the new hash function, this must not run concurrently
+// with any other operation on the map as the entries are missing from the map while being rehashed
 func (m *Map[K, V]) SetHasher(hs func(K) uintptr) {
+	m.initialize()
 	m.hasher = hs
+	m.rehash()
+}
+
+// SetKeyEqual sets the function comparing keys instead of ==, like case-insensitive comparison of strings
+// Keys which are equal must have the same hash hence the hasher must be customized consistently with SetHasher
+// Entries already present are rehashed like with SetHasher, entries which became equal are merged
+func (m *Map[K, V]) SetKeyEqual(equal func(a, b K) bool) {
+	m.initialize()
+	m.keyEqual = equal
+	m.rehash()
+}
+
+// SetSeed replaces the hasher with the default hasher seeded with the given seed instead of a random one
+// A fixed seed makes the hashes and hence the iteration order reproducible across processes, useful for tests
+// Entries already present are rehashed like with SetHasher
+func (m *Map[K, V]) SetSeed(seed uint64) {
+	m.initialize()
+	m.seed = seed
+	m.setDefaultHasher()
+	m.rehash()
+}
+
+// SetHashAlgorithm replaces the hasher with the default hasher using the given algorithm
+// Entries already present are rehashed like with SetHasher
+func (m *Map[K, V]) SetHashAlgorithm(algorithm HashAlgorithm) {
+	m.initialize()
+	m.algorithm = algorithm
+	m.setDefaultHasher()
+	m.rehash()
+}
+
+// Seed returns the seed of the default hasher of the map
+func (m *Map[K, V]) Seed() uint64 {
+	m.initialize()
+	return m.seed
+}
+
+// Hash returns the hash of the key computed by the hasher of the map
+func (m *Map[K, V]) Hash(key K) uintptr {
+	m.initialize()
+	return m.hasher(key)
 }
 
 // Len returns the number of key-value pairs within the map
@@ -378,30 +1142,64 @@ func (m *Map[K, V]) Len() uintptr {
 
 // Fillrate returns the fill rate of the map as an percentage integer
 func (m *Map[K, V]) Fillrate() uintptr {
+	m.initialize()
 	data := m.metadata.Load()
 	return (data.count.Load() * 100) / uintptr(len(data.index))
 }
 
-// MarshalJSON implements the json.Marshaler interface.
-func (m *Map[K, V]) MarshalJSON() ([]byte, error) {
-	gomap := make(map[K]V)
-	for i := m.listHead.next(); i != nil; i = i.next() {
-		gomap[i.key] = *i.value.Load()
+// ToMap returns a copy of the map's entries as a built-in map by traversing the list once
+// Entries set or deleted concurrently might or might not be reflected in the copy
+func (m *Map[K, V]) ToMap() map[K]V {
+	m.initialize()
+	gomap := make(map[K]V, m.Len())
+	for i := m.skipExpired(m.listHead.next()); i != nil; i = m.skipExpired(i.next()) {
+		gomap[i.key] = *m.valueOf(i)
 	}
-	return json.Marshal(gomap)
+	return gomap
 }
 
-// UnmarshalJSON implements the json.Unmarshaler interface.
-func (m *Map[K, V]) UnmarshalJSON(i []byte) error {
-	gomap := make(map[K]V)
-	err := json.Unmarshal(i, &gomap)
-	if err != nil {
-		return err
+// equal compares keys with the custom key equality if set else with ==
+func (m *Map[K, V]) equal(a, b K) bool {
+	return keysEqual(m.keyEqual, a, b)
+}
+
+// initialize sets up the map upon first use so that the zero value of Map is ready to use
+// Every exported method calls it first, which costs a single atomic load once the map is set up
+func (m *Map[K, V]) initialize() {
+	if m.state.Load() != initialized {
+		m.initializeSlow()
 	}
-	for k, v := range gomap {
-		m.Set(k, v)
+}
+
+func (m *Map[K, V]) initializeSlow() {
+	if !m.state.CompareAndSwap(uninitialized, initializing) {
+		// another goroutine is setting up the map
+		for m.state.Load() != initialized {
+			runtime.Gosched()
+		}
+		return
 	}
-	return nil
+	if m.defaultSize == 0 {
+		m.defaultSize = defaultSize
+	}
+	m.listHead = newListHead[K, V]()
+	m.maxFillRate = maxFillRate
+	m.growthShift = 1
+	m.loads = &loadGroup[K, V]{calls: make(map[uintptr][]*loadCall[K, V])}
+	m.computes = &loadGroup[K, V]{calls: make(map[uintptr][]*loadCall[K, V])}
+	if m.clock == nil {
+		m.clock = unixNano
+	}
+	m.allocate(m.defaultSize)
+	m.seed = randomSeed()
+	m.setDefaultHasher()
+	m.setDefaultKeyEqual()
+	m.inline = pointerFree(reflect.TypeOf((*V)(nil)).Elem())
+	m.tomb = &new(struct {
+		value V
+		_     byte // distinguishes the address of a zero-sized tombstone from those of other zero-sized values
+	}).value
+	m.state.Store(initialized)
 }
 
 // allocate map with the given size
@@ -411,6 +1209,130 @@ func (m *Map[K, V]) allocate(newSize uintptr) {
 	}
 }
 
+// detach swaps in a fresh list and index of the initial size and returns the first element of the detached list
+// The count of entries is left to whoever claims the detached elements with remove(), the caller or a concurrent
+// deletion, which decrements it once per element
+func (m *Map[K, V]) detach() *element[K, V] {
+	// wait for any in-progress resize to finish, otherwise it could re-index the detached list into the map
+	m.waitResize()
+	defer m.resizing.Store(notResizing)
+
+	size := roundUpPower2(m.defaultSize)
+	index := make([]*element[K, V], size)
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&index))
+	newdata := &metadata[K, V]{
+		keyshifts: strconv.IntSize - log2(size),
+		data:      unsafe.Pointer(header.Data),
+		index:     index,
+	}
+	first := m.listHead.nextPtr.Swap(nil)
+	m.metadata.Store(newdata)
+	return first
+}
+
+// rehash rebuilds the list and the index after the hasher changed as their stored hashes and hence
+// their positions in the list and the index were computed with the previous hasher
+// The entries keep their value, expiry and version as they are not modified, hence neither hooks nor watchers are
+// notified, only entries merged into another one as their keys became equal are recorded as deleted
+func (m *Map[K, V]) rehash() {
+	if m.Len() == 0 {
+		return
+	}
+	elements := make([]*element[K, V], 0, m.Len())
+	for item := m.detach(); item != nil; item = item.nextPtr.Load() {
+		if item.isDeleted() {
+			continue
+		}
+		value := *m.valueOf(item)
+		elem := &element[K, V]{keyHash: m.hasher(item.key), key: item.key}
+		elem.expiry.Store(item.expiry.Load())
+		elem.ttl.Store(item.ttl.Load())
+		elem.version.Store(item.version.Load())
+		elem.value.Store(&value)
+		elements = append(elements, elem)
+	}
+	// stable so that keys which became equal keep the first key and the last value, like re-inserting them in list
+	// order would
+	sort.SliceStable(elements, func(i, j int) bool {
+		return elements[i].keyHash < elements[j].keyHash
+	})
+
+	var (
+		tail  = m.listHead
+		first = 0 // first element of the run of elements sharing the hash of the tail
+		count uintptr
+	)
+	for idx, elem := range elements {
+		if tail != m.listHead && elem.keyHash == tail.keyHash {
+			duplicate := false
+			for _, prev := range elements[first:idx] {
+				if (prev.nextPtr.Load() != nil || prev == tail) && m.equal(prev.key, elem.key) { // linked
+					m.changes.removed(elem.key)
+					prev.value.Store(elem.value.Load())
+					prev.expiry.Store(elem.expiry.Load())
+					prev.ttl.Store(elem.ttl.Load())
+					prev.version.Store(elem.version.Load())
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				continue
+			}
+		} else {
+			first = idx
+		}
+		tail.nextPtr.Store(elem)
+		tail = elem
+		count++
+	}
+	m.numItems.Store(count)
+
+	data := m.metadata.Load()
+	if size := fitSize(count, m.maxFillRate); roundUpPower2(size) > uintptr(len(data.index)) {
+		m.GrowAndWait(size) // re-indexes the list while growing
+	} else {
+		m.fillIndexItems(data)
+	}
+}
+
+// copyIf returns a new map with the same configuration and at least the given index size holding the entries
+// which match the predicate, or all entries if the predicate is nil
+func (m *Map[K, V]) copyIf(size uintptr, predicate func(K, V) bool) *Map[K, V] {
+	dst := NewLike(m)
+	if size > dst.defaultSize {
+		dst.Grow(size)
+	}
+	var (
+		tail  = dst.listHead
+		count uintptr
+	)
+	// the list is already sorted in ascending order of hash hence elements are appended as is
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		value := *m.valueOf(item)
+		if predicate != nil && !predicate(item.key, value) {
+			continue
+		}
+		elem := &element[K, V]{keyHash: item.keyHash, key: item.key}
+		if expiry := item.expiry.Load(); expiry != 0 {
+			elem.expiry.Store(expiry)
+			elem.ttl.Store(item.ttl.Load())
+			dst.expiries.Store(hasExpiries)
+		}
+		elem.value.Store(&value)
+		tail.nextPtr.Store(elem)
+		tail = elem
+		count++
+	}
+	dst.numItems.Store(count)
+	if data := dst.metadata.Load(); resizeNeeded(uintptr(len(data.index)), count, dst.maxFillRate) {
+		dst.Grow(0) // re-indexes the list while growing
+	} else {
+		dst.fillIndexItems(data)
+	}
+	return dst
+}
+
 // fillIndexItems re-indexes the map given the latest state of the linked list
 func (m *Map[K, V]) fillIndexItems(mapData *metadata[K, V]) {
 	var (
@@ -428,8 +1350,76 @@ func (m *Map[K, V]) fillIndexItems(mapData *metadata[K, V]) {
 	}
 }
 
+// fillIndexParallel re-indexes the map like fillIndexItems with one goroutine per range of hashes, every worker filling
+// a disjoint range of slots of the new index
+// A worker reaches the first element of its range through the current index, which stays valid while the new one is
+// filled, instead of walking the list from its head
+func (m *Map[K, V]) fillIndexParallel(mapData, current *metadata[K, V], workers int) {
+	slots := uintptr(len(mapData.index))
+	if uintptr(workers) > slots {
+		workers = int(slots)
+	}
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		var (
+			lower = (uintptr(worker) * slots / uintptr(workers)) << mapData.keyshifts
+			upper = (uintptr(worker+1) * slots / uintptr(workers)) << mapData.keyshifts
+			last  = worker == workers-1 // the last range is unbounded as its upper bound overflows
+		)
+		wg.Add(1)
+		go m.labeled("resize", func() {
+			defer wg.Done()
+			item := current.indexElement(lower)
+			if item == nil || item.keyHash > lower {
+				item = m.listHead.next()
+			}
+			for ; item != nil && item.keyHash < lower; item = item.next() {
+			}
+			lastIndex := ^uintptr(0)
+			for ; item != nil && (last || item.keyHash < upper); item = item.next() {
+				if index := item.keyHash >> mapData.keyshifts; index != lastIndex {
+					mapData.addItemToIndex(item)
+					lastIndex = index
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// removeIf marks the element for deletion only if its value is still old, on behalf of CompareAndDelete and Compute
+// The value is swapped for the tombstone in the same step as it is compared, so that a concurrent write either lands
+// beforehand and fails the comparison, or finds the tombstone and retries on a new element, see element.store
+// The old value is restored once the element is marked, for the readers and hooks still holding the element
+func (m *Map[K, V]) removeIf(elem *element[K, V], old *V) bool {
+	if !elem.value.CompareAndSwap(old, m.tomb) {
+		return false
+	}
+	removed := elem.remove()
+	elem.value.Store(old)
+	return removed
+}
+
+// valueOf returns the pointer to the value of an element, waiting for the value of an element claimed for deletion
+// to be restored as the tombstone is swapped back for the old value right after marking the element, see removeIf
+func (m *Map[K, V]) valueOf(elem *element[K, V]) *V {
+	value := elem.value.Load()
+	for value == m.tomb {
+		runtime.Gosched()
+		value = elem.value.Load()
+	}
+	return value
+}
+
 // removeItemFromIndex removes an item from the map index
+// removed elements are recorded as deleted if changes are tracked and reported to the deletion hook
 func (m *Map[K, V]) removeItemFromIndex(item *element[K, V]) {
+	m.changes.removed(item.key)
+	m.count(OpDelete)
+	if m.onDelete != nil {
+		m.onDelete(item.key, *m.valueOf(item))
+	}
+	defer m.unlink(item)
 	for {
 		data := m.metadata.Load()
 		index := item.keyHash >> data.keyshifts
@@ -442,25 +1432,70 @@ func (m *Map[K, V]) removeItemFromIndex(item *element[K, V]) {
 		swappedToNil := atomic.CompareAndSwapPointer(ptr, unsafe.Pointer(item), unsafe.Pointer(next)) && next == nil
 
 		if data == m.metadata.Load() { // check that no resize happened
-			m.numItems.Add(^uintptr(0)) // decrement counter
-			if swappedToNil {           // decrement the metadata count if the index is set to nil
+			count := m.numItems.Add(^uintptr(0)) // decrement counter
+			if swappedToNil {                    // decrement the metadata count if the index is set to nil
 				data.count.Add(^uintptr(0))
 			}
+			if m.minFillRate > 0 && shrinkNeeded(uintptr(len(data.index)), count, m.minFillRate) &&
+				uintptr(len(data.index)) > roundUpPower2(m.defaultSize) && m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+				m.shrink()
+			}
 			return
 		}
 	}
 }
 
+// unlink physically unlinks a deleted element from the list right away instead of leaving it to the next traversal
+// passing over it, so that it no longer retains its key and value once the readers holding it are done
+// The list is traversed from the closest preceding element of the index, whose next() calls unlink the deleted
+// elements met on the way, hence this usually visits a few elements only
+// Memory is reclaimed safely by the garbage collector, a concurrent reader positioned on the element can still move
+// on to its successor as a deleted element keeps pointing to it through its marker, and no concurrent insertion
+// after the element is lost as marked elements refuse them, see element.mark
+func (m *Map[K, V]) unlink(item *element[K, V]) {
+	start := m.metadata.Load().indexElement(item.keyHash)
+	if start == nil || start == item || start.keyHash > item.keyHash {
+		start = m.listHead
+	}
+	for elem := start; elem != nil && elem.keyHash <= item.keyHash; elem = elem.next() {
+	}
+}
+
+// shrink rebuilds the index with the smallest size, no smaller than the initial size, which keeps the fill rate
+// at most at half of the maximum fill rate so that subsequent insertions do not immediately grow it again
+func (m *Map[K, V]) shrink() {
+	size := roundUpPower2(fitSize(m.Len()*2, m.maxFillRate))
+	if initialSize := roundUpPower2(m.defaultSize); size < initialSize {
+		size = initialSize
+	}
+	if size >= uintptr(len(m.metadata.Load().index)) {
+		m.resizing.Store(notResizing)
+		return
+	}
+	m.grow(size)
+}
+
+// waitResize blocks until no other resize operation is in progress and marks a new one as in progress
+func (m *Map[K, V]) waitResize() {
+	for !m.resizing.CompareAndSwap(notResizing, resizingInProgress) {
+		runtime.Gosched()
+	}
+}
+
 // grow to the new size
 func (m *Map[K, V]) grow(newSize uintptr) {
 	for {
 		currentStore := m.metadata.Load()
 		if newSize == 0 {
-			newSize = uintptr(len(currentStore.index)) << 1
+			newSize = uintptr(len(currentStore.index)) << m.growthShift
 		} else {
 			newSize = roundUpPower2(newSize)
 		}
 
+		var finished func()
+		if currentStore != nil && (m.instrument != nil || m.onResize != nil) { // not the initial allocation
+			finished = m.resizeStarted(uintptr(len(currentStore.index)), newSize)
+		}
 		index := make([]*element[K, V], newSize)
 		header := (*reflect.SliceHeader)(unsafe.Pointer(&index))
 
@@ -470,18 +1505,43 @@ func (m *Map[K, V]) grow(newSize uintptr) {
 			index:     index,
 		}
 
-		m.fillIndexItems(newdata) // re-index with longer and more widespread keys
+		// re-index with longer and more widespread keys
+		if workers := runtime.GOMAXPROCS(0); workers > 1 && currentStore != nil && m.Len() >= parallelIndexMin {
+			m.fillIndexParallel(newdata, currentStore, workers)
+		} else {
+			m.fillIndexItems(newdata)
+		}
 		m.metadata.Store(newdata)
+		if currentStore != nil {
+			m.stats.resized()
+		}
+		if finished != nil {
+			finished()
+		}
 
-		if !resizeNeeded(newSize, uintptr(m.Len())) {
+		if !resizeNeeded(newSize, uintptr(m.Len()), m.maxFillRate) {
 			m.resizing.Store(notResizing)
 			return
 		}
-		newSize = 0 // 0 means double the current size
+		newSize = 0 // 0 means grow the current size by the growth factor
+	}
+}
+
+// seek returns the element from which a lookup of the hash traverses the list, the closest element of the index
+// preceding the hash or else the first element of the list
+// The index is only a shortcut into the list, which holds every entry as soon as it is inserted, whereas an index
+// swapped in by a resize lacks the entries inserted while it was filled, so lookups must not give up on the key when
+// the index has no element preceding its hash
+func (m *Map[K, V]) seek(data *metadata[K, V], h uintptr) *element[K, V] {
+	if elem := data.indexElement(h); elem != nil && elem.keyHash <= h {
+		return elem
 	}
+	return m.listHead.next()
 }
 
 // indexElement returns the index of a hash key, returns `nil` if absent
+// A deleted element is never returned either, the first slot of the index might still hold one until it is replaced
+// and the entries inserted after its deletion are not reachable from it
 func (md *metadata[K, V]) indexElement(hashedKey uintptr) *element[K, V] {
 	index := hashedKey >> md.keyshifts
 	ptr := (*unsafe.Pointer)(unsafe.Pointer(uintptr(md.data) + index*intSizeBytes))
@@ -491,6 +1551,9 @@ func (md *metadata[K, V]) indexElement(hashedKey uintptr) *element[K, V] {
 		ptr = (*unsafe.Pointer)(unsafe.Pointer(uintptr(md.data) + index*intSizeBytes))
 		item = (*element[K, V])(atomic.LoadPointer(ptr))
 	}
+	if item != nil && item.isDeleted() {
+		return nil
+	}
 	return item
 }
 
@@ -516,8 +1579,18 @@ func (md *metadata[K, V]) addItemToIndex(item *element[K, V]) uintptr {
 }
 
 // check if resize is needed
-func resizeNeeded(length, count uintptr) bool {
-	return (count*100)/length > maxFillRate
+func resizeNeeded(length, count, fillRate uintptr) bool {
+	return (count*100)/length > fillRate
+}
+
+// check if shrinking is needed
+func shrinkNeeded(length, count, minFillRate uintptr) bool {
+	return (count*100)/length < minFillRate
+}
+
+// fitSize returns the index size required to hold count items without exceeding the given fill rate
+func fitSize(count, fillRate uintptr) uintptr {
+	return count*100/fillRate + 1
 }
 
 // roundUpPower2 rounds a number to the next power of 2
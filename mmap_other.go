@@ -0,0 +1,18 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package haxmap
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmapUnsupported = errors.New("haxmap: memory-mapped stores are not supported on this platform")
+
+func mapFile(*os.File, uint64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func unmapFile([]byte) error {
+	return errMmapUnsupported
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package haxmap
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of the file read-only, the mapping may extend past the end of the file
+func mapFile(f *os.File, size uint64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
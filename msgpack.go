@@ -0,0 +1,360 @@
+package haxmap
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+type (
+	// msgpackMarshaler is the msgpack.Marshaler interface of github.com/vmihailenco/msgpack
+	msgpackMarshaler interface {
+		MarshalMsgpack() ([]byte, error)
+	}
+
+	// msgpackUnmarshaler is the msgpack.Unmarshaler interface of github.com/vmihailenco/msgpack
+	msgpackUnmarshaler interface {
+		UnmarshalMsgpack([]byte) error
+	}
+
+	// msgpackDecoder decodes MessagePack objects from a buffer
+	msgpackDecoder struct {
+		data []byte
+		off  int
+	}
+)
+
+var (
+	msgpackMarshalerType   = reflect.TypeOf((*msgpackMarshaler)(nil)).Elem()
+	msgpackUnmarshalerType = reflect.TypeOf((*msgpackUnmarshaler)(nil)).Elem()
+)
+
+// MarshalMsgpack implements the msgpack.Marshaler interface of github.com/vmihailenco/msgpack.
+// The map is encoded as a MessagePack map without requiring any dependency: keys and values implementing the
+// interface are embedded as is, encoding.BinaryMarshaler types are encoded as binary, structs are encoded as maps of
+// their exported fields named by their `msgpack` tag if any and other types are encoded according to their kind
+// The entries are collected in a single pass over the list, expired entries are skipped
+func (m *Map[K, V]) MarshalMsgpack() ([]byte, error) {
+	m.initialize()
+	var items []*element[K, V]
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		items = append(items, item)
+	}
+	dst := appendMsgpackLength(nil, len(items), 0x80, 15, 0, 0xde, 0xdf)
+	var err error
+	for _, item := range items {
+		if dst, err = appendMsgpack(dst, reflect.ValueOf(&item.key).Elem()); err != nil {
+			return nil, err
+		}
+		if dst, err = appendMsgpack(dst, reflect.ValueOf(m.valueOf(item)).Elem()); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// UnmarshalMsgpack implements the msgpack.Unmarshaler interface of github.com/vmihailenco/msgpack.
+// Keys and values are decoded following the rules of MarshalMsgpack, entries already present are kept unless
+// overwritten and a nil object leaves the map as is
+// All pairs are decoded first and inserted with SetMany, which grows the map once to fit them
+func (m *Map[K, V]) UnmarshalMsgpack(data []byte) error {
+	m.initialize()
+	dec := &msgpackDecoder{data: data}
+	if code, err := dec.peek(); err != nil || code == 0xc0 {
+		return err
+	}
+	n, err := dec.mapLength()
+	if err != nil {
+		return err
+	}
+	pairs := make([]Pair[K, V], n)
+	for idx := range pairs {
+		if err = decodeValue(dec, reflect.ValueOf(&pairs[idx].Key).Elem()); err != nil {
+			return err
+		}
+		if err = decodeValue(dec, reflect.ValueOf(&pairs[idx].Value).Elem()); err != nil {
+			return err
+		}
+	}
+	m.SetMany(pairs...)
+	return nil
+}
+
+// appendMsgpack appends the MessagePack encoding of a value
+func appendMsgpack(dst []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface || v.Kind() == reflect.Map ||
+		v.Kind() == reflect.Slice) && v.IsNil()) {
+		return append(dst, 0xc0), nil
+	}
+	if v.Type().Implements(msgpackMarshalerType) {
+		data, err := v.Interface().(msgpackMarshaler).MarshalMsgpack()
+		return append(dst, data...), err
+	}
+	if v.Type().Implements(binaryMarshalerType) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		return append(appendMsgpackLength(dst, len(data), 0, 0, 0xc4, 0xc5, 0xc6), data...), err
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, 0xc3), nil
+		}
+		return append(dst, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(dst, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(dst, v.Uint()), nil
+	case reflect.Float32:
+		return appendBigEndian(append(dst, 0xca), uint64(math.Float32bits(float32(v.Float()))), 4), nil
+	case reflect.Float64:
+		return appendBigEndian(append(dst, 0xcb), math.Float64bits(v.Float()), 8), nil
+	case reflect.String:
+		return append(appendMsgpackLength(dst, v.Len(), 0xa0, 31, 0xd9, 0xda, 0xdb), v.String()...), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dst = appendMsgpackLength(dst, v.Len(), 0, 0, 0xc4, 0xc5, 0xc6)
+			for i := 0; i < v.Len(); i++ {
+				dst = append(dst, byte(v.Index(i).Uint()))
+			}
+			return dst, nil
+		}
+		dst = appendMsgpackLength(dst, v.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len() && err == nil; i++ {
+			dst, err = appendMsgpack(dst, v.Index(i))
+		}
+		return dst, err
+	case reflect.Map:
+		dst = appendMsgpackLength(dst, v.Len(), 0x80, 15, 0, 0xde, 0xdf)
+		for iter := v.MapRange(); iter.Next() && err == nil; {
+			if dst, err = appendMsgpack(dst, iter.Key()); err == nil {
+				dst, err = appendMsgpack(dst, iter.Value())
+			}
+		}
+		return dst, err
+	case reflect.Struct:
+		var fields []structField
+		for _, field := range taggedFields(v, "msgpack") {
+			if !field.omitEmpty || !field.value.IsZero() {
+				fields = append(fields, field)
+			}
+		}
+		dst = appendMsgpackLength(dst, len(fields), 0x80, 15, 0, 0xde, 0xdf)
+		for _, field := range fields {
+			dst = append(appendMsgpackLength(dst, len(field.name), 0xa0, 31, 0xd9, 0xda, 0xdb), field.name...)
+			if dst, err = appendMsgpack(dst, field.value); err != nil {
+				return dst, err
+			}
+		}
+		return dst, nil
+	case reflect.Pointer, reflect.Interface:
+		return appendMsgpack(dst, v.Elem())
+	}
+	return dst, fmt.Errorf("haxmap: unsupported msgpack type %s", v.Type())
+}
+
+func appendMsgpackInt(dst []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(dst, uint64(i))
+	case i >= -32:
+		return append(dst, byte(i)) // negative fixint
+	case i >= math.MinInt8:
+		return append(dst, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendBigEndian(append(dst, 0xd1), uint64(i), 2)
+	case i >= math.MinInt32:
+		return appendBigEndian(append(dst, 0xd2), uint64(i), 4)
+	}
+	return appendBigEndian(append(dst, 0xd3), uint64(i), 8)
+}
+
+func appendMsgpackUint(dst []byte, u uint64) []byte {
+	switch {
+	case u <= math.MaxInt8:
+		return append(dst, byte(u)) // positive fixint
+	case u <= math.MaxUint8:
+		return append(dst, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendBigEndian(append(dst, 0xcd), u, 2)
+	case u <= math.MaxUint32:
+		return appendBigEndian(append(dst, 0xce), u, 4)
+	}
+	return appendBigEndian(append(dst, 0xcf), u, 8)
+}
+
+// appendMsgpackLength appends the header of a string, binary, array or map of length n
+// lengths up to fixMax are held by the fix code itself, a zero code means the format has no such variant
+func appendMsgpackLength(dst []byte, n int, fix byte, fixMax int, code8, code16, code32 byte) []byte {
+	switch {
+	case fix != 0 && n <= fixMax:
+		return append(dst, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		return append(dst, code8, byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(dst, code16), uint64(n), 2)
+	}
+	return appendBigEndian(append(dst, code32), uint64(n), 4)
+}
+
+func (d *msgpackDecoder) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return d.data[d.off], nil
+}
+
+// read consumes the next n bytes
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	d.off += n
+	return d.data[d.off-n : d.off], nil
+}
+
+// uint reads a big endian unsigned integer of n bytes
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, err
+}
+
+// length reads a length of n bytes
+func (d *msgpackDecoder) length(n int) (int, error) {
+	u, err := d.uint(n)
+	if u > math.MaxInt32 {
+		return 0, io.ErrUnexpectedEOF // longer than any buffer the length could refer to
+	}
+	return int(u), err
+}
+
+// unmarshal hands the raw next object to the UnmarshalMsgpack method of v if it implements it
+func (d *msgpackDecoder) unmarshal(v reflect.Value) (bool, error) {
+	if !v.CanAddr() || !v.Addr().Type().Implements(msgpackUnmarshalerType) {
+		return false, nil
+	}
+	start := d.off
+	if _, err := d.decodeAny(); err != nil {
+		return true, err
+	}
+	return true, v.Addr().Interface().(msgpackUnmarshaler).UnmarshalMsgpack(d.data[start:d.off])
+}
+
+// nextNil consumes the next object if it is nil
+func (d *msgpackDecoder) nextNil() (bool, error) {
+	code, err := d.peek()
+	if err == nil && code == 0xc0 {
+		d.off++
+		return true, nil
+	}
+	return false, err
+}
+
+// nextBytes reports whether the next object is a binary or a string
+func (d *msgpackDecoder) nextBytes() bool {
+	code, _ := d.peek()
+	return code == 0xc4 || code == 0xc5 || code == 0xc6 || code&0xe0 == 0xa0 || code == 0xd9 || code == 0xda || code == 0xdb
+}
+
+func (d *msgpackDecoder) format() string {
+	return "msgpack"
+}
+
+func (d *msgpackDecoder) fieldTags() []string {
+	return []string{"msgpack"}
+}
+
+// arrayLength reads the header of an array
+func (d *msgpackDecoder) arrayLength() (int, error) {
+	return d.containerLength(0x90, 0xdc, 1)
+}
+
+// mapLength reads the header of a map
+func (d *msgpackDecoder) mapLength() (int, error) {
+	return d.containerLength(0x80, 0xde, 2)
+}
+
+// containerLength reads the header of an array or a map given its fix code and its 16 bits length code
+// the length is checked against the remaining data as every entry holds objects of at least a byte each
+func (d *msgpackDecoder) containerLength(fix, code16 byte, objects int) (n int, err error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	switch code := b[0]; {
+	case code&0xf0 == fix:
+		n = int(code & 0x0f)
+	case code == code16 || code == code16+1:
+		if n, err = d.length(2 << (code - code16)); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("haxmap: unexpected msgpack code 0x%x", code)
+	}
+	if n*objects > len(d.data)-d.off {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// decodeAny decodes the next object into a generic value: nil, bool, int64, uint64, float32, float64, string,
+// []byte, []any and map[string]any or map[any]any depending on the keys
+func (d *msgpackDecoder) decodeAny() (any, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	switch code := b[0]; {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		data, err := d.read(int(code & 0x1f))
+		return string(data), err
+	case code&0xf0 == 0x90 || code == 0xdc || code == 0xdd:
+		d.off-- // read again as the header
+		return decodeAnyArray(d)
+	case code&0xf0 == 0x80 || code == 0xde || code == 0xdf:
+		d.off--
+		return decodeAnyMap(d)
+	case code == 0xc0:
+		return nil, nil
+	case code == 0xc2 || code == 0xc3:
+		return code == 0xc3, nil
+	case code >= 0xc4 && code <= 0xc6:
+		n, err := d.length(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(n)
+		return append([]byte(nil), data...), err
+	case code == 0xca:
+		u, err := d.uint(4)
+		return math.Float32frombits(uint32(u)), err
+	case code == 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case code >= 0xcc && code <= 0xcf:
+		return d.uint(1 << (code - 0xcc))
+	case code >= 0xd0 && code <= 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := d.uint(size)
+		return int64(u<<(64-8*size)) >> (64 - 8*size), err // sign extended
+	case code >= 0xd9 && code <= 0xdb:
+		n, err := d.length(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(n)
+		return string(data), err
+	}
+	return nil, fmt.Errorf("haxmap: unsupported msgpack code 0x%x", b[0])
+}
@@ -0,0 +1,74 @@
+package haxmap
+
+// MultiMap implements a concurrent map holding multiple values per key on top of Map
+// The values of a key are stored in an immutable slice which is replaced atomically upon every update
+// hence concurrent appends to the same key never lose values and readers never observe a partial update
+type MultiMap[K hashable, V any] struct {
+	m *Map[K, []V]
+}
+
+// NewMultiMap returns a new MultiMap instance with an optional specific initialization size
+func NewMultiMap[K hashable, V any](size ...uintptr) *MultiMap[K, V] {
+	return &MultiMap[K, V]{m: New[K, []V](size...)}
+}
+
+// Append adds values to the key, after the values already present
+func (mm *MultiMap[K, V]) Append(key K, values ...V) {
+	if len(values) == 0 {
+		return
+	}
+	mm.m.Compute(key, func(old []V, _ bool) ([]V, bool) {
+		// copy on write as readers might hold the old slice
+		updated := make([]V, len(old), len(old)+len(values))
+		copy(updated, old)
+		return append(updated, values...), false
+	})
+}
+
+// GetAll returns a copy of the values of the key in insertion order, nil if the key is absent
+func (mm *MultiMap[K, V]) GetAll(key K) []V {
+	values, ok := mm.m.Get(key)
+	if !ok {
+		return nil
+	}
+	return append([]V(nil), values...)
+}
+
+// RemoveValue removes the values of the key matching the predicate and returns the number of removed values
+// The key is deleted once it holds no value
+func (mm *MultiMap[K, V]) RemoveValue(key K, predicate func(V) bool) (removed int) {
+	mm.m.Compute(key, func(old []V, loaded bool) ([]V, bool) {
+		removed = 0 // valueFn might be retried under contention
+		if !loaded {
+			return nil, true
+		}
+		kept := make([]V, 0, len(old))
+		for _, value := range old {
+			if predicate(value) {
+				removed++
+			} else {
+				kept = append(kept, value)
+			}
+		}
+		return kept, len(kept) == 0
+	})
+	return
+}
+
+// Del deletes the keys along with all their values
+func (mm *MultiMap[K, V]) Del(keys ...K) {
+	mm.m.Del(keys...)
+}
+
+// Len returns the number of keys within the map
+func (mm *MultiMap[K, V]) Len() uintptr {
+	return mm.m.Len()
+}
+
+// ForEach iterates over keys and executes the lambda provided for each key and a copy of its values
+// lambda must return `true` to continue iteration and `false` to break iteration
+func (mm *MultiMap[K, V]) ForEach(lambda func(K, []V) bool) {
+	mm.m.ForEach(func(key K, values []V) bool {
+		return lambda(key, append([]V(nil), values...))
+	})
+}
@@ -0,0 +1,35 @@
+package haxmap
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// SetName names the map so that it can be told apart from other maps of the process
+// The goroutines started by the map, namely the janitor, Stream and the workers of ForEachParallel and of resizes of
+// large maps, carry the pprof labels haxmap=<name> and haxmap_task=<task>, which show up in goroutine dumps and CPU
+// profiles
+// Resizes run on the goroutine of the writer triggering them, they are identified by the Name of their ResizeEvent
+// Goroutines already running keep their labels
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) SetName(name string) {
+	m.initialize()
+	m.name = name
+}
+
+// Name returns the name of the map set with SetName, empty if unnamed
+func (m *Map[K, V]) Name() string {
+	return m.name
+}
+
+// labeled runs the task with the pprof labels of the map if it is named, it is meant to be the body of the goroutines
+// started by the map
+func (m *Map[K, V]) labeled(task string, run func()) {
+	if m.name == "" {
+		run()
+		return
+	}
+	pprof.Do(context.Background(), pprof.Labels("haxmap", m.name, "haxmap_task", task), func(context.Context) {
+		run()
+	})
+}
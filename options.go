@@ -0,0 +1,288 @@
+package haxmap
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// Option configures a map created with NewWithOptions
+	Option func(*options)
+
+	// options collected from the Option list, typed settings are stored as `any` and asserted in NewWithOptions
+	options struct {
+		size         uintptr
+		hasher       any // func(K) uintptr
+		seed         *uint64
+		algorithm    HashAlgorithm
+		maxFillRate  uintptr
+		minFillRate  uintptr
+		growthFactor uintptr
+		maxEntries   uintptr
+		evictor      any // Evictor[K, V]
+		loader       any // func(K) (V, error)
+		keyEqual     any // func(a, b K) bool
+		onEvict      any // func(K, V, Reason)
+		onInsert     any // func(K, V)
+		onUpdate     any // func(K, V, V)
+		onDelete     any // func(K, V)
+		sliding      bool
+		changes      bool
+		stats        bool
+		sortedCBOR   bool
+		instrument   Instrumentation
+		onResize     func(ResizeEvent)
+		janitor      time.Duration
+		maxPerSweep  int
+		name         string
+	}
+)
+
+// NewWithOptions returns a new HashMap instance configured with the given options
+// Options carrying typed functions, like WithHasher, must match the key and value types of the map else it panics
+func NewWithOptions[K hashable, V any](opts ...Option) *Map[K, V] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := New[K, V](o.size)
+	if o.name != "" {
+		m.SetName(o.name)
+	}
+	if o.seed != nil {
+		m.seed = *o.seed
+	}
+	if o.seed != nil || o.algorithm != XXHash {
+		m.SetHashAlgorithm(o.algorithm)
+	}
+	if o.hasher != nil {
+		m.SetHasher(assertOption[func(K) uintptr]("WithHasher", o.hasher))
+	}
+	if o.keyEqual != nil {
+		m.SetKeyEqual(assertOption[func(a, b K) bool]("WithKeyEqual", o.keyEqual))
+	}
+	if o.maxFillRate > 0 {
+		m.SetMaxFillRate(o.maxFillRate)
+	}
+	if o.minFillRate > 0 {
+		m.SetMinFillRate(o.minFillRate)
+	}
+	if o.growthFactor > 0 {
+		m.SetGrowthFactor(o.growthFactor)
+	}
+	if o.maxEntries > 0 {
+		var evictor Evictor[K, V]
+		if o.evictor != nil {
+			evictor = assertOption[Evictor[K, V]]("WithEvictor", o.evictor)
+		}
+		m.SetMaxEntries(o.maxEntries, evictor)
+	}
+	if o.loader != nil {
+		m.SetLoader(assertOption[func(K) (V, error)]("WithLoader", o.loader))
+	}
+	if o.onEvict != nil {
+		m.OnEvict(assertOption[func(K, V, Reason)]("WithOnEvict", o.onEvict))
+	}
+	if o.onInsert != nil {
+		m.OnInsert(assertOption[func(K, V)]("WithOnInsert", o.onInsert))
+	}
+	if o.onUpdate != nil {
+		m.OnUpdate(assertOption[func(K, V, V)]("WithOnUpdate", o.onUpdate))
+	}
+	if o.onDelete != nil {
+		m.OnDelete(assertOption[func(K, V)]("WithOnDelete", o.onDelete))
+	}
+	if o.sliding {
+		m.SetSlidingExpiration(true)
+	}
+	if o.changes {
+		m.EnableChangeTracking()
+	}
+	if o.stats {
+		m.EnableStats()
+	}
+	if o.instrument != nil {
+		m.SetInstrumentation(o.instrument)
+	}
+	if o.onResize != nil {
+		m.OnResize(o.onResize)
+	}
+	if o.sortedCBOR {
+		m.SetDeterministicCBOR(true)
+	}
+	if o.janitor > 0 {
+		m.SetJanitor(o.janitor, o.maxPerSweep)
+	}
+	return m
+}
+
+// WithInitialSize sets the initial size of the map, see New
+func WithInitialSize(size uintptr) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// WithHasher sets the hash function of the map, see SetHasher
+func WithHasher[K hashable](hasher func(K) uintptr) Option {
+	return func(o *options) {
+		o.hasher = hasher
+	}
+}
+
+// WithSeed fixes the seed of the default hasher instead of a random one, see SetSeed
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seed = &seed
+	}
+}
+
+// WithHashAlgorithm selects the algorithm of the default hasher, see SetHashAlgorithm
+func WithHashAlgorithm(algorithm HashAlgorithm) Option {
+	return func(o *options) {
+		o.algorithm = algorithm
+	}
+}
+
+// WithKeyEqual sets the function comparing keys instead of ==, see SetKeyEqual
+func WithKeyEqual[K hashable](equal func(a, b K) bool) Option {
+	return func(o *options) {
+		o.keyEqual = equal
+	}
+}
+
+// WithMaxFillRate sets the fill rate above which the map grows, see SetMaxFillRate
+func WithMaxFillRate(rate uintptr) Option {
+	return func(o *options) {
+		o.maxFillRate = rate
+	}
+}
+
+// WithMinFillRate enables automatic shrinking below the given fill rate, see SetMinFillRate
+func WithMinFillRate(rate uintptr) Option {
+	return func(o *options) {
+		o.minFillRate = rate
+	}
+}
+
+// WithGrowthFactor sets the factor by which the map grows, see SetGrowthFactor
+func WithGrowthFactor(factor uintptr) Option {
+	return func(o *options) {
+		o.growthFactor = factor
+	}
+}
+
+// WithMaxEntries bounds the number of entries in the map, insertions into a full map are rejected
+// unless an evictor is set with WithEvictor, see SetMaxEntries
+func WithMaxEntries(limit uintptr) Option {
+	return func(o *options) {
+		o.maxEntries = limit
+	}
+}
+
+// WithEvictor sets the evictor picking entries to evict when a map bounded with WithMaxEntries is full
+func WithEvictor[K hashable, V any](evictor Evictor[K, V]) Option {
+	return func(o *options) {
+		o.evictor = evictor
+	}
+}
+
+// WithLoader sets a read-through loader invoked upon a miss, see SetLoader
+func WithLoader[K hashable, V any](loader func(K) (V, error)) Option {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// WithOnEvict sets a callback notified of entries removed by expiry, capacity eviction or Clear, see OnEvict
+func WithOnEvict[K hashable, V any](callback func(key K, value V, reason Reason)) Option {
+	return func(o *options) {
+		o.onEvict = callback
+	}
+}
+
+// WithOnInsert sets a hook called after every insertion, see OnInsert
+func WithOnInsert[K hashable, V any](hook func(key K, value V)) Option {
+	return func(o *options) {
+		o.onInsert = hook
+	}
+}
+
+// WithOnUpdate sets a hook called after every update, see OnUpdate
+func WithOnUpdate[K hashable, V any](hook func(key K, oldValue, newValue V)) Option {
+	return func(o *options) {
+		o.onUpdate = hook
+	}
+}
+
+// WithOnDelete sets a hook called after every removal, see OnDelete
+func WithOnDelete[K hashable, V any](hook func(key K, oldValue V)) Option {
+	return func(o *options) {
+		o.onDelete = hook
+	}
+}
+
+// WithSlidingExpiration makes accesses by key extend the expiry of entries, see SetSlidingExpiration
+func WithSlidingExpiration() Option {
+	return func(o *options) {
+		o.sliding = true
+	}
+}
+
+// WithChangeTracking records the writes and deletions of the map for delta snapshots, see EnableChangeTracking
+func WithChangeTracking() Option {
+	return func(o *options) {
+		o.changes = true
+	}
+}
+
+// WithStats maintains the counters reported by Stats, see EnableStats
+func WithStats() Option {
+	return func(o *options) {
+		o.stats = true
+	}
+}
+
+// WithDeterministicCBOR makes MarshalCBOR sort keys so that equal maps are encoded to equal bytes, see SetDeterministicCBOR
+func WithDeterministicCBOR() Option {
+	return func(o *options) {
+		o.sortedCBOR = true
+	}
+}
+
+// WithInstrumentation sets the instrumentation receiving the telemetry events of the map, see SetInstrumentation
+func WithInstrumentation(instrumentation Instrumentation) Option {
+	return func(o *options) {
+		o.instrument = instrumentation
+	}
+}
+
+// WithOnResize sets a callback notified when resizes of the index start and finish, see OnResize
+func WithOnResize(callback func(event ResizeEvent)) Option {
+	return func(o *options) {
+		o.onResize = callback
+	}
+}
+
+// WithName names the map in the pprof labels of its goroutines and in its resize events, see SetName
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithJanitor starts a background goroutine removing expired entries at every interval, see SetJanitor
+func WithJanitor(interval time.Duration, maxPerSweep int) Option {
+	return func(o *options) {
+		o.janitor, o.maxPerSweep = interval, maxPerSweep
+	}
+}
+
+// assertOption asserts the type of a typed option value against the types of the map being created
+func assertOption[T any](name string, value any) T {
+	typed, ok := value.(T)
+	if !ok {
+		panic(fmt.Sprintf("haxmap: %s option of type %T does not match the map, expected %T", name, value, typed))
+	}
+	return typed
+}
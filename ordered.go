@@ -0,0 +1,62 @@
+package haxmap
+
+import (
+	"sort"
+
+	"golang.org/x/exp/constraints"
+)
+
+// ordered is the subset of hashable key types which have a natural ordering
+type ordered interface {
+	constraints.Integer | constraints.Float | ~string
+}
+
+// ForEachSorted iterates over key-value pairs in ascending order of keys and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// The list itself is kept in ascending order of hash for the index to work, hence the pairs are collected in a single
+// weakly consistent pass (see ForEach) and sorted by key before the first lambda call
+// Every call therefore copies the whole map and costs O(n log n) time and O(n) memory, even if the lambda stops early
+func ForEachSorted[K ordered, V any](m *Map[K, V], lambda func(K, V) bool) {
+	for _, pair := range sortedPairs(m, func(K) bool { return true }) {
+		if !lambda(pair.Key, pair.Value) {
+			return
+		}
+	}
+}
+
+// ForEachSortedReverse iterates over key-value pairs in descending order of keys and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// Like ForEachSorted it collects and sorts the whole map on every call
+func ForEachSortedReverse[K ordered, V any](m *Map[K, V], lambda func(K, V) bool) {
+	pairs := sortedPairs(m, func(K) bool { return true })
+	for idx := len(pairs) - 1; idx >= 0 && lambda(pairs[idx].Key, pairs[idx].Value); idx-- {
+	}
+}
+
+// RangeSorted iterates over key-value pairs with `from <= key < to` in ascending order of keys
+// and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// There is no ordered index behind it: the whole map is scanned and the matching pairs are sorted on every call,
+// so it costs O(n) regardless of how narrow the range is
+func RangeSorted[K ordered, V any](m *Map[K, V], from, to K, lambda func(K, V) bool) {
+	for _, pair := range sortedPairs(m, func(key K) bool { return from <= key && key < to }) {
+		if !lambda(pair.Key, pair.Value) {
+			return
+		}
+	}
+}
+
+// sortedPairs collects the pairs whose key matches the filter sorted in ascending order of keys
+func sortedPairs[K ordered, V any](m *Map[K, V], filter func(K) bool) []Pair[K, V] {
+	m.initialize()
+	pairs := make([]Pair[K, V], 0, m.Len())
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		if filter(item.key) {
+			pairs = append(pairs, Pair[K, V]{Key: item.key, Value: *m.valueOf(item)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return pairs
+}
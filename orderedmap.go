@@ -0,0 +1,113 @@
+package haxmap
+
+import "sync"
+
+type (
+	// OrderedMap implements a concurrent hashmap which iterates over its entries in insertion order
+	// Lookups and updates of existing keys are lock-free like Map whereas insertions and deletions of keys
+	// additionally link and unlink their entry in a separate order list under a mutex
+	// Updating the value of a key keeps its position, a key inserted again after being deleted moves to the end
+	OrderedMap[K hashable, V any] struct {
+		m    *Map[K, *orderedEntry[K, V]]
+		mu   sync.Mutex // serializes modifications of the order list
+		head *orderedEntry[K, V]
+		tail *orderedEntry[K, V]
+	}
+
+	// entry of an OrderedMap threaded through the order list
+	// readers follow next atomically whereas prev and linked are only accessed under the mutex
+	orderedEntry[K hashable, V any] struct {
+		key     K
+		value   atomicPointer[V]
+		next    atomicPointer[orderedEntry[K, V]]
+		prev    *orderedEntry[K, V]
+		linked  bool
+		deleted atomicUint32
+	}
+)
+
+// NewOrderedMap returns a new OrderedMap instance with an optional specific initialization size
+func NewOrderedMap[K hashable, V any](size ...uintptr) *OrderedMap[K, V] {
+	head := &orderedEntry[K, V]{}
+	return &OrderedMap[K, V]{m: New[K, *orderedEntry[K, V]](size...), head: head, tail: head}
+}
+
+// Get retrieves an element from the map
+// returns `false` if element is absent
+func (o *OrderedMap[K, V]) Get(key K) (value V, ok bool) {
+	if entry, found := o.m.Get(key); found {
+		return *entry.value.Load(), true
+	}
+	return
+}
+
+// Set tries to update an element if key is present else it inserts a new element at the end of the order
+func (o *OrderedMap[K, V]) Set(key K, value V) {
+	if entry, ok := o.m.Get(key); ok {
+		entry.value.Store(&value)
+		return
+	}
+	entry := &orderedEntry[K, V]{key: key}
+	entry.value.Store(&value)
+	if actual, loaded := o.m.GetOrSet(key, entry); loaded {
+		actual.value.Store(&value)
+		return
+	}
+	o.mu.Lock()
+	if entry.deleted.Load() == notDeleted { // else deleted before being linked
+		entry.prev, entry.linked = o.tail, true
+		o.tail.next.Store(entry)
+		o.tail = entry
+	}
+	o.mu.Unlock()
+}
+
+// Del deletes key/keys from the map
+func (o *OrderedMap[K, V]) Del(keys ...K) {
+	for _, key := range keys {
+		entry, ok := o.m.GetAndDel(key)
+		if !ok {
+			continue
+		}
+		entry.deleted.Store(deleted)
+		o.mu.Lock()
+		if entry.linked {
+			// the next pointer of the entry is kept so that readers positioned on it can move on
+			next := entry.next.Load()
+			entry.prev.next.Store(next)
+			if next != nil {
+				next.prev = entry.prev
+			} else {
+				o.tail = entry.prev
+			}
+			entry.linked = false
+		}
+		o.mu.Unlock()
+	}
+}
+
+// Len returns the number of key-value pairs within the map
+func (o *OrderedMap[K, V]) Len() uintptr {
+	return o.m.Len()
+}
+
+// ForEach iterates over key-value pairs in insertion order and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// Like Map.ForEach, the iteration is weakly consistent with respect to concurrent writers
+func (o *OrderedMap[K, V]) ForEach(lambda func(K, V) bool) {
+	for entry := o.head.next.Load(); entry != nil; entry = entry.next.Load() {
+		if entry.deleted.Load() == notDeleted && !lambda(entry.key, *entry.value.Load()) {
+			return
+		}
+	}
+}
+
+// Keys returns a snapshot of all keys of the map in insertion order
+func (o *OrderedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, o.Len())
+	o.ForEach(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
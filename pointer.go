@@ -0,0 +1,27 @@
+package haxmap
+
+// SetPtr is like Set but stores the pointer itself instead of a copy of the value it points to, which saves copying
+// large values, a nil pointer stores the zero value
+// The map shares the value with the caller and with the readers of GetPtr, hence it must not be modified afterwards,
+// store a new value with SetPtr instead
+func (m *Map[K, V]) SetPtr(key K, value *V) {
+	m.initialize()
+	var zero V
+	m.set(key, zero, value, expiration{})
+}
+
+// GetPtr is like Get but returns a pointer to the stored value instead of a copy, which saves copying large values
+// The value is shared with the map and with other readers, hence it must not be modified, store a new value with
+// SetPtr instead
+func (m *Map[K, V]) GetPtr(key K) (*V, bool) {
+	m.initialize()
+	if elem := m.probe(key); elem != nil {
+		return m.valueOf(elem), true
+	}
+	if m.loader != nil {
+		if value, err := m.load(key, m.loader); err == nil {
+			return &value, true
+		}
+	}
+	return nil, false
+}
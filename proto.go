@@ -0,0 +1,23 @@
+package haxmap
+
+// FromProtoMap returns a new map holding the entries of a map field of a generated protobuf message, see NewFromMap
+// Built-in maps do not expose their hashes hence every key is hashed exactly once, the entries are then linked in
+// a single pass and indexed once
+// Values are copied by assignment hence message values are shared with the source map
+func FromProtoMap[K hashable, V any](src map[K]V) *Map[K, V] {
+	return NewFromMap(src)
+}
+
+// AppendToProtoMap adds the entries of m to dst, typically a map field of a generated protobuf message, and returns
+// the resulting map, which is allocated to fit all entries if dst is nil: msg.Fields = AppendToProtoMap(msg.Fields, m)
+// Existing entries of dst are overwritten by the ones of m, the list is traversed once like in ToMap
+func AppendToProtoMap[K hashable, V any](dst map[K]V, m *Map[K, V]) map[K]V {
+	m.initialize()
+	if dst == nil {
+		dst = make(map[K]V, m.Len())
+	}
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		dst[item.key] = *m.valueOf(item)
+	}
+	return dst
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package haxmap
+
+// raceEnabled tells whether the race detector is enabled, which instruments code with extra allocations
+const raceEnabled = true
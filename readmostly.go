@@ -0,0 +1,125 @@
+package haxmap
+
+import (
+	"sync"
+	"time"
+)
+
+// ReadMostly serves lookups from an immutable snapshot of a live map, refreshed periodically or by Refresh, for
+// read-mostly workloads of slowly changing data which tolerate reading data as old as the refresh interval
+// A lookup loads the pointer to the current snapshot and indexes a built-in map, without any further atomic
+// operation, whereas writes go to the live map whose changes are folded into a new snapshot upon refresh
+// The live map tracks its changes, see EnableChangeTracking, so that a refresh only collects the entries written
+// since the previous one, yet it copies the whole snapshot when anything changed hence it costs O(n)
+// Keys of the snapshot are compared with == whatever the key equality of the live map, and entries stay in the
+// snapshot until they are deleted from the live map, which happens to expired entries once the janitor removes them
+type ReadMostly[K hashable, V any] struct {
+	live     *Map[K, V]
+	snapshot atomicPointer[map[K]V]
+	mu       sync.Mutex    // serializes refreshes
+	seq      uint64        // sequence number of the changes of the live map folded into the snapshot
+	stop     chan struct{} // closed by Close to stop the background refresh, nil if disabled
+}
+
+// NewReadMostly returns a new ReadMostly instance over a live map configured with the options, whose snapshot is
+// refreshed at every interval by a background goroutine until Close is called, an interval of 0 or less disables
+// the background refresh and snapshots are only refreshed by Refresh
+func NewReadMostly[K hashable, V any](interval time.Duration, opts ...Option) *ReadMostly[K, V] {
+	r := &ReadMostly[K, V]{live: NewWithOptions[K, V](opts...)}
+	r.live.EnableChangeTracking()
+	r.Refresh()
+	if interval > 0 {
+		stop := make(chan struct{})
+		r.stop = stop
+		go r.live.labeled("refresh", func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					r.Refresh()
+				case <-stop:
+					return
+				}
+			}
+		})
+	}
+	return r
+}
+
+// Get retrieves the value of the key from the current snapshot, writes since the last refresh are not visible
+func (r *ReadMostly[K, V]) Get(key K) (value V, ok bool) {
+	value, ok = (*r.snapshot.Load())[key]
+	return
+}
+
+// Len returns the number of key-value pairs within the current snapshot
+func (r *ReadMostly[K, V]) Len() int {
+	return len(*r.snapshot.Load())
+}
+
+// ForEach iterates over the key-value pairs of the current snapshot in no particular order and executes the lambda
+// provided for each such pair, lambda must return `true` to continue iteration and `false` to break iteration
+func (r *ReadMostly[K, V]) ForEach(lambda func(K, V) bool) {
+	for key, value := range *r.snapshot.Load() {
+		if !lambda(key, value) {
+			return
+		}
+	}
+}
+
+// Set sets the value of the key in the live map, it becomes visible to Get upon the next refresh
+func (r *ReadMostly[K, V]) Set(key K, value V) {
+	r.live.Set(key, value)
+}
+
+// Del deletes key/keys from the live map, they remain visible to Get until the next refresh
+func (r *ReadMostly[K, V]) Del(keys ...K) {
+	r.live.Del(keys...)
+}
+
+// Live returns the live map, which serves up to date lookups and any write operation
+// The deleted keys recorded by its change tracking are discarded upon every refresh, see TrimChanges
+func (r *ReadMostly[K, V]) Live() *Map[K, V] {
+	return r.live
+}
+
+// Refresh folds the changes of the live map since the previous refresh into a new snapshot, which replaces the
+// current one for subsequent lookups, nothing is copied if the live map did not change
+func (r *ReadMostly[K, V]) Refresh() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delta := r.live.SnapshotSince(r.seq)
+	if !delta.Full && len(delta.Deleted) == 0 && len(delta.Set) == 0 {
+		return
+	}
+	var next map[K]V
+	if delta.Full {
+		next = make(map[K]V, len(delta.Set))
+	} else {
+		current := *r.snapshot.Load()
+		next = make(map[K]V, len(current)+len(delta.Set))
+		for key, value := range current {
+			next[key] = value
+		}
+		for _, key := range delta.Deleted {
+			delete(next, key)
+		}
+	}
+	for _, pair := range delta.Set {
+		next[pair.Key] = pair.Value
+	}
+	r.snapshot.Store(&next)
+	r.live.TrimChanges(delta.Seq)
+	r.seq = delta.Seq
+}
+
+// Close stops the background refresh, the snapshot remains readable and can still be refreshed by Refresh
+func (r *ReadMostly[K, V]) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
@@ -0,0 +1,49 @@
+package haxmap
+
+// Reduce folds all key-value pairs of the map into a single result, starting from seed, by traversing the list once
+// Like ForEach, the traversal is weakly consistent with respect to concurrent writers
+func Reduce[K hashable, V, R any](m *Map[K, V], seed R, fn func(acc R, key K, value V) R) R {
+	m.initialize()
+	acc := seed
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		acc = fn(acc, item.key, *m.valueOf(item))
+	}
+	return acc
+}
+
+// MapValues returns a new map with the same keys, hasher and index size whose values are transformed by fn
+// The list is copied in a single pass reusing the already computed hashes so no key is hashed again
+func MapValues[K hashable, V, W any](m *Map[K, V], fn func(key K, value V) W) *Map[K, W] {
+	m.initialize()
+	dst := New[K, W](m.defaultSize)
+	dst.hasher, dst.seed, dst.algorithm, dst.keyEqual = m.hasher, m.seed, m.algorithm, m.keyEqual
+	dst.maxFillRate, dst.growthShift, dst.clock = m.maxFillRate, m.growthShift, m.clock
+	if size := uintptr(len(m.metadata.Load().index)); size > dst.defaultSize {
+		dst.Grow(size)
+	}
+	var (
+		tail  = dst.listHead
+		count uintptr
+	)
+	// the list is already sorted in ascending order of hash hence elements are appended as is
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		value := fn(item.key, *m.valueOf(item))
+		elem := &element[K, W]{keyHash: item.keyHash, key: item.key}
+		if expiry := item.expiry.Load(); expiry != 0 {
+			elem.expiry.Store(expiry)
+			elem.ttl.Store(item.ttl.Load())
+			dst.expiries.Store(hasExpiries)
+		}
+		elem.value.Store(&value)
+		tail.nextPtr.Store(elem)
+		tail = elem
+		count++
+	}
+	dst.numItems.Store(count)
+	if data := dst.metadata.Load(); resizeNeeded(uintptr(len(data.index)), count, dst.maxFillRate) {
+		dst.Grow(0) // re-indexes the list while growing
+	} else {
+		dst.fillIndexItems(data)
+	}
+	return dst
+}
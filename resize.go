@@ -0,0 +1,44 @@
+package haxmap
+
+import "time"
+
+// ResizeEvent describes a resize of the index of a map, see OnResize
+type ResizeEvent struct {
+	Name     string        // name of the map, see SetName
+	Finished bool          // false when the resize starts, true once the resized index is in use
+	OldSize  uintptr       // number of index slots before the resize
+	NewSize  uintptr       // number of index slots after the resize
+	Start    time.Time     // time at which the resize started
+	Duration time.Duration // time taken by the resize, 0 when it starts
+}
+
+// OnResize sets a callback notified when a resize of the index starts and once it finishes, nil disables it
+// Growing and shrinking are both reported, the initial allocation of the index is not
+// The callback is called synchronously by the goroutine resizing the map, which might be a writer triggering
+// the resize, hence it should be fast and must not block
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) OnResize(callback func(event ResizeEvent)) {
+	m.initialize()
+	m.onResize = callback
+}
+
+// resizeStarted notifies the instrumentation and the resize callback of a resize and returns the function to call
+// once it finishes
+func (m *Map[K, V]) resizeStarted(oldSize, newSize uintptr) (finished func()) {
+	var end func()
+	if m.instrument != nil {
+		end = m.instrument.Resize(oldSize, newSize)
+	}
+	if m.onResize == nil {
+		return end
+	}
+	event := ResizeEvent{Name: m.name, OldSize: oldSize, NewSize: newSize, Start: time.Unix(0, m.clock())}
+	m.onResize(event)
+	return func() {
+		if end != nil {
+			end()
+		}
+		event.Finished, event.Duration = true, time.Duration(m.clock()-event.Start.UnixNano())
+		m.onResize(event)
+	}
+}
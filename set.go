@@ -0,0 +1,78 @@
+package haxmap
+
+// Set implements a concurrent set of keys on top of Map
+type Set[K hashable] struct {
+	m *Map[K, struct{}]
+}
+
+// NewSet returns a new Set instance with an optional specific initialization size
+func NewSet[K hashable](size ...uintptr) *Set[K] {
+	return &Set[K]{m: New[K, struct{}](size...)}
+}
+
+// Add inserts a key into the set
+// It returns a boolean indicating whether the key was absent and has been added or not
+func (s *Set[K]) Add(key K) bool {
+	return s.m.SetIfAbsent(key, struct{}{})
+}
+
+// Remove deletes a key from the set
+// It returns a boolean indicating whether the key was present and has been removed or not
+func (s *Set[K]) Remove(key K) bool {
+	_, ok := s.m.GetAndDel(key)
+	return ok
+}
+
+// Contains returns whether the key is present in the set
+func (s *Set[K]) Contains(key K) bool {
+	_, ok := s.m.Get(key)
+	return ok
+}
+
+// Len returns the number of keys within the set
+func (s *Set[K]) Len() uintptr {
+	return s.m.Len()
+}
+
+// ForEach iterates over the keys and executes the lambda provided for each such key
+// lambda must return `true` to continue iteration and `false` to break iteration
+func (s *Set[K]) ForEach(lambda func(K) bool) {
+	s.m.ForEach(func(key K, _ struct{}) bool {
+		return lambda(key)
+	})
+}
+
+// Keys returns a snapshot of all keys of the set
+func (s *Set[K]) Keys() []K {
+	return s.m.Keys()
+}
+
+// Clear removes all keys from the set
+func (s *Set[K]) Clear() {
+	s.m.Clear()
+}
+
+// Union returns a new set holding the keys present in either set
+// The new set has the same configuration as the receiver, like Clone
+func (s *Set[K]) Union(other *Set[K]) *Set[K] {
+	union := &Set[K]{m: s.m.Clone()}
+	other.ForEach(func(key K) bool {
+		union.Add(key)
+		return true
+	})
+	return union
+}
+
+// Intersect returns a new set holding the keys present in both sets
+func (s *Set[K]) Intersect(other *Set[K]) *Set[K] {
+	return &Set[K]{m: s.m.Filter(func(key K, _ struct{}) bool {
+		return other.Contains(key)
+	})}
+}
+
+// Difference returns a new set holding the keys of the receiver which are absent from the other set
+func (s *Set[K]) Difference(other *Set[K]) *Set[K] {
+	return &Set[K]{m: s.m.Filter(func(key K, _ struct{}) bool {
+		return !other.Contains(key)
+	})}
+}
@@ -0,0 +1,164 @@
+package haxmap
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// ShardedMap implements a concurrent map partitioning its keys across several maps by the most significant bits of
+// their hashes, so that concurrent writers of distinct shards neither contend on the same list nor resize the same
+// index, which scales writes on machines with many cores
+// Every shard hashes its keys with the same hasher rotated left by the number of shard bits, which moves the bits
+// selecting the shard out of the most significant bits addressing the index of the shard
+type ShardedMap[K hashable, V any] struct {
+	shards []*Map[K, V]
+	mask   uintptr // selects the shard from the rotated hash, whose least significant bits are the shard bits
+}
+
+// NewSharded returns a new ShardedMap instance with the number of shards rounded up to a power of 2
+// The options configure every shard, hence sizes and limits like WithInitialSize and WithMaxEntries apply per shard
+func NewSharded[K hashable, V any](shards int, opts ...Option) *ShardedMap[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	n := roundUpPower2(uintptr(shards))
+	var (
+		sm      = &ShardedMap[K, V]{shards: make([]*Map[K, V], n), mask: n - 1}
+		hasher  func(K) uintptr
+		rotated func(K) uintptr
+		shift   = int(log2(n))
+	)
+	for idx := range sm.shards {
+		m := NewWithOptions[K, V](opts...)
+		if hasher == nil {
+			hasher = m.hasher
+			rotated = func(key K) uintptr {
+				return uintptr(bits.RotateLeft(uint(hasher(key)), shift))
+			}
+		}
+		m.SetHasher(rotated)
+		sm.shards[idx] = m
+	}
+	return sm
+}
+
+// Shards returns the number of shards
+func (sm *ShardedMap[K, V]) Shards() int {
+	return len(sm.shards)
+}
+
+// Shard returns the map holding the keys of the shard at the given index, from 0 to Shards()-1
+func (sm *ShardedMap[K, V]) Shard(idx int) *Map[K, V] {
+	return sm.shards[idx]
+}
+
+// Get retrieves an element from the map under given hash key
+func (sm *ShardedMap[K, V]) Get(key K) (value V, ok bool) {
+	return sm.shard(key).Get(key)
+}
+
+// Set tries to update an element if key is present else it inserts a new element
+func (sm *ShardedMap[K, V]) Set(key K, value V) {
+	sm.shard(key).Set(key, value)
+}
+
+// SetIfAbsent sets the value of the key only if the key is absent, see Map.SetIfAbsent
+func (sm *ShardedMap[K, V]) SetIfAbsent(key K, value V) bool {
+	return sm.shard(key).SetIfAbsent(key, value)
+}
+
+// GetOrSet returns the existing value for the key if present
+// Otherwise, it stores and returns the given value
+// The loaded result is true if the value was loaded, false if stored
+func (sm *ShardedMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	return sm.shard(key).GetOrSet(key, value)
+}
+
+// Compute atomically updates or deletes the value of the key, see Map.Compute
+func (sm *ShardedMap[K, V]) Compute(key K, valueFn func(oldValue V, loaded bool) (newValue V, delete bool)) (V, bool) {
+	return sm.shard(key).Compute(key, valueFn)
+}
+
+// GetAndDel deletes the key from the map, returning the previous value if any
+func (sm *ShardedMap[K, V]) GetAndDel(key K) (value V, ok bool) {
+	return sm.shard(key).GetAndDel(key)
+}
+
+// Del deletes key/keys from the map
+// Several keys are grouped by shard so that every shard deletes its keys in bulk
+func (sm *ShardedMap[K, V]) Del(keys ...K) {
+	switch {
+	case len(keys) == 0:
+		return
+	case len(keys) == 1 || len(sm.shards) == 1:
+		sm.shard(keys[0]).Del(keys...)
+		return
+	}
+	groups := make([][]K, len(sm.shards))
+	for _, key := range keys {
+		idx := sm.index(key)
+		groups[idx] = append(groups[idx], key)
+	}
+	for idx, group := range groups {
+		sm.shards[idx].Del(group...)
+	}
+}
+
+// ForEach iterates over key-value pairs and executes the lambda provided for each such pair
+// lambda must return `true` to continue iteration and `false` to break iteration
+// The shards are traversed one after the other, each like Map.ForEach
+func (sm *ShardedMap[K, V]) ForEach(lambda func(K, V) bool) {
+	proceed := true
+	for _, m := range sm.shards {
+		m.ForEach(func(key K, value V) bool {
+			proceed = lambda(key, value)
+			return proceed
+		})
+		if !proceed {
+			return
+		}
+	}
+}
+
+// ForEachParallel traverses the shards concurrently, one goroutine per shard, and calls lambda for every pair
+// lambda must be safe for concurrent use
+func (sm *ShardedMap[K, V]) ForEachParallel(lambda func(K, V)) {
+	var wg sync.WaitGroup
+	for _, m := range sm.shards {
+		m := m
+		wg.Add(1)
+		go m.labeled("foreach", func() {
+			defer wg.Done()
+			m.ForEach(func(key K, value V) bool {
+				lambda(key, value)
+				return true
+			})
+		})
+	}
+	wg.Wait()
+}
+
+// Len returns the number of key-value pairs within the map, the sum of the lengths of the shards
+func (sm *ShardedMap[K, V]) Len() (count uintptr) {
+	for _, m := range sm.shards {
+		count += m.Len()
+	}
+	return
+}
+
+// Clear removes all entries from every shard
+func (sm *ShardedMap[K, V]) Clear() {
+	for _, m := range sm.shards {
+		m.Clear()
+	}
+}
+
+// shard returns the map holding the key
+func (sm *ShardedMap[K, V]) shard(key K) *Map[K, V] {
+	return sm.shards[sm.index(key)]
+}
+
+// index returns the index of the shard of the key
+func (sm *ShardedMap[K, V]) index(key K) uintptr {
+	return sm.shards[0].hasher(key) & sm.mask
+}
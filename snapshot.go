@@ -0,0 +1,424 @@
+package haxmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+// Snapshots written by WriteTo are laid out as follows, fixed size integers being little endian
+//
+//	header   "HAXM" | version byte
+//	block    uvarint payload length | payload | crc32c of the payload as uint32
+//	payload  records of uvarint key length | key | uvarint value length | value
+//	trailer  uvarint 0 | uvarint number of entries
+//
+// Keys and values are encoded according to their type: types implementing encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler are marshaled, strings and byte slices are copied as is, integers are varint encoded,
+// booleans, floats and complex numbers are written with their fixed size and other fixed size types like arrays or
+// structs of such types are written with encoding/binary
+const (
+	snapshotMagic   = "HAXM"
+	snapshotVersion = 1
+	snapshotBlock   = 64 << 10 // payload size after which a block is written
+	maxSnapshotSize = 1 << 30  // upper bound of the payload length of a block when reading
+)
+
+// ErrCorruptSnapshot is returned by ReadFrom if the snapshot is malformed or fails its checksums
+var ErrCorruptSnapshot = errors.New("haxmap: corrupt snapshot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type (
+	// binaryCodec encodes values of a type within snapshots, it is picked once per type and snapshot
+	binaryCodec[T any] struct {
+		append func(dst []byte, value T) ([]byte, error)
+		decode func(src []byte, value *T) error
+	}
+
+	// snapshotWriter counts the bytes written and keeps the first error
+	snapshotWriter struct {
+		w   io.Writer
+		n   int64
+		err error
+	}
+
+	// snapshotReader counts the bytes read
+	snapshotReader struct {
+		r interface {
+			io.Reader
+			io.ByteReader
+		}
+		n int64
+	}
+)
+
+// WriteTo implements the io.WriterTo interface.
+// It writes a binary snapshot of the entries collected in a single pass over the list, expired entries are skipped
+// and expiries are not written, the entries are framed into checksummed blocks hence a snapshot is streamed to w
+// with a bounded buffer whatever the size of the map
+// Entries set concurrently with WriteTo may or may not be part of the snapshot
+func (m *Map[K, V]) WriteTo(w io.Writer) (int64, error) {
+	m.initialize()
+	keys, err := newBinaryCodec[K]()
+	if err != nil {
+		return 0, err
+	}
+	values, err := newBinaryCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	sw := &snapshotWriter{w: w}
+	sw.write(append([]byte(snapshotMagic), snapshotVersion))
+	var (
+		payload, scratch []byte
+		count            uint64
+	)
+	for item := m.skipExpired(m.listHead.next()); item != nil && sw.err == nil; item = m.skipExpired(item.next()) {
+		if scratch, err = keys.append(scratch[:0], item.key); err != nil {
+			return sw.n, err
+		}
+		payload = append(appendUvarint(payload, uint64(len(scratch))), scratch...)
+		if scratch, err = values.append(scratch[:0], *m.valueOf(item)); err != nil {
+			return sw.n, err
+		}
+		payload = append(appendUvarint(payload, uint64(len(scratch))), scratch...)
+		count++
+		if len(payload) >= snapshotBlock {
+			sw.block(payload)
+			payload = payload[:0]
+		}
+	}
+	if len(payload) > 0 {
+		sw.block(payload)
+	}
+	sw.write(appendUvarint(appendUvarint(scratch[:0], 0), count))
+	return sw.n, sw.err
+}
+
+// ReadFrom implements the io.ReaderFrom interface.
+// It reads a snapshot written by WriteTo and inserts its entries, all blocks are verified before any entry is
+// inserted hence the map is left unchanged if the snapshot is corrupt
+// Reading into an empty map builds the list and the index in a single pass like GobDecode, it must then not be called
+// concurrently with other operations on the map, a non empty map gets the entries inserted with SetMany
+// If r does not implement io.ByteReader it is buffered, which might consume bytes past the end of the snapshot
+func (m *Map[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	m.initialize()
+	keys, err := newBinaryCodec[K]()
+	if err != nil {
+		return 0, err
+	}
+	values, err := newBinaryCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	sr := &snapshotReader{}
+	if br, ok := r.(interface {
+		io.Reader
+		io.ByteReader
+	}); ok {
+		sr.r = br
+	} else {
+		sr.r = bufio.NewReader(r)
+	}
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if err = sr.readFull(header); err != nil {
+		return sr.n, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return sr.n, ErrCorruptSnapshot
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return sr.n, fmt.Errorf("haxmap: unsupported snapshot version %d", version)
+	}
+
+	var (
+		pairs []Pair[K, V]
+		block []byte
+	)
+	for {
+		size, err := sr.readUvarint()
+		if err != nil {
+			return sr.n, err
+		}
+		if size == 0 {
+			break
+		}
+		if size > maxSnapshotSize {
+			return sr.n, ErrCorruptSnapshot
+		}
+		if block, err = sr.readBlock(block, size+4); err != nil {
+			return sr.n, err
+		}
+		payload := block[:size]
+		if crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(block[size:]) {
+			return sr.n, ErrCorruptSnapshot
+		}
+		for len(payload) > 0 {
+			var (
+				pair       Pair[K, V]
+				key, value []byte
+			)
+			if key, payload, err = nextRecord(payload); err != nil {
+				return sr.n, err
+			}
+			if value, payload, err = nextRecord(payload); err != nil {
+				return sr.n, err
+			}
+			if err = keys.decode(key, &pair.Key); err != nil {
+				return sr.n, err
+			}
+			if err = values.decode(value, &pair.Value); err != nil {
+				return sr.n, err
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	count, err := sr.readUvarint()
+	if err != nil {
+		return sr.n, err
+	}
+	if count != uint64(len(pairs)) {
+		return sr.n, ErrCorruptSnapshot
+	}
+
+	if m.Len() > 0 || m.maxEntries > 0 {
+		m.SetMany(pairs...)
+	} else {
+		m.build(pairs)
+	}
+	return sr.n, nil
+}
+
+// block writes a payload framed with its length and checksum
+func (sw *snapshotWriter) block(payload []byte) {
+	var frame [binary.MaxVarintLen64]byte
+	sw.write(frame[:binary.PutUvarint(frame[:], uint64(len(payload)))])
+	sw.write(payload)
+	binary.LittleEndian.PutUint32(frame[:4], crc32.Checksum(payload, castagnoli))
+	sw.write(frame[:4])
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sr *snapshotReader) readFull(p []byte) error {
+	n, err := io.ReadFull(sr.r, p)
+	sr.n += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // a snapshot never ends before its trailer
+	}
+	return err
+}
+
+// readBlock reads size bytes into buf, which grows by chunks of at most a block as the bytes arrive so that a
+// corrupt length does not allocate more memory than the snapshot actually holds
+func (sr *snapshotReader) readBlock(buf []byte, size uint64) ([]byte, error) {
+	buf = buf[:0]
+	for uint64(len(buf)) < size {
+		chunk := size - uint64(len(buf))
+		if chunk > snapshotBlock {
+			chunk = snapshotBlock
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if err := sr.readFull(buf[start:]); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+func (sr *snapshotReader) readUvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(sr)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return x, err
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.n++
+	}
+	return b, err
+}
+
+// nextRecord splits a length prefixed record off the payload
+func nextRecord(payload []byte) (record, rest []byte, err error) {
+	size, n := binary.Uvarint(payload)
+	if n <= 0 || size > uint64(len(payload)-n) {
+		return nil, nil, ErrCorruptSnapshot
+	}
+	return payload[n : n+int(size)], payload[n+int(size):], nil
+}
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// newBinaryCodec picks the encoding of values of type T within snapshots
+func newBinaryCodec[T any]() (c binaryCodec[T], err error) {
+	var zero T
+	if _, ok := any(zero).(encoding.BinaryMarshaler); ok {
+		if _, ok = any(&zero).(encoding.BinaryUnmarshaler); ok {
+			c.append = func(dst []byte, value T) ([]byte, error) {
+				data, err := any(value).(encoding.BinaryMarshaler).MarshalBinary()
+				return append(dst, data...), err
+			}
+			c.decode = func(src []byte, value *T) error {
+				return any(value).(encoding.BinaryUnmarshaler).UnmarshalBinary(src)
+			}
+			return
+		}
+	}
+
+	typ := reflect.TypeOf(&zero).Elem()
+	size := typ.Size()
+	switch typ.Kind() {
+	case reflect.String:
+		c.append = func(dst []byte, value T) ([]byte, error) {
+			return append(dst, *(*string)(unsafe.Pointer(&value))...), nil
+		}
+		c.decode = func(src []byte, value *T) error {
+			*(*string)(unsafe.Pointer(value)) = string(src)
+			return nil
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		c.append = func(dst []byte, value T) ([]byte, error) {
+			return append(dst, *(*[]byte)(unsafe.Pointer(&value))...), nil
+		}
+		c.decode = func(src []byte, value *T) error {
+			*(*[]byte)(unsafe.Pointer(value)) = append([]byte(nil), src...)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := 64 - 8*size
+		c.append = func(dst []byte, value T) ([]byte, error) {
+			x := int64(loadScalar(unsafe.Pointer(&value), size)<<bits) >> bits // sign extended
+			var buf [binary.MaxVarintLen64]byte
+			return append(dst, buf[:binary.PutVarint(buf[:], x)]...), nil
+		}
+		c.decode = func(src []byte, value *T) error {
+			x, n := binary.Varint(src)
+			if n != len(src) || x<<bits>>bits != x {
+				return ErrCorruptSnapshot
+			}
+			storeScalar(unsafe.Pointer(value), size, uint64(x))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		bits := 64 - 8*size
+		c.append = func(dst []byte, value T) ([]byte, error) {
+			return appendUvarint(dst, loadScalar(unsafe.Pointer(&value), size)), nil
+		}
+		c.decode = func(src []byte, value *T) error {
+			x, n := binary.Uvarint(src)
+			if n != len(src) || x<<bits>>bits != x {
+				return ErrCorruptSnapshot
+			}
+			storeScalar(unsafe.Pointer(value), size, x)
+			return nil
+		}
+	case reflect.Bool, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		// copied bit by bit in words of at most 8 bytes
+		c.append = func(dst []byte, value T) ([]byte, error) {
+			for off := uintptr(0); off < size; off += 8 {
+				word := size - off
+				if word > 8 {
+					word = 8
+				}
+				x := loadScalar(unsafe.Add(unsafe.Pointer(&value), off), word)
+				for i := uintptr(0); i < word; i++ {
+					dst = append(dst, byte(x>>(8*i)))
+				}
+			}
+			return dst, nil
+		}
+		c.decode = func(src []byte, value *T) error {
+			if uintptr(len(src)) != size {
+				return ErrCorruptSnapshot
+			}
+			for off := uintptr(0); off < size; off += 8 {
+				word := size - off
+				if word > 8 {
+					word = 8
+				}
+				var x uint64
+				for i := uintptr(0); i < word; i++ {
+					x |= uint64(src[off+i]) << (8 * i)
+				}
+				storeScalar(unsafe.Add(unsafe.Pointer(value), off), word, x)
+			}
+			return nil
+		}
+	}
+	if c.append != nil {
+		return
+	}
+
+	if binary.Size(zero) < 0 {
+		return c, fmt.Errorf("haxmap: unsupported snapshot type %s", typ)
+	}
+	c.append = func(dst []byte, value T) ([]byte, error) {
+		buf := bytes.NewBuffer(dst)
+		err := binary.Write(buf, binary.LittleEndian, value)
+		return buf.Bytes(), err
+	}
+	c.decode = func(src []byte, value *T) error {
+		if binary.Size(*value) != len(src) {
+			return ErrCorruptSnapshot
+		}
+		return binary.Read(bytes.NewReader(src), binary.LittleEndian, value)
+	}
+	return
+}
+
+// loadScalar reads a scalar of 1, 2, 4 or 8 bytes
+func loadScalar(p unsafe.Pointer, size uintptr) uint64 {
+	switch size {
+	case 1:
+		return uint64(*(*uint8)(p))
+	case 2:
+		return uint64(*(*uint16)(p))
+	case 4:
+		return uint64(*(*uint32)(p))
+	default:
+		return *(*uint64)(p)
+	}
+}
+
+// storeScalar writes a scalar of 1, 2, 4 or 8 bytes
+func storeScalar(p unsafe.Pointer, size uintptr, x uint64) {
+	switch size {
+	case 1:
+		*(*uint8)(p) = uint8(x)
+	case 2:
+		*(*uint16)(p) = uint16(x)
+	case 4:
+		*(*uint32)(p) = uint32(x)
+	default:
+		*(*uint64)(p) = x
+	}
+}
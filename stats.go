@@ -0,0 +1,121 @@
+package haxmap
+
+import "unsafe"
+
+// MemStats describes the memory footprint of a map
+// Memory referenced by keys and values, like string contents or pointed-to structs, is not accounted for
+type MemStats struct {
+	IndexSlots       uintptr // number of slots in the index
+	IndexBytes       uintptr // memory held by the index
+	Elements         uintptr // number of live entries linked in the list
+	DeletedElements  uintptr // number of deleted entries still linked in the list, see Compact()
+	ElementBytes     uintptr // memory held by a single list element including its boxed value
+	TotalBytes       uintptr // memory held by the index and all linked elements
+	OverheadPerEntry uintptr // memory used per live entry beyond the size of its key and value
+}
+
+// MemStats returns an estimate of the memory footprint of the map
+// The list is traversed without unlinking deleted elements so that they show up in the report
+func (m *Map[K, V]) MemStats() (stats MemStats) {
+	m.initialize()
+	var (
+		data    = m.metadata.Load()
+		element element[K, V]
+		value   V
+	)
+	stats.IndexSlots = uintptr(len(data.index))
+	stats.IndexBytes = stats.IndexSlots * intSizeBytes
+	stats.ElementBytes = unsafe.Sizeof(element) + unsafe.Sizeof(value)
+	for item := m.listHead.nextPtr.Load(); item != nil; item = item.nextPtr.Load() {
+		if item.marker {
+			continue // part of the deleted element it follows
+		}
+		if item.isDeleted() {
+			stats.DeletedElements++
+		} else {
+			stats.Elements++
+		}
+	}
+	stats.TotalBytes = stats.IndexBytes + (stats.Elements+stats.DeletedElements)*stats.ElementBytes
+	if stats.Elements > 0 {
+		stats.OverheadPerEntry = stats.TotalBytes/stats.Elements - unsafe.Sizeof(element.key) - unsafe.Sizeof(value)
+	}
+	return
+}
+
+// Stats describes the operations of a map since its creation, see Stats()
+type Stats struct {
+	Resizes        uintptr // number of times the index was grown or shrunk
+	Lookups        uintptr // number of lookups by Get
+	ProbeSteps     uintptr // total number of list elements visited by lookups, see AverageProbeLength
+	MaxProbeLength uintptr // largest number of list elements visited by a single lookup
+	InsertRetries  uintptr // insertions restarted from the head of the list after losing a race with a concurrent write
+	FillRate       uintptr // current fill rate of the index as a percentage, see Fillrate()
+}
+
+// opStats holds the counters reported by Stats, updated only if enabled and statsEnabled
+type opStats struct {
+	resizes  atomicUintptr
+	lookups  atomicUintptr
+	probes   atomicUintptr
+	maxProbe atomicUintptr
+	retries  atomicUintptr
+	enabled  bool // see EnableStats
+}
+
+// EnableStats maintains the counters reported by Stats, which are disabled by default as they cost atomic adds
+// shared by all goroutines on the hot paths of lookups and insertions
+// It cannot be disabled once enabled and must not be called concurrently with other operations on the map
+func (m *Map[K, V]) EnableStats() {
+	m.initialize()
+	m.stats.enabled = true
+}
+
+// Stats returns the operational statistics of the map
+// Only the fill rate is reported unless the counters were enabled with EnableStats, they can also be compiled out
+// with the haxmap_nostats build tag. Counters are read one by one hence they may be slightly inconsistent with each
+// other while the map is being modified
+func (m *Map[K, V]) Stats() Stats {
+	m.initialize()
+	return Stats{
+		Resizes:        m.stats.resizes.Load(),
+		Lookups:        m.stats.lookups.Load(),
+		ProbeSteps:     m.stats.probes.Load(),
+		MaxProbeLength: m.stats.maxProbe.Load(),
+		InsertRetries:  m.stats.retries.Load(),
+		FillRate:       m.Fillrate(),
+	}
+}
+
+// AverageProbeLength returns the average number of list elements visited by a lookup
+func (s Stats) AverageProbeLength() float64 {
+	if s.Lookups == 0 {
+		return 0
+	}
+	return float64(s.ProbeSteps) / float64(s.Lookups)
+}
+
+// probed records a lookup which visited the given number of list elements
+func (s *opStats) probed(steps uintptr) {
+	if !statsEnabled || !s.enabled {
+		return
+	}
+	s.lookups.Add(1)
+	s.probes.Add(steps)
+	for max := s.maxProbe.Load(); steps > max && !s.maxProbe.CompareAndSwap(max, steps); max = s.maxProbe.Load() {
+	}
+}
+
+// retried records an insertion restarted from the head of the list
+func (s *opStats) retried() {
+	if statsEnabled && s.enabled {
+		s.retries.Add(1)
+	}
+}
+
+// resized records a swap of the index
+func (s *opStats) resized() {
+	if statsEnabled && s.enabled {
+		s.resizes.Add(1)
+	}
+}
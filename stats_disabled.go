@@ -0,0 +1,6 @@
+//go:build haxmap_nostats
+
+package haxmap
+
+// statsEnabled tells whether the counters reported by Stats are maintained, see the haxmap_nostats build tag
+const statsEnabled = false
@@ -0,0 +1,524 @@
+package haxmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Stores opened by OpenStore keep their entries in an append-only log, fixed size integers being little endian
+//
+//	log header    "HAXL" | version byte | 3 zero bytes | generation as uint64
+//	record        crc32c of the rest of the record as uint32 | uvarint key length | uvarint value length + 1 | key | value
+//	deletion      a record whose value length + 1 is 0 and which has no value
+//
+// The log is mapped in memory and read through the mapping, records are appended with regular writes which the
+// unified page cache makes visible in the mapping. Closing a store checkpoints the index of the offsets of the live
+// records next to the log as follows so that it is loaded without scanning the log upon reopening
+//
+//	index header  "HAXI" | version byte | generation as uint64 | end of the log as uint64 | garbage as uint64 | crc32c
+//	index         snapshot of the offsets of the latest record of every live key, see WriteTo
+//
+// The generation identifies a log, it changes upon compaction so that the checkpoint of a replaced log is ignored
+const (
+	storeLogMagic     = "HAXL"
+	storeIndexMagic   = "HAXI"
+	storeVersion      = 1
+	storeHeaderSize   = 16
+	storeIndexSuffix  = ".index"
+	storeMinMapping   = 1 << 20 // initial size of the mapping, doubled whenever the log outgrows it
+	storeMaxRecordLen = 1 << 31 // upper bound of key and value lengths when scanning the log
+)
+
+var (
+	// ErrStoreClosed is returned by the methods of a Store which was closed
+	ErrStoreClosed = errors.New("haxmap: store is closed")
+
+	// ErrCorruptStore is returned by OpenStore if the header of the log is malformed
+	ErrCorruptStore = errors.New("haxmap: corrupt store")
+)
+
+// Store is a persistent map whose entries live in a memory-mapped append-only log file
+// The offsets of the live records are indexed by a Map hence lookups cost a map lookup and the decoding of the value
+// straight from the mapping, writes append a record and are serialized
+// Keys and values are encoded like within snapshots, see WriteTo
+// Writes are not durable until Sync or Close returns, if the process crashes the log is recovered up to its last
+// intact record upon reopening. Overwritten and deleted records are reclaimed by Compact
+type Store[K hashable, V any] struct {
+	path    string
+	writeMu sync.Mutex   // serializes writers, guards end, garbage, generation and the scratch buffers
+	mu      sync.RWMutex // guards file, data and index against remapping and compaction, held exclusively to swap them
+	file    *os.File
+	data    []byte // read-only mapping of the log, it may extend past the end of the file
+	index   *Map[K, uint64]
+	keys    binaryCodec[K]
+	values  binaryCodec[V]
+
+	end        uint64 // offset at which the next record is appended
+	garbage    uint64 // bytes of records overwritten or deleted
+	generation uint64
+	key, value []byte // scratch buffers of the encoded key and value
+	rec        []byte // scratch buffer of the record
+}
+
+// OpenStore opens the store persisted at path, creating it if the file does not exist
+// The index is loaded from the checkpoint written by Close if it matches the log, records appended after it are
+// replayed and a log left by a crash is truncated after its last intact record
+func OpenStore[K hashable, V any](path string) (*Store[K, V], error) {
+	s := &Store[K, V]{path: path, index: New[K, uint64]()}
+	var err error
+	if s.keys, err = newBinaryCodec[K](); err != nil {
+		return nil, err
+	}
+	if s.values, err = newBinaryCodec[V](); err != nil {
+		return nil, err
+	}
+	if s.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return nil, err
+	}
+	if err = s.open(); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open reads or writes the header of the log, maps it and recovers the index
+func (s *Store[K, V]) open() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := uint64(info.Size())
+	header := make([]byte, storeHeaderSize)
+	if size == 0 {
+		s.generation = randomSeed()
+		copy(header, storeLogMagic)
+		header[len(storeLogMagic)] = storeVersion
+		binary.LittleEndian.PutUint64(header[8:], s.generation)
+		if _, err = s.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+		if err = s.file.Sync(); err != nil {
+			return err
+		}
+		size = storeHeaderSize
+	} else {
+		if _, err = s.file.ReadAt(header, 0); err != nil {
+			if err == io.EOF {
+				return ErrCorruptStore
+			}
+			return err
+		}
+		if string(header[:len(storeLogMagic)]) != storeLogMagic {
+			return ErrCorruptStore
+		}
+		if version := header[len(storeLogMagic)]; version != storeVersion {
+			return fmt.Errorf("haxmap: unsupported store version %d", version)
+		}
+		s.generation = binary.LittleEndian.Uint64(header[8:])
+	}
+	if s.data, err = mapFile(s.file, mappingSize(size)); err != nil {
+		return err
+	}
+
+	s.end = storeHeaderSize
+	if !s.loadIndex(size) {
+		s.index, s.end, s.garbage = New[K, uint64](), storeHeaderSize, 0
+	}
+	for s.end < size {
+		key, _, deleted, n, ok := parseRecord(s.data[s.end:size], true)
+		if !ok {
+			break
+		}
+		var k K
+		if err = s.keys.decode(key, &k); err != nil {
+			return err
+		}
+		s.apply(k, s.end, n, deleted)
+		s.end += n
+	}
+	if s.end < size { // torn or corrupt tail
+		if err = s.file.Truncate(int64(s.end)); err != nil {
+			return err
+		}
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// loadIndex loads the checkpoint of the index if it matches the log, it returns false otherwise
+func (s *Store[K, V]) loadIndex(size uint64) bool {
+	f, err := os.Open(s.path + storeIndexSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, len(storeIndexMagic)+1+3*8+4)
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(storeIndexMagic)]) != storeIndexMagic ||
+		header[len(storeIndexMagic)] != storeVersion {
+		return false
+	}
+	fields := header[len(storeIndexMagic)+1:]
+	if crc32.Checksum(header[:len(header)-4], castagnoli) != binary.LittleEndian.Uint32(header[len(header)-4:]) {
+		return false
+	}
+	end, garbage := binary.LittleEndian.Uint64(fields[8:]), binary.LittleEndian.Uint64(fields[16:])
+	if binary.LittleEndian.Uint64(fields) != s.generation || end < storeHeaderSize || end > size {
+		return false
+	}
+	if _, err = s.index.ReadFrom(r); err != nil {
+		return false
+	}
+	s.end, s.garbage = end, garbage
+	return true
+}
+
+// Get returns the value stored for the key and whether it was found
+// An error is returned if the store is closed or if the record cannot be decoded
+func (s *Store[K, V]) Get(key K) (value V, ok bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return value, false, ErrStoreClosed
+	}
+	off, ok := s.index.Get(key)
+	if !ok {
+		return value, false, nil
+	}
+	_, encoded, _, _, _ := parseRecord(s.data[off:], false)
+	if err = s.values.decode(encoded, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Set appends a record of the key and value to the log and indexes it
+func (s *Store[K, V]) Set(key K, value V) (err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.key, err = s.keys.append(s.key[:0], key); err != nil {
+		return err
+	}
+	if s.value, err = s.values.append(s.value[:0], value); err != nil {
+		return err
+	}
+	return s.write(key, false)
+}
+
+// Del appends a deletion record of the key to the log if it is present
+func (s *Store[K, V]) Del(key K) (err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed() {
+		return ErrStoreClosed
+	}
+	if _, ok := s.index.Get(key); !ok {
+		return nil
+	}
+	if s.key, err = s.keys.append(s.key[:0], key); err != nil {
+		return err
+	}
+	return s.write(key, true)
+}
+
+// write appends a record of the encoded key and value in the scratch buffers to the log and applies it to the index
+// the mapping is grown before the index points to the record so that readers always find it mapped
+func (s *Store[K, V]) write(key K, deleted bool) error {
+	if s.closed() {
+		return ErrStoreClosed
+	}
+	s.rec = appendUvarint(append(s.rec[:0], 0, 0, 0, 0), uint64(len(s.key)))
+	if deleted {
+		s.rec = append(appendUvarint(s.rec, 0), s.key...)
+	} else {
+		s.rec = append(append(appendUvarint(s.rec, uint64(len(s.value))+1), s.key...), s.value...)
+	}
+	binary.LittleEndian.PutUint32(s.rec, crc32.Checksum(s.rec[4:], castagnoli))
+	if _, err := s.file.WriteAt(s.rec, int64(s.end)); err != nil {
+		return err
+	}
+	off, n := s.end, uint64(len(s.rec))
+	if off+n > uint64(len(s.data)) {
+		s.mu.Lock()
+		err := s.remap(mappingSize(off + n))
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	s.end += n
+	s.apply(key, off, n, deleted)
+	return nil
+}
+
+// apply indexes the record of n bytes at the offset and accounts for the records it makes obsolete
+func (s *Store[K, V]) apply(key K, off, n uint64, deleted bool) {
+	var (
+		prev  uint64
+		found bool
+	)
+	if deleted {
+		prev, found = s.index.GetAndDel(key)
+		s.garbage += n
+	} else {
+		prev, found = s.index.Swap(key, off)
+	}
+	if found {
+		_, _, _, size, _ := parseRecord(s.data[prev:], false)
+		s.garbage += size
+	}
+}
+
+// remap replaces the mapping of the log with a mapping of the given size, the caller holds mu exclusively
+func (s *Store[K, V]) remap(size uint64) error {
+	data, err := mapFile(s.file, size)
+	if err != nil {
+		return err
+	}
+	old := s.data
+	s.data = data
+	return unmapFile(old)
+}
+
+// Len returns the number of live entries of the store
+func (s *Store[K, V]) Len() uintptr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.Len()
+}
+
+// Garbage returns the number of bytes of the log taken by overwritten and deleted records, reclaimed by Compact
+func (s *Store[K, V]) Garbage() uint64 {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.garbage
+}
+
+// ForEach calls the lambda for every live entry until it returns false
+// The store may be modified by the lambda, entries set concurrently may or may not be visited
+func (s *Store[K, V]) ForEach(lambda func(K, V) bool) (err error) {
+	s.mu.RLock()
+	index, closed := s.index, s.data == nil
+	s.mu.RUnlock()
+	if closed {
+		return ErrStoreClosed
+	}
+	index.ForEach(func(key K, _ uint64) bool {
+		var (
+			value V
+			ok    bool
+		)
+		if value, ok, err = s.Get(key); err != nil {
+			return false
+		}
+		return !ok || lambda(key, value)
+	})
+	return err
+}
+
+// Sync flushes the log to stable storage
+func (s *Store[K, V]) Sync() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed() {
+		return ErrStoreClosed
+	}
+	return s.file.Sync()
+}
+
+// Compact rewrites the live records into a new log which atomically replaces the current one and checkpoints its
+// index, reads proceed while the new log is written but writes wait for the compaction to complete
+func (s *Store[K, V]) Compact() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed() {
+		return ErrStoreClosed
+	}
+
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	generation := randomSeed()
+	w := bufio.NewWriter(f)
+	header := make([]byte, storeHeaderSize)
+	copy(header, storeLogMagic)
+	header[len(storeLogMagic)] = storeVersion
+	binary.LittleEndian.PutUint64(header[8:], generation)
+	w.Write(header)
+	index, end := NewLike(s.index), uint64(storeHeaderSize)
+	s.mu.RLock()
+	s.index.ForEach(func(key K, off uint64) bool {
+		_, _, _, n, _ := parseRecord(s.data[off:], false)
+		if _, err = w.Write(s.data[off : off+n]); err != nil {
+			return false
+		}
+		index.Set(key, end)
+		end += n
+		return true
+	})
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	data, err := mapFile(f, mappingSize(end))
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		unmapFile(data)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+
+	s.mu.Lock()
+	old, oldData := s.file, s.data
+	s.file, s.data, s.index = f, data, index
+	s.mu.Unlock()
+	s.end, s.garbage, s.generation = end, 0, generation
+	unmapFile(oldData)
+	old.Close()
+	return s.checkpoint()
+}
+
+// Close flushes the log, checkpoints the index and releases the file
+func (s *Store[K, V]) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed() {
+		return ErrStoreClosed
+	}
+	err := s.file.Sync()
+	if err == nil {
+		err = s.checkpoint()
+	}
+	s.mu.Lock()
+	data, file := s.data, s.file
+	s.data = nil
+	s.mu.Unlock()
+	if unmapErr := unmapFile(data); err == nil {
+		err = unmapErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// checkpoint writes the index next to the log, replacing the previous checkpoint atomically
+// the caller holds writeMu and the records covered by the index are synced
+func (s *Store[K, V]) checkpoint() (err error) {
+	tmp := s.path + storeIndexSuffix + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	header := append([]byte(storeIndexMagic), storeVersion)
+	for _, field := range [...]uint64{s.generation, s.end, s.garbage} {
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(header[len(header)-8:], field)
+	}
+	header = append(header, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(header[len(header)-4:], crc32.Checksum(header[:len(header)-4], castagnoli))
+	w := bufio.NewWriter(f)
+	w.Write(header)
+	if _, err = s.index.WriteTo(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path+storeIndexSuffix); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+	return nil
+}
+
+// closed reports whether the store was closed, the caller holds writeMu
+func (s *Store[K, V]) closed() bool {
+	return s.data == nil
+}
+
+// parseRecord parses the record at the start of buf and returns its key, its value, whether it is a deletion and
+// its size, verifying its checksum if asked to, ok is false if the record is truncated or corrupt
+func parseRecord(buf []byte, verify bool) (key, value []byte, deleted bool, size uint64, ok bool) {
+	if len(buf) < 4 {
+		return
+	}
+	keyLen, n := binary.Uvarint(buf[4:])
+	if n <= 0 || keyLen > storeMaxRecordLen {
+		return
+	}
+	off := uint64(4 + n)
+	valueLen, n := binary.Uvarint(buf[off:])
+	if n <= 0 || valueLen > storeMaxRecordLen+1 {
+		return
+	}
+	off += uint64(n)
+	deleted = valueLen == 0
+	if !deleted {
+		valueLen--
+	}
+	size = off + keyLen + valueLen
+	if size > uint64(len(buf)) {
+		return nil, nil, false, 0, false
+	}
+	if verify && crc32.Checksum(buf[4:size], castagnoli) != binary.LittleEndian.Uint32(buf) {
+		return nil, nil, false, 0, false
+	}
+	return buf[off : off+keyLen], buf[off+keyLen : size], deleted, size, true
+}
+
+// mappingSize returns the size of the mapping of a log of the given size, a power of 2 leaving room to grow
+func mappingSize(size uint64) uint64 {
+	mapping := uint64(storeMinMapping)
+	for mapping < size {
+		mapping <<= 1
+	}
+	return mapping
+}
+
+// syncDir flushes a directory so that renames within it are durable, it is best effort as not every platform
+// supports syncing directories
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,45 @@
+package haxmap
+
+import "context"
+
+// Emit pushes the key-value pairs of the map to fn one at a time until fn returns an error or ctx is done
+// returns the error of fn or the error of the context, nil if all pairs were emitted
+// Like ForEach, the list is traversed in a single weakly consistent pass, fn is called synchronously hence a slow
+// consumer slows the traversal down instead of pairs piling up in a buffer
+func (m *Map[K, V]) Emit(ctx context.Context, fn func(K, V) error) error {
+	m.initialize()
+	done := ctx.Done()
+	for item := m.skipExpired(m.listHead.next()); item != nil; item = m.skipExpired(item.next()) {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+		if err := fn(item.key, *m.valueOf(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream sends the key-value pairs of the map on the returned channel from a new goroutine, see Emit
+// The channel is unbuffered hence the traversal advances only as fast as the pairs are received, which applies
+// backpressure to the producer when feeding a pipeline or several workers receiving from the same channel
+// The channel is closed once all pairs were sent or as soon as ctx is done, cancel ctx when not draining the channel
+// to release the goroutine
+func (m *Map[K, V]) Stream(ctx context.Context) <-chan Pair[K, V] {
+	m.initialize()
+	pairs := make(chan Pair[K, V])
+	go m.labeled("stream", func() {
+		defer close(pairs)
+		_ = m.Emit(ctx, func(key K, value V) error {
+			select {
+			case pairs <- Pair[K, V]{Key: key, Value: value}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	})
+	return pairs
+}
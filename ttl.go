@@ -0,0 +1,187 @@
+package haxmap
+
+import "time"
+
+// indicates whether entries with an expiry were stored enums
+const (
+	noExpiries uint32 = iota
+	hasExpiries
+)
+
+// SetWithTTL inserts or updates an element which expires after the given ttl, a ttl of 0 or less disables expiry
+// Expired entries are treated as absent by lookups and iterations, they are removed lazily once encountered
+// or by DeleteExpired, hence Len counts expired entries which were not removed yet
+// A later Set, SetMany or TrySet clears the expiry while updates of the value in place like Swap, Compute
+// or CompareAndSwap keep it
+func (m *Map[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	m.initialize()
+	var exp expiration
+	if ttl > 0 {
+		exp = expiration{at: m.clock() + int64(ttl), ttl: int64(ttl)}
+		if m.expiries.Load() == noExpiries {
+			m.expiries.Store(hasExpiries)
+		}
+	}
+	m.set(key, value, nil, exp)
+}
+
+// SetSlidingExpiration enables or disables sliding expiration, disabled by default
+// When enabled, every access to an entry by its key, like Get or Compute, extends its expiry to the current time
+// plus the ttl it was stored with, so that entries expire once they were not accessed for their ttl
+// Iterations do not extend expiries, neither do accesses to entries stored without ttl
+// This must not be called concurrently with other operations on the map
+func (m *Map[K, V]) SetSlidingExpiration(sliding bool) {
+	m.initialize()
+	m.sliding = sliding
+}
+
+// GetRefresh retrieves an element from the map and extends its expiry to the current time plus the ttl
+// it was stored with, whether sliding expiration is enabled or not
+// returns `false` if the element is absent or expired, the loader is not consulted upon a miss
+func (m *Map[K, V]) GetRefresh(key K) (value V, ok bool) {
+	m.initialize()
+	if elem := m.probe(key); elem != nil {
+		if !m.sliding { // else refreshed by the lookup already
+			elem.touch(m.clock())
+		}
+		return *m.valueOf(elem), true
+	}
+	return
+}
+
+// DeleteExpired removes all expired entries by traversing the list once and returns their number
+func (m *Map[K, V]) DeleteExpired() (removed int) {
+	m.initialize()
+	if m.expiries.Load() == noExpiries {
+		return
+	}
+	now := m.clock()
+	for item := m.listHead.next(); item != nil; item = item.next() {
+		if m.expire(item, now) {
+			removed++
+		}
+	}
+	return
+}
+
+// SetJanitor configures the background goroutine removing expired entries, replacing a running one
+// Every interval it sweeps over up to maxPerSweep entries, resuming where the previous sweep stopped, so that
+// a sweep pauses writers for a bounded time, a maxPerSweep of 0 or less sweeps the whole list at once
+// An interval of 0 or less disables the janitor, which is the default, and expired entries are then only removed
+// lazily by lookups and iterations or by calling DeleteExpired
+func (m *Map[K, V]) SetJanitor(interval time.Duration, maxPerSweep int) {
+	m.initialize()
+	m.janitorMu.Lock()
+	defer m.janitorMu.Unlock()
+	if m.janitor != nil {
+		close(m.janitor)
+		m.janitor = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	m.janitor = stop
+	go m.labeled("janitor", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if maxPerSweep > 0 {
+					m.sweep(maxPerSweep)
+				} else {
+					m.DeleteExpired()
+				}
+			case <-stop:
+				return
+			}
+		}
+	})
+}
+
+// StartJanitor starts a background goroutine calling DeleteExpired at every interval until StopJanitor is called
+// A running janitor is replaced, see SetJanitor to bound the work done per sweep
+func (m *Map[K, V]) StartJanitor(interval time.Duration) {
+	m.SetJanitor(interval, 0)
+}
+
+// StopJanitor stops the background goroutine started by StartJanitor or SetJanitor if any
+func (m *Map[K, V]) StopJanitor() {
+	m.SetJanitor(0, 0)
+}
+
+// sweep visits up to max entries starting from the hash where the previous sweep stopped and removes the expired ones
+// it returns the number of removed entries, the next sweep starts over from the head once the end of the list is reached
+func (m *Map[K, V]) sweep(max int) (removed int) {
+	if m.expiries.Load() == noExpiries {
+		return
+	}
+	var (
+		now  = m.clock()
+		from = m.sweepFrom.Load()
+		item = m.metadata.Load().indexElement(from)
+	)
+	if item == nil || item.keyHash > from {
+		item = m.listHead.next()
+	}
+	for ; item != nil && item.keyHash < from; item = item.next() {
+	}
+	for visited := 0; item != nil && visited < max; item, visited = item.next(), visited+1 {
+		if m.expire(item, now) {
+			removed++
+		}
+	}
+	if item == nil {
+		m.sweepFrom.Store(0)
+	} else {
+		m.sweepFrom.Store(item.keyHash) // first hash not visited yet
+	}
+	return
+}
+
+// live reports whether the element holds an entry which has not expired yet
+// an expired element is removed from the map on the spot
+func (m *Map[K, V]) live(elem *element[K, V]) bool {
+	if m.expiries.Load() == noExpiries {
+		return true
+	}
+	now := m.clock()
+	if m.expire(elem, now) || elem.expired(now) {
+		return false
+	}
+	if m.sliding {
+		elem.touch(now)
+	}
+	return true
+}
+
+// skipExpired returns the first element from item onwards which has not expired yet, nil if there is none
+// expired elements passed over are removed from the map
+func (m *Map[K, V]) skipExpired(item *element[K, V]) *element[K, V] {
+	if m.expiries.Load() == noExpiries {
+		return item
+	}
+	for now := m.clock(); item != nil && item.expired(now); item = item.next() {
+		m.expire(item, now)
+	}
+	return item
+}
+
+// expire removes the element if it expired at the given time and reports whether this call removed it
+// exactly one caller removes an expired element and notifies the eviction callback, see OnEvict
+func (m *Map[K, V]) expire(item *element[K, V], now int64) bool {
+	if !item.claimExpired(now) || !item.remove() {
+		return false
+	}
+	m.removeItemFromIndex(item)
+	if m.onEvict != nil {
+		m.onEvict(item.key, *m.valueOf(item), ReasonExpired)
+	}
+	return true
+}
+
+// unixNano is the default clock of a map
+func unixNano() int64 {
+	return time.Now().UnixNano()
+}
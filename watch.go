@@ -0,0 +1,141 @@
+package haxmap
+
+import "sync"
+
+// watchBuffer is the capacity of the channels returned by WatchAll
+const watchBuffer = 64
+
+type (
+	// watchers of a map, created upon the first call of Watch or WatchAll
+	watchers[K hashable, V any] struct {
+		mu   sync.RWMutex
+		keys map[K][]*subscriber[V]
+		all  []*subscriber[Pair[K, V]]
+	}
+
+	// subscriber delivers values to a channel without ever blocking the writers of the map
+	// when the channel is full the oldest value is dropped to make room for the newest one
+	subscriber[T any] struct {
+		mu     sync.Mutex
+		ch     chan T
+		closed bool
+	}
+)
+
+// Watch returns a channel receiving the values stored for the key by subsequent insertions and updates, and a
+// function cancelling the subscription which closes the channel
+// Writers never block on subscribers: the channel holds the latest value only, a subscriber falling behind misses
+// intermediate values but the last value received is the current value of the key once writes settle
+// Deletions are not delivered and keys are compared with == whatever the key equality of the map
+func (m *Map[K, V]) Watch(key K) (<-chan V, func()) {
+	m.initialize()
+	w := m.subscribers()
+	s := &subscriber[V]{ch: make(chan V, 1)}
+	w.mu.Lock()
+	w.keys[key] = append(w.keys[key], s)
+	w.mu.Unlock()
+	return s.ch, func() {
+		w.mu.Lock()
+		subs := w.keys[key]
+		for idx := range subs {
+			if subs[idx] == s {
+				subs = append(subs[:idx:idx], subs[idx+1:]...)
+				break
+			}
+		}
+		if len(subs) == 0 {
+			delete(w.keys, key)
+		} else {
+			w.keys[key] = subs
+		}
+		w.mu.Unlock()
+		s.close()
+	}
+}
+
+// WatchAll returns a channel receiving the pairs stored by subsequent insertions and updates of any key, and a
+// function cancelling the subscription which closes the channel
+// Writers never block on subscribers: the channel buffers a bounded number of pairs and drops the oldest ones once
+// full, the value of a pair is the current value of its key when it was delivered
+// Deletions are not delivered
+func (m *Map[K, V]) WatchAll() (<-chan Pair[K, V], func()) {
+	m.initialize()
+	w := m.subscribers()
+	s := &subscriber[Pair[K, V]]{ch: make(chan Pair[K, V], watchBuffer)}
+	w.mu.Lock()
+	w.all = append(w.all, s)
+	w.mu.Unlock()
+	return s.ch, func() {
+		w.mu.Lock()
+		for idx := range w.all {
+			if w.all[idx] == s {
+				w.all = append(w.all[:idx:idx], w.all[idx+1:]...)
+				break
+			}
+		}
+		w.mu.Unlock()
+		s.close()
+	}
+}
+
+// subscribers returns the watchers of the map, creating them if needed
+func (m *Map[K, V]) subscribers() *watchers[K, V] {
+	if w := m.watchers.Load(); w != nil {
+		return w
+	}
+	m.watchers.CompareAndSwap(nil, &watchers[K, V]{keys: make(map[K][]*subscriber[V])})
+	return m.watchers.Load()
+}
+
+// publish delivers the current value of a written element to the subscribers of its key
+// the value is read again under the lock of every subscriber so that concurrent writes of the key are never
+// delivered out of order, an element deleted or claimed for deletion in between is skipped, see Map.removeIf
+func (w *watchers[K, V]) publish(elem *element[K, V], tomb *V) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, s := range w.keys[elem.key] {
+		s.send(func() (V, bool) {
+			value := elem.value.Load()
+			return *value, value != tomb && !elem.isDeleted()
+		})
+	}
+	for _, s := range w.all {
+		s.send(func() (Pair[K, V], bool) {
+			value := elem.value.Load()
+			return Pair[K, V]{Key: elem.key, Value: *value}, value != tomb && !elem.isDeleted()
+		})
+	}
+}
+
+// send delivers the value returned by current if any, dropping the oldest buffered value if the channel is full
+func (s *subscriber[T]) send(current func() (T, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	value, ok := current()
+	if !ok {
+		return
+	}
+	for {
+		select {
+		case s.ch <- value:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+func (s *subscriber[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
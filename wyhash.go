@@ -0,0 +1,61 @@
+package haxmap
+
+/*
+Port of wyhash final version 4 from https://github.com/wangyi-fudan/wyhash
+
+This is free and unencumbered software released into the public domain under The Unlicense (http://unlicense.org/)
+*/
+
+import "math/bits"
+
+// default secret parameters of wyhash
+const (
+	wyp0 uint64 = 0xa0761d6478bd642f
+	wyp1 uint64 = 0xe7037ed1a0b428db
+	wyp2 uint64 = 0x8ebc6af09c88c6e3
+	wyp3 uint64 = 0x589965cc75374cc3
+)
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func wyr3(b []byte, n int) uint64 {
+	return uint64(b[0])<<16 | uint64(b[n>>1])<<8 | uint64(b[n-1])
+}
+
+// wyHash hashes a byte slice of any size
+func wyHash(b []byte, seed uint64) uintptr {
+	var (
+		n    = len(b)
+		x, y uint64
+	)
+	seed ^= wymix(seed^wyp0, wyp1)
+	if n <= 16 {
+		if n >= 4 {
+			x = uint64(u32(b))<<32 | uint64(u32(b[(n>>3)<<2:]))
+			y = uint64(u32(b[n-4:]))<<32 | uint64(u32(b[n-4-((n>>3)<<2):]))
+		} else if n > 0 {
+			x = wyr3(b, n)
+		}
+	} else {
+		// p is the offset of the unconsumed input, the last 16 bytes are always read from the full slice
+		p, i := 0, n
+		if i >= 48 {
+			see1, see2 := seed, seed
+			for ; i >= 48; p, i = p+48, i-48 {
+				seed = wymix(u64(b[p:])^wyp1, u64(b[p+8:])^seed)
+				see1 = wymix(u64(b[p+16:])^wyp2, u64(b[p+24:])^see1)
+				see2 = wymix(u64(b[p+32:])^wyp3, u64(b[p+40:])^see2)
+			}
+			seed ^= see1 ^ see2
+		}
+		for ; i > 16; p, i = p+16, i-16 {
+			seed = wymix(u64(b[p:])^wyp1, u64(b[p+8:])^seed)
+		}
+		x, y = u64(b[p+i-16:]), u64(b[p+i-8:])
+	}
+	hi, lo := bits.Mul64(x^wyp1, y^seed)
+	return uintptr(wymix(lo^wyp0^uint64(n), hi^wyp1))
+}